@@ -0,0 +1,120 @@
+// Command matrix runs the e2e suite once per combination of the kind node images and component
+// image tags of a matrix config, and writes the results of all the runs to one comparison report.
+//
+//	go run ./cmd/matrix -config examples/matrix.yaml
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/e2e-framework/support/kind"
+
+	"github.com/morvencao/maestro-e2e/utils/matrix"
+)
+
+func main() {
+	configPath := flag.String("config", "matrix.yaml", "path of the matrix config")
+	outputDir := flag.String("output", "_output/matrix", "directory of the report and of the go test output of every run")
+	pkg := flag.String("pkg", "./e2e", "package of the suite")
+	run := flag.String("run", "", "run only the tests matching the regular expression, passed to go test -run")
+	timeout := flag.Duration("timeout", time.Hour, "timeout of a run, passed to go test -timeout")
+	flag.Parse()
+
+	config, err := matrix.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	report := &matrix.Report{}
+	combinations := config.Combinations()
+	for i, combination := range combinations {
+		fmt.Printf("run #%d/%d: %s\n", i+1, len(combinations), combination.Name())
+		result := runSuite(combination, config.ReuseClusters, *pkg, *run, *timeout, filepath.Join(*outputDir, fmt.Sprintf("run-%d.json", i+1)))
+		fmt.Printf("run #%d: %d passed, %d failed, %d skipped in %.0fs %s\n", i+1,
+			result.Count(matrix.OutcomePass), result.Count(matrix.OutcomeFail), result.Count(matrix.OutcomeSkip), result.Duration, result.Error)
+		report.Results = append(report.Results, result)
+	}
+
+	if config.ReuseClusters {
+		for _, nodeImage := range config.NodeImages {
+			name := matrix.ClusterName(nodeImage)
+			if err := kind.NewCluster(name).Destroy(context.Background()); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to delete the kind cluster %s: %v\n", name, err)
+			}
+		}
+	}
+
+	reportPath := filepath.Join(*outputDir, "report.json")
+	if err := report.WriteFile(reportPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n%s\nreport written to %s\n", report.FormatTable(), reportPath)
+
+	for _, result := range report.Results {
+		if result.Error != "" || result.Count(matrix.OutcomeFail) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// runSuite runs the suite against the combination and writes the go test output to the log path
+func runSuite(combination matrix.Combination, reuseCluster bool, pkg, run string, timeout time.Duration, logPath string) matrix.Result {
+	result := matrix.Result{Combination: combination, Tests: map[string]string{}}
+
+	log, err := os.Create(logPath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer log.Close()
+
+	args := []string{"test", "-json", "-count=1", "-timeout", timeout.String(), pkg}
+	if run != "" {
+		args = append(args, "-run", run)
+	}
+
+	// the components are always uninstalled, the cluster is deleted unless it is reused by the next run
+	env := append(os.Environ(), "CLEAN_ENV=true", "REAL_CLUSTER=false")
+	env = append(env, combination.Env()...)
+	if reuseCluster {
+		env = append(env, fmt.Sprintf("%s=%s", matrix.ClusterNameEnv, matrix.ClusterName(combination.NodeImage)))
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Env = env
+	cmd.Stdout = io.MultiWriter(&output, log)
+	cmd.Stderr = io.MultiWriter(os.Stderr, log)
+
+	start := time.Now()
+	runErr := cmd.Run()
+	result.Duration = time.Since(start).Seconds()
+
+	result.Tests, err = matrix.ParseTestEvents(&output)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// go test exits with an error when a test fails, it is only an error of the run when no test ran,
+	// e.g. the cluster could not be created in TestMain
+	if runErr != nil && len(result.Tests) == 0 {
+		result.Error = fmt.Sprintf("%v, see %s", runErr, logPath)
+	}
+	return result
+}
@@ -0,0 +1,392 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+)
+
+// the benchmarks can't reach the context of the test environment, they share a
+// connection and a consumer of their own, so they also run with `-run '^$'`. They
+// measure maestro, so they are skipped against the fake maestro
+var (
+	benchOnce       sync.Once
+	benchConn       *grpc.ClientConn
+	benchHttpClient *http.Client
+	benchConsumerID string
+	benchErr        error
+
+	// benchRunID and benchSeq name the bench resources, the names are unique across the rounds and
+	// the variants of the benchmarks and across the runs
+	benchRunID string
+	benchSeq   int64
+)
+
+func setupBench(b *testing.B) (*grpc.ClientConn, *http.Client, string) {
+	if os.Getenv("FAKE_MAESTRO") == "true" {
+		b.Skip("the benchmarks measure maestro, they do not run against the fake maestro")
+	}
+
+	benchOnce.Do(func() {
+		benchRunID = envconf.RandomName("bench", 10)
+		benchConn, benchErr = grpc.Dial(maestroGPRCBaseURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if benchErr != nil {
+			return
+		}
+
+		benchHttpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 100,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+
+		var pbConsumer *maestropbv1.Consumer
+		pbConsumer, benchErr = maestropbv1.NewConsumerServiceClient(benchConn).Create(context.Background(), &maestropbv1.ConsumerCreateRequest{
+			Labels: []*maestropbv1.ConsumerLabel{{Key: "maestro-e2e/bench", Value: "true"}},
+		})
+		if benchErr != nil {
+			return
+		}
+		benchConsumerID = pbConsumer.Id
+	})
+
+	if benchErr != nil {
+		b.Fatal(benchErr)
+	}
+
+	return benchConn, benchHttpClient, benchConsumerID
+}
+
+// benchRTT runs the operation serially and in parallel, reporting allocations and the mean round trip time
+func benchRTT(b *testing.B, op func(ctx context.Context, i int64) error) {
+	b.Run("serial", func(b *testing.B) {
+		ctx := context.Background()
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		var total time.Duration
+		for i := 0; i < b.N; i++ {
+			start := time.Now()
+			if err := op(ctx, int64(i)); err != nil {
+				b.Fatal(err)
+			}
+			total += time.Since(start)
+		}
+		b.ReportMetric(float64(total.Microseconds())/float64(b.N), "rtt-us/op")
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		ctx := context.Background()
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		var total, seq int64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				start := time.Now()
+				if err := op(ctx, atomic.AddInt64(&seq, 1)); err != nil {
+					b.Error(err)
+					return
+				}
+				atomic.AddInt64(&total, int64(time.Since(start)))
+			}
+		})
+		b.ReportMetric(float64(time.Duration(total).Microseconds())/float64(b.N), "rtt-us/op")
+	})
+}
+
+// benchName returns a unique name of a bench resource
+func benchName(prefix string) string {
+	return fmt.Sprintf("%s-%s-%d", prefix, benchRunID, atomic.AddInt64(&benchSeq, 1))
+}
+
+// benchResources are the resources a benchmark creates, they are deleted once the benchmark completes
+type benchResources struct {
+	mu      sync.Mutex
+	objects map[string]*unstructured.Unstructured
+}
+
+func trackBenchResources(b *testing.B, conn *grpc.ClientConn) *benchResources {
+	tracked := &benchResources{objects: map[string]*unstructured.Unstructured{}}
+	b.Cleanup(func() {
+		grpcClient := maestropbv1.NewResourceServiceClient(conn)
+		tracked.mu.Lock()
+		defer tracked.mu.Unlock()
+		for id, obj := range tracked.objects {
+			objStruct, err := toStruct(markDeleted(obj))
+			if err == nil {
+				_, err = grpcClient.Update(context.Background(), &maestropbv1.ResourceUpdateRequest{Id: id, Object: objStruct})
+			}
+			if err != nil {
+				b.Errorf("failed to delete bench resource %s: %v", id, err)
+			}
+		}
+	})
+	return tracked
+}
+
+func (r *benchResources) add(id string, obj *unstructured.Unstructured) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.objects[id] = obj
+}
+
+// newBenchConfigMap returns a configmap, cheap for the work-agent to apply
+func newBenchConfigMap(name string, i int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+			},
+			"data": map[string]interface{}{
+				"iteration": fmt.Sprintf("%d", i),
+			},
+		},
+	}
+}
+
+// newBenchCloudEvent returns a manifest create request for a new resource and tracks the resource
+func newBenchCloudEvent(tracked *benchResources, consumerID, prefix string, i int64) (*cloudevents.Event, error) {
+	builder := manifestevent.NewBuilder(consumerID)
+	obj := newBenchConfigMap(benchName(prefix), i)
+	evt, err := builder.Build(manifestevent.ActionCreate, obj)
+	if err != nil {
+		return nil, err
+	}
+	tracked.add(builder.ResourceID(), obj)
+	return evt, nil
+}
+
+func doBenchRequest(httpClient *http.Client, method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected status code %d, got %d: %s", http.StatusOK, resp.StatusCode, respBody)
+	}
+
+	return respBody, nil
+}
+
+func BenchmarkResourceGRPCCreate(b *testing.B) {
+	conn, _, consumer := setupBench(b)
+	grpcClient := maestropbv1.NewResourceServiceClient(conn)
+	tracked := trackBenchResources(b, conn)
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		obj := newBenchConfigMap(benchName("bench-grpc-create"), i)
+		objStruct, err := toStruct(obj)
+		if err != nil {
+			return err
+		}
+		pbResource, err := grpcClient.Create(ctx, &maestropbv1.ResourceCreateRequest{ConsumerId: consumer, Object: objStruct})
+		if err != nil {
+			return err
+		}
+		tracked.add(pbResource.Id, obj)
+		return nil
+	})
+}
+
+func BenchmarkResourceGRPCUpdate(b *testing.B) {
+	conn, _, consumer := setupBench(b)
+	grpcClient := maestropbv1.NewResourceServiceClient(conn)
+	tracked := trackBenchResources(b, conn)
+
+	name := benchName("bench-grpc-update")
+	obj := newBenchConfigMap(name, 0)
+	objStruct, err := toStruct(obj)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pbResource, err := grpcClient.Create(context.Background(), &maestropbv1.ResourceCreateRequest{ConsumerId: consumer, Object: objStruct})
+	if err != nil {
+		b.Fatal(err)
+	}
+	tracked.add(pbResource.Id, obj)
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		objStruct, err := toStruct(newBenchConfigMap(name, i))
+		if err != nil {
+			return err
+		}
+		_, err = grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: pbResource.Id, Object: objStruct})
+		return err
+	})
+}
+
+func BenchmarkResourceGRPCRead(b *testing.B) {
+	conn, _, consumer := setupBench(b)
+	grpcClient := maestropbv1.NewResourceServiceClient(conn)
+	tracked := trackBenchResources(b, conn)
+
+	name := benchName("bench-grpc-read")
+	obj := newBenchConfigMap(name, 0)
+	objStruct, err := toStruct(obj)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pbResource, err := grpcClient.Create(context.Background(), &maestropbv1.ResourceCreateRequest{ConsumerId: consumer, Object: objStruct})
+	if err != nil {
+		b.Fatal(err)
+	}
+	tracked.add(pbResource.Id, obj)
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		_, err := grpcClient.Read(ctx, &maestropbv1.ResourceReadRequest{Id: pbResource.Id})
+		return err
+	})
+}
+
+func BenchmarkCloudEventsGRPCSend(b *testing.B) {
+	conn, _, consumer := setupBench(b)
+	grpcClient := maestropbv1.NewCloudEventsServiceClient(conn)
+	tracked := trackBenchResources(b, conn)
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		evt, err := newBenchCloudEvent(tracked, consumer, "bench-grpc-send", i)
+		if err != nil {
+			return err
+		}
+		pbEvt, err := cepbv2.ToProto(evt)
+		if err != nil {
+			return err
+		}
+		_, err = grpcClient.Send(ctx, pbEvt)
+		return err
+	})
+}
+
+func BenchmarkResourceRESTCreate(b *testing.B) {
+	conn, httpClient, consumer := setupBench(b)
+	tracked := trackBenchResources(b, conn)
+	requestURL := fmt.Sprintf("%s/%s/%s/%s", maestroRESTBaseURL, "v1/consumers", consumer, "resources")
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		obj := newBenchConfigMap(benchName("bench-rest-create"), i)
+		body, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		respBody, err := doBenchRequest(httpClient, http.MethodPost, requestURL, "application/json", body)
+		if err != nil {
+			return err
+		}
+		resource := &maestropbv1.Resource{}
+		if err := protojson.Unmarshal(respBody, resource); err != nil {
+			return err
+		}
+		tracked.add(resource.Id, obj)
+		return nil
+	})
+}
+
+func BenchmarkResourceRESTUpdate(b *testing.B) {
+	conn, httpClient, consumer := setupBench(b)
+	name := benchName("bench-rest-update")
+	resourceID := createBenchRESTResource(b, httpClient, trackBenchResources(b, conn), consumer, name)
+	requestURL := fmt.Sprintf("%s/%s/%s", maestroRESTBaseURL, "v1/resources", resourceID)
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		body, err := json.Marshal(newBenchConfigMap(name, i))
+		if err != nil {
+			return err
+		}
+		_, err = doBenchRequest(httpClient, http.MethodPut, requestURL, "application/json", body)
+		return err
+	})
+}
+
+func BenchmarkResourceRESTRead(b *testing.B) {
+	conn, httpClient, consumer := setupBench(b)
+	name := benchName("bench-rest-read")
+	resourceID := createBenchRESTResource(b, httpClient, trackBenchResources(b, conn), consumer, name)
+	requestURL := fmt.Sprintf("%s/%s/%s", maestroRESTBaseURL, "v1/resources", resourceID)
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		_, err := doBenchRequest(httpClient, http.MethodGet, requestURL, "", nil)
+		return err
+	})
+}
+
+func BenchmarkCloudEventsRESTSend(b *testing.B) {
+	conn, httpClient, consumer := setupBench(b)
+	tracked := trackBenchResources(b, conn)
+	requestURL := fmt.Sprintf("%s/%s", maestroRESTBaseURL, "v1/cloudevents")
+
+	benchRTT(b, func(ctx context.Context, i int64) error {
+		evt, err := newBenchCloudEvent(tracked, consumer, "bench-rest-send", i)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		_, err = doBenchRequest(httpClient, http.MethodPost, requestURL, "application/x-cloudevents", body)
+		return err
+	})
+}
+
+func createBenchRESTResource(b *testing.B, httpClient *http.Client, tracked *benchResources, consumer, name string) string {
+	requestURL := fmt.Sprintf("%s/%s/%s/%s", maestroRESTBaseURL, "v1/consumers", consumer, "resources")
+	obj := newBenchConfigMap(name, 0)
+	body, err := json.Marshal(obj)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	respBody, err := doBenchRequest(httpClient, http.MethodPost, requestURL, "application/json", body)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	resource := &maestropbv1.Resource{}
+	if err := protojson.Unmarshal(respBody, resource); err != nil {
+		b.Fatal(err)
+	}
+	tracked.add(resource.Id, obj)
+
+	return resource.Id
+}
@@ -0,0 +1,146 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+// chaosTarget is a component deployment that the chaos helpers can disrupt,
+// all components label their pods with `app: <deployment name>`
+type chaosTarget struct {
+	Name      string
+	Namespace string
+}
+
+var (
+	brokerTarget    = chaosTarget{Name: "mosquitto", Namespace: "mqtt"}
+	maestroTarget   = chaosTarget{Name: "maestro-api", Namespace: "maestro"}
+	workAgentTarget = chaosTarget{Name: "work-agent", Namespace: "open-cluster-management-agent"}
+	dynamodbTarget  = chaosTarget{Name: "dynamodb", Namespace: "dynamodb"}
+)
+
+func (c chaosTarget) String() string {
+	return fmt.Sprintf("%s/%s", c.Namespace, c.Name)
+}
+
+// scaleComponent scales the component deployment to the given replicas and waits for the pods to settle
+func scaleComponent(ctx context.Context, cfg *envconf.Config, target chaosTarget, replicas int32) error {
+	var dep appsv1.Deployment
+	if err := cfg.Client().Resources().Get(ctx, target.Name, target.Namespace, &dep); err != nil {
+		return err
+	}
+
+	dep.Spec.Replicas = &replicas
+	if err := cfg.Client().Resources().Update(ctx, &dep); err != nil {
+		return err
+	}
+
+	if replicas == 0 {
+		return waitForComponentPodsGone(cfg, target)
+	}
+
+	return waitForComponentReady(cfg, target)
+}
+
+// killComponent deletes the component pods without grace period and waits for the replacement pods to be ready
+func killComponent(ctx context.Context, cfg *envconf.Config, target chaosTarget) error {
+	pods := &corev1.PodList{}
+	if err := cfg.Client().Resources(target.Namespace).List(ctx, pods, resources.WithLabelSelector("app="+target.Name)); err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		if err := cfg.Client().Resources().Delete(ctx, &pods.Items[i], resources.WithGracePeriod(0)); err != nil {
+			return err
+		}
+	}
+
+	// wait for the killed pods to go away before checking the deployment, otherwise
+	// the old pods may still be reported as ready
+	if err := wait.For(conditions.New(cfg.Client().Resources()).ResourcesDeleted(pods), wait.WithTimeout(time.Minute*2)); err != nil {
+		return err
+	}
+
+	return waitForComponentReady(cfg, target)
+}
+
+func waitForComponentReady(cfg *envconf.Config, target chaosTarget) error {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: target.Name, Namespace: target.Namespace},
+	}
+
+	return wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(dep, func(object k8s.Object) bool {
+		d := object.(*appsv1.Deployment)
+		return d.Spec.Replicas != nil && *d.Spec.Replicas > 0 && d.Status.ReadyReplicas == *d.Spec.Replicas
+	}), wait.WithTimeout(time.Minute*2))
+}
+
+// waitForComponentRollout waits for the rollout of the component deployment to complete, otherwise
+// the old pod is still reported as ready
+func waitForComponentRollout(cfg *envconf.Config, target chaosTarget) error {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: target.Name, Namespace: target.Namespace},
+	}
+
+	return wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(dep, func(object k8s.Object) bool {
+		d := object.(*appsv1.Deployment)
+		return d.Status.ObservedGeneration >= d.Generation &&
+			d.Status.UpdatedReplicas == *d.Spec.Replicas &&
+			d.Status.ReadyReplicas == *d.Spec.Replicas &&
+			d.Status.Replicas == *d.Spec.Replicas
+	}), wait.WithTimeout(time.Minute*2))
+}
+
+func waitForComponentPodsGone(cfg *envconf.Config, target chaosTarget) error {
+	pods := &corev1.PodList{}
+	return wait.For(conditions.New(cfg.Client().Resources(target.Namespace)).ResourceListN(pods, 0, resources.WithLabelSelector("app="+target.Name)), wait.WithTimeout(time.Minute*2))
+}
+
+// stopComponent returns a feature step that scales the component to zero
+func stopComponent(target chaosTarget) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := scaleComponent(ctx, cfg, target, 0); err != nil {
+			t.Fatalf("failed to stop %s: %v", target, err)
+		}
+
+		t.Logf("component stopped: %s", target)
+		return ctx
+	}
+}
+
+// startComponent returns a feature step that scales the component back to one replica, it is also the
+// teardown of the features that stop the component, a running component is left as it is
+func startComponent(target chaosTarget) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := scaleComponent(ctx, cfg, target, 1); err != nil {
+			t.Fatalf("failed to start %s: %v", target, err)
+		}
+
+		t.Logf("component started: %s", target)
+		return ctx
+	}
+}
+
+// restartComponent returns a feature step that kills the component pods and waits for them to come back
+func restartComponent(target chaosTarget) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := killComponent(ctx, cfg, target); err != nil {
+			t.Fatalf("failed to restart %s: %v", target, err)
+		}
+
+		t.Logf("component restarted: %s", target)
+		return ctx
+	}
+}
@@ -1,11 +1,8 @@
 package e2e
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"testing"
@@ -19,8 +16,14 @@ import (
 	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
 	"sigs.k8s.io/e2e-framework/pkg/envconf"
 	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/restclient"
 )
 
+// restConsumerBody is the consumer created through REST, the parity test sends the same body through
+// REST and GRPC so that the fields the two APIs do not share are reported
+var restConsumerBody = []byte(`{"name": "Test", "labels": [{"key": "baz", "value": "qux" }]}`)
+
 func TestConsumerRESTAPI(t *testing.T) {
 	consumerFeature := features.New("Consumer REST API").
 		WithLabel("type", "rest").
@@ -46,33 +49,8 @@ func TestConsumerRESTAPI(t *testing.T) {
 		}).
 		Assess("Should be able to create a consumer", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			// create a consumer
-			requestURL := fmt.Sprintf("%s/%s", maestroRESTBaseURL, "v1/consumers")
-			jsonBody := []byte(`{"name": "Test", "labels": [{"key": "baz", "value": "qux" }]}`)
-			bodyReader := bytes.NewReader(jsonBody)
-			req, err := http.NewRequest(http.MethodPost, requestURL, bodyReader)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			httpClient := ctx.Value("http-client").(*http.Client)
-			resp, err := httpClient.Do(req)
-			if err != nil {
-				t.Fatal(err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
-			}
-
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatal(err)
-			}
-
 			consumer := &maestropbv1.Consumer{}
-			err = json.Unmarshal(bodyBytes, consumer)
+			err := newRESTClient(ctx).Do(ctx, http.MethodPost, "/v1/consumers", restclient.ContentTypeJSON, restConsumerBody, consumer)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -83,30 +61,7 @@ func TestConsumerRESTAPI(t *testing.T) {
 		}).
 		Assess("Should be able to retrieve a consumer", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			// retrieve the consumer
-			requestURL := fmt.Sprintf("%s/%s/%s", maestroRESTBaseURL, "v1/consumers", consumerID)
-			req, err := http.NewRequest(http.MethodGet, requestURL, nil)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			httpClient := ctx.Value("http-client").(*http.Client)
-			resp, err := httpClient.Do(req)
-			if err != nil {
-				t.Fatal(err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
-			}
-
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			consumer := &maestropbv1.Consumer{}
-			err = json.Unmarshal(bodyBytes, consumer)
+			consumer, err := newRESTClient(ctx).GetConsumer(ctx, consumerID)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -117,33 +72,10 @@ func TestConsumerRESTAPI(t *testing.T) {
 		}).
 		Assess("Should be able to update a consumer", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			// update the consumer
-			requestURL := fmt.Sprintf("%s/%s/%s", maestroRESTBaseURL, "v1/consumers", consumerID)
-			jsonBody := []byte(`{"labels": [{"key": "baz", "value": "quux" }]}`)
-			bodyReader := bytes.NewReader(jsonBody)
-			req, err := http.NewRequest(http.MethodPut, requestURL, bodyReader)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			httpClient := ctx.Value("http-client").(*http.Client)
-			resp, err := httpClient.Do(req)
-			if err != nil {
-				t.Fatal(err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				t.Fatalf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
-			}
-
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			consumer := &maestropbv1.Consumer{}
-			err = json.Unmarshal(bodyBytes, consumer)
+			consumer, err := newRESTClient(ctx).UpdateConsumer(ctx, &maestropbv1.ConsumerUpdateRequest{
+				Id:     consumerID,
+				Labels: []*maestropbv1.ConsumerLabel{{Key: "baz", Value: "quux"}},
+			})
 			if err != nil {
 				t.Fatal(err)
 			}
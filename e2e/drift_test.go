@@ -0,0 +1,224 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+const (
+	driftLabel         = "maestro-e2e/drift"
+	driftImage         = "quay.io/jitesoft/nginx:stable"
+	driftLabelOriginal = "original"
+)
+
+// driftCase edits the applied deployment directly on the cluster, the work-agent is expected to put it back
+type driftCase struct {
+	name string
+	// drift edits the deployment
+	drift func(dep *appsv1.Deployment)
+	// restored checks that the agent reverted the edit
+	restored func(dep *appsv1.Deployment) bool
+	// statusDrifted checks that the status feedback reports the drift, it is nil when the
+	// edit is not visible in the status of the deployment
+	statusDrifted func(status.ContentStatus) bool
+	// statusRestored checks that the status feedback reports the recovery
+	statusRestored func(status.ContentStatus) bool
+}
+
+// The work-agent only reapplies the manifests on its periodic resync, so the drift may last a few minutes,
+// the timeout of the recovery is read from DRIFT_TIMEOUT
+func TestDrift(t *testing.T) {
+	if os.Getenv("DRIFT_TEST") != "true" {
+		t.Skip("drift testing is disabled, set DRIFT_TEST=true to enable it")
+	}
+
+	timeout := time.Minute * 6
+	if v := os.Getenv("DRIFT_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			t.Fatalf("invalid DRIFT_TIMEOUT: %q", v)
+		}
+		timeout = d
+	}
+
+	var feats []features.Feature
+	for _, c := range driftCases() {
+		feats = append(feats, driftFeature(c, timeout))
+	}
+	testenv.Test(t, feats...)
+}
+
+func driftFeature(c driftCase, timeout time.Duration) features.Feature {
+	depName := "drift-" + c.name
+	return features.New(fmt.Sprintf("Drift of the %s", c.name)).
+		WithLabel("type", "grpc").
+		WithLabel("res", "resource").
+		WithLabel("mode", "drift").
+		Setup(setupDeletionClients()).
+		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			objStruct, err := toStruct(newDriftDeployment(depName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pbResource, err := grpcClient.Create(ctx, &maestropbv1.ResourceCreateRequest{
+				ConsumerId: consumerID,
+				Object:     objStruct,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := waitForResourceReadyReplicas(ctx, grpcClient, pbResource.Id, 1, time.Minute*2); err != nil {
+				t.Fatal(err)
+			}
+
+			return context.WithValue(ctx, "drift-resource-id", pbResource.Id)
+		}).
+		Assess("should be able to edit the applied deployment", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				var dep appsv1.Deployment
+				if err := cfg.Client().Resources().Get(ctx, depName, "default", &dep); err != nil {
+					return err
+				}
+				c.drift(&dep)
+				return cfg.Client().Resources().Update(ctx, &dep)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Logf("deployment %s edited: %s", depName, c.name)
+			return ctx
+		}).
+		Assess("should report the drift", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			if c.statusDrifted == nil {
+				return ctx
+			}
+
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			_, err := status.WaitFor(ctx, grpcClient, ctx.Value("drift-resource-id").(string), func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+				return c.statusDrifted(s.ContentStatus)
+			}, time.Minute*2)
+			if err != nil {
+				t.Fatalf("the drift is not reported: %v", err)
+			}
+			return ctx
+		}).
+		Assess("should revert the drift", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			dep := &appsv1.Deployment{}
+			dep.Name, dep.Namespace = depName, "default"
+			err := wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(dep, func(object k8s.Object) bool {
+				return c.restored(object.(*appsv1.Deployment))
+			}), wait.WithTimeout(timeout), wait.WithInterval(time.Second*10))
+			if err != nil {
+				t.Fatalf("the work-agent did not revert the %s within %s: %v", c.name, timeout, err)
+			}
+			return ctx
+		}).
+		Assess("should report the recovery", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			_, err := status.WaitFor(ctx, grpcClient, ctx.Value("drift-resource-id").(string), func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+				return c.statusRestored(s.ContentStatus)
+			}, time.Minute*2)
+			if err != nil {
+				t.Fatalf("the recovery is not reported: %v", err)
+			}
+			return ctx
+		}).
+		Teardown(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			resourceID, ok := ctx.Value("drift-resource-id").(string)
+			if !ok {
+				return ctx
+			}
+
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			objStruct, err := toStruct(markDeleted(newDriftDeployment(depName)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: resourceID, Object: objStruct}); err != nil {
+				t.Logf("failed to delete the resource: %v", err)
+			}
+			return ctx
+		}).
+		Feature()
+}
+
+func driftCases() []driftCase {
+	readyReplicas := func(replicas int64) func(status.ContentStatus) bool {
+		return func(c status.ContentStatus) bool {
+			ready, _ := c.Int64("readyReplicas")
+			updated, _ := c.Int64("updatedReplicas")
+			return ready == replicas && updated == replicas
+		}
+	}
+
+	return []driftCase{
+		{
+			name: "replicas",
+			drift: func(dep *appsv1.Deployment) {
+				replicas := int32(3)
+				dep.Spec.Replicas = &replicas
+			},
+			restored: func(dep *appsv1.Deployment) bool {
+				return *dep.Spec.Replicas == 1
+			},
+			statusDrifted:  readyReplicas(3),
+			statusRestored: readyReplicas(1),
+		},
+		{
+			name: "labels",
+			drift: func(dep *appsv1.Deployment) {
+				dep.Labels[driftLabel] = "drifted"
+			},
+			restored: func(dep *appsv1.Deployment) bool {
+				return dep.Labels[driftLabel] == driftLabelOriginal
+			},
+			// the labels are not part of the status
+			statusRestored: readyReplicas(1),
+		},
+		{
+			name: "image",
+			drift: func(dep *appsv1.Deployment) {
+				dep.Spec.Template.Spec.Containers[0].Image = driftImage
+			},
+			restored: func(dep *appsv1.Deployment) bool {
+				return dep.Spec.Template.Spec.Containers[0].Image != driftImage
+			},
+			// the image is not part of the status, the rollout of the edit bumps the observed generation
+			statusDrifted: func(c status.ContentStatus) bool {
+				generation, _ := c.Int64("observedGeneration")
+				return generation >= 2
+			},
+			statusRestored: func(c status.ContentStatus) bool {
+				generation, _ := c.Int64("observedGeneration")
+				return generation >= 3 && readyReplicas(1)(c)
+			},
+		},
+	}
+}
+
+// newDriftDeployment returns the nginx deployment with the label the drift features edit
+func newDriftDeployment(name string) *unstructured.Unstructured {
+	dep := newNginxDeployment(name, 1)
+	dep.SetLabels(map[string]string{driftLabel: driftLabelOriginal})
+	return dep
+}
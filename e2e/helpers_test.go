@@ -0,0 +1,289 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	apimachinerywait "k8s.io/apimachinery/pkg/util/wait"
+	workv1 "open-cluster-management.io/api/work/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+
+	"github.com/morvencao/maestro-e2e/utils/restclient"
+	"github.com/morvencao/maestro-e2e/utils/status"
+	"github.com/morvencao/maestro-e2e/utils/workview"
+)
+
+// newRESTClient returns a maestro REST client that uses the http client stored in the context
+func newRESTClient(ctx context.Context) *restclient.Client {
+	return restclient.New(maestroRESTBaseURL, ctx.Value("http-client").(*http.Client))
+}
+
+// newNginxDeployment returns the nginx deployment that the features deliver through maestro
+func newNginxDeployment(name string, replicas int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+			},
+			"spec": map[string]interface{}{
+				"replicas": replicas,
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						"app": name,
+					},
+				},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]interface{}{
+							"app": name,
+						},
+					},
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{
+								"image":           "quay.io/jitesoft/nginx",
+								"imagePullPolicy": "IfNotPresent",
+								"name":            "nginx",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// toStruct converts the unstructured object to the proto struct accepted by the resource service
+func toStruct(obj *unstructured.Unstructured) (*structpb.Struct, error) {
+	return structpb.NewStruct(obj.UnstructuredContent())
+}
+
+// featureConsumerID returns the consumer of the feature, the consumer created by setupWatchConsumer when
+// the feature has one of its own, or the consumer of the suite
+func featureConsumerID(ctx context.Context) string {
+	if id, ok := ctx.Value("watch-consumer-id").(string); ok {
+		return id
+	}
+	return consumerID
+}
+
+// runsAgainstFake returns true when the features run against the fake maestro and its simulated work-agent
+func runsAgainstFake() bool {
+	return fakeCluster != nil
+}
+
+// pollInterval is the interval of the waits of the features, the fake maestro and the simulated
+// work-agent answer at once, so the waits poll them often
+func pollInterval() time.Duration {
+	if runsAgainstFake() {
+		return time.Millisecond * 100
+	}
+	return time.Second * 5
+}
+
+// holdWindow is how long a feature verifies that a state is kept, the simulated work-agent handles
+// the events it receives at once, so a short window is enough to see a change against the fake
+func holdWindow() time.Duration {
+	if runsAgainstFake() {
+		return time.Second * 2
+	}
+	return time.Second * 30
+}
+
+// clusterClient returns the client of the cluster the manifests of the consumers are applied to, it is the
+// client of the simulated work-agent when the features run against the fake maestro
+func clusterClient(cfg *envconf.Config) client.Client {
+	if fakeCluster != nil {
+		return fakeCluster
+	}
+	return cfg.Client().Resources().GetControllerRuntimeClient()
+}
+
+// objectMatch is the condition of the object of the cluster client matching, as ResourceMatch
+func objectMatch(cfg *envconf.Config, obj client.Object, match func(object client.Object) bool) apimachinerywait.ConditionWithContextFunc {
+	c := clusterClient(cfg)
+	return func(ctx context.Context) (done bool, err error) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return false, nil
+		}
+		return match(obj), nil
+	}
+}
+
+// objectDeleted is the condition of the object being removed from the cluster client, as ResourceDeleted
+func objectDeleted(cfg *envconf.Config, obj client.Object) apimachinerywait.ConditionWithContextFunc {
+	c := clusterClient(cfg)
+	return func(ctx context.Context) (done bool, err error) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return false, nil
+	}
+}
+
+// waitForWorkAgentAvailable waits until at least half of the replicas of the work-agent are ready, the
+// simulated work-agent of the fake maestro runs in the test process
+func waitForWorkAgentAvailable(cfg *envconf.Config, timeout time.Duration) error {
+	if runsAgainstFake() {
+		return nil
+	}
+
+	workAgentDep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "work-agent", Namespace: "open-cluster-management-agent"},
+	}
+	return wait.For(objectMatch(cfg, workAgentDep, func(object client.Object) bool {
+		d := object.(*appsv1.Deployment)
+		return float64(d.Status.ReadyReplicas)/float64(*d.Spec.Replicas) >= 0.50
+	}), wait.WithTimeout(timeout), wait.WithInterval(pollInterval()))
+}
+
+// waitForDeploymentReadyReplicas waits until the deployment reports the given ready replicas
+func waitForDeploymentReadyReplicas(cfg *envconf.Config, name, namespace string, replicas int32, timeout time.Duration) error {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+
+	return wait.For(objectMatch(cfg, dep, func(object client.Object) bool {
+		d := object.(*appsv1.Deployment)
+		return d.Status.ReadyReplicas == replicas
+	}), wait.WithTimeout(timeout), wait.WithInterval(pollInterval()))
+}
+
+// waitForResourceReadyReplicas waits until maestro reports the given spec and ready replicas for the resource
+func waitForResourceReadyReplicas(ctx context.Context, client maestropbv1.ResourceServiceClient, id string, replicas int64, timeout time.Duration) error {
+	return wait.For(func(context.Context) (done bool, err error) {
+		pbResource, err := client.Read(ctx, &maestropbv1.ResourceReadRequest{Id: id})
+		if err != nil {
+			// maestro may not be reachable yet
+			return false, nil
+		}
+
+		spec := pbResource.Object.Fields["spec"]
+		if spec.GetStructValue().Fields["replicas"].GetNumberValue() != float64(replicas) {
+			return false, nil
+		}
+
+		resourceStatus, err := status.FromResource(pbResource)
+		if err != nil {
+			return false, err
+		}
+
+		if readyReplicas, _ := resourceStatus.ContentStatus.Int64("readyReplicas"); readyReplicas != replicas {
+			return false, nil
+		}
+
+		return true, nil
+	}, wait.WithTimeout(timeout), wait.WithInterval(pollInterval()))
+}
+
+// markDeleted returns a copy of the object with the deletionTimestamp set, maestro has no delete
+// operation, the object is deleted from the cluster once it is updated with a deletionTimestamp
+func markDeleted(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	deleted := obj.DeepCopy()
+	deleted.SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
+	return deleted
+}
+
+// waitForDeploymentDeleted waits until the deployment is removed from the cluster
+func waitForDeploymentDeleted(cfg *envconf.Config, name, namespace string, timeout time.Duration) error {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+
+	return wait.For(objectDeleted(cfg, dep), wait.WithTimeout(timeout), wait.WithInterval(pollInterval()))
+}
+
+// getAgentView returns the agent side view of the resource of the suite consumer, or nil when the agent
+// keeps nothing for it
+func getAgentView(ctx context.Context, cfg *envconf.Config, resourceID string) (*workview.View, error) {
+	if err := workv1.Install(cfg.Client().Resources().GetScheme()); err != nil {
+		return nil, err
+	}
+	return workview.Get(ctx, cfg.Client().Resources().GetControllerRuntimeClient(), consumerID, resourceID)
+}
+
+// waitForAppliedManifestWorkDeleted waits until the AppliedManifestWork of the resource is removed
+// from the cluster, the work-agent names the ManifestWork after the resource id
+func waitForAppliedManifestWorkDeleted(ctx context.Context, cfg *envconf.Config, resourceID string, timeout time.Duration) error {
+	return wait.For(func(context.Context) (done bool, err error) {
+		view, err := getAgentView(ctx, cfg, resourceID)
+		if err != nil {
+			return false, err
+		}
+		return view == nil || view.AppliedManifestWork == nil, nil
+	}, wait.WithTimeout(timeout), wait.WithInterval(time.Second*5))
+}
+
+// waitForAppliedResourceNames waits until the AppliedManifestWork of the resource lists exactly
+// the applied resources with the given names
+func waitForAppliedResourceNames(ctx context.Context, cfg *envconf.Config, resourceID string, names []string, timeout time.Duration) error {
+	expected := sets.New(names...)
+	return wait.For(func(context.Context) (done bool, err error) {
+		view, err := getAgentView(ctx, cfg, resourceID)
+		if err != nil || view == nil {
+			return false, err
+		}
+
+		applied := sets.New[string]()
+		for _, resource := range view.AppliedResources() {
+			applied.Insert(resource.Name)
+		}
+		return applied.Equal(expected), nil
+	}, wait.WithTimeout(timeout), wait.WithInterval(time.Second*5))
+}
+
+// deployWorkAgent deploys a copy of the suite work-agent that serves the given consumer
+func deployWorkAgent(ctx context.Context, cfg *envconf.Config, name, clusterName string) error {
+	var workAgentDep appsv1.Deployment
+	if err := cfg.Client().Resources().Get(ctx, workAgentTarget.Name, workAgentTarget.Namespace, &workAgentDep); err != nil {
+		return err
+	}
+
+	labels := map[string]string{"app": name}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: workAgentTarget.Namespace, Labels: labels},
+		Spec:       *workAgentDep.Spec.DeepCopy(),
+	}
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	dep.Spec.Template.Labels = labels
+
+	args := dep.Spec.Template.Spec.Containers[0].Args
+	for i, arg := range args {
+		if strings.Contains(arg, "--spoke-cluster-name=") {
+			args[i] = fmt.Sprintf("--spoke-cluster-name=%s", clusterName)
+			break
+		}
+	}
+
+	if err := cfg.Client().Resources().Create(ctx, dep); err != nil {
+		return err
+	}
+
+	return waitForComponentReady(cfg, chaosTarget{Name: name, Namespace: workAgentTarget.Namespace})
+}
+
+// deleteWorkAgent deletes a work-agent deployed by deployWorkAgent
+func deleteWorkAgent(ctx context.Context, cfg *envconf.Config, name string) error {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: workAgentTarget.Namespace},
+	}
+	return cfg.Client().Resources().Delete(ctx, dep)
+}
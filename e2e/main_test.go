@@ -13,10 +13,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/e2e-framework/klient/conf"
 	"sigs.k8s.io/e2e-framework/klient/k8s"
 	"sigs.k8s.io/e2e-framework/klient/wait"
@@ -24,9 +26,16 @@ import (
 	"sigs.k8s.io/e2e-framework/pkg/env"
 	"sigs.k8s.io/e2e-framework/pkg/envconf"
 	"sigs.k8s.io/e2e-framework/pkg/envfuncs"
+	"sigs.k8s.io/e2e-framework/pkg/features"
 	"sigs.k8s.io/e2e-framework/support/kind"
 
+	"github.com/morvencao/maestro-e2e/utils/fakeagent"
+	"github.com/morvencao/maestro-e2e/utils/fakemaestro"
+	"github.com/morvencao/maestro-e2e/utils/grpcclient"
 	"github.com/morvencao/maestro-e2e/utils/kustomize"
+	"github.com/morvencao/maestro-e2e/utils/matrix"
+	"github.com/morvencao/maestro-e2e/utils/status"
+	"github.com/morvencao/maestro-e2e/utils/watchrecorder"
 )
 
 var (
@@ -36,13 +45,48 @@ var (
 
 const (
 	dbEndpoint         = "http://127.0.0.1:31310"
-	maestroRESTBaseURL = "http://127.0.0.1:31330"
 	maestroGPRCBaseURL = "127.0.0.1:31320"
 )
 
+// maestroRESTBaseURL is the URL of the maestro REST gateway, it is replaced by the URL of the REST
+// gateway of the fake maestro when FAKE_MAESTRO is true
+var maestroRESTBaseURL = "http://127.0.0.1:31330"
+
+// fakeCluster is the fake cluster client the simulated work-agent applies the manifests to, it replaces
+// the cluster of the environment when FAKE_MAESTRO is true
+var fakeCluster client.Client
+
+// fakeAgentFaults are the faults of the simulated work-agents of the features, the delay stands for the
+// broker and the apply of a real work-agent, so a spec sent right after another one is stored before
+// the status of the first one
+var fakeAgentFaults = fakeagent.Faults{Delay: time.Millisecond * 50}
+
 func TestMain(m *testing.M) {
 	cfg, _ := envconf.NewFromFlags()
-	if os.Getenv("REAL_CLUSTER") == "true" {
+	if os.Getenv("FAKE_MAESTRO") == "true" {
+		// the hermetic tier runs against an in-process fake maestro and a simulated work-agent, no
+		// cluster is created and only the features labeled tier=hermetic run
+		labels := cfg.Labels()
+		if labels == nil {
+			labels = map[string][]string{}
+		}
+		labels["tier"] = []string{"hermetic"}
+		testenv = env.NewWithConfig(cfg.WithLabels(labels))
+
+		testenv.Setup(
+			startFakeMaestro(),
+			startWatchHub(),
+			createHttpClient(),
+			createFakeConsumer(),
+		)
+		testenv.Finish(
+			deleteHttpClient(),
+			stopWatchHub(),
+			stopFakeAgent(),
+			deleteGRPCClient(),
+			stopFakeMaestro(),
+		)
+	} else if os.Getenv("REAL_CLUSTER") == "true" {
 		path := conf.ResolveKubeConfigFile()
 		cfg = cfg.WithKubeconfigFile(path)
 		testenv = env.NewWithConfig(cfg)
@@ -54,11 +98,13 @@ func TestMain(m *testing.M) {
 			installComponent("../manifests/maestro"),
 			createTables("us-east-1", dbEndpoint),
 			createGRPCClient(maestroGPRCBaseURL),
+			startWatchHub(),
 			createHttpClient(),
 		)
 		if os.Getenv("CLEAN_ENV") == "true" {
 			testenv.Finish(
 				deleteHttpClient(),
+				stopWatchHub(),
 				deleteGRPCClient(),
 				uninstallComponent("../manifests/maestro"),
 				uninstallComponent("../manifests/dynamodb"),
@@ -68,42 +114,64 @@ func TestMain(m *testing.M) {
 		} else {
 			testenv.Finish(
 				deleteHttpClient(),
+				stopWatchHub(),
 				deleteGRPCClient(),
 			)
 		}
 	} else {
 		testenv = env.NewWithConfig(cfg)
-		kindClusterName := envconf.RandomName("maestro-e2e", 16)
+		// an existing cluster named by KIND_CLUSTER_NAME is reused and kept, only the components are uninstalled
+		kindClusterName := os.Getenv(matrix.ClusterNameEnv)
+		reuseCluster := kindClusterName != ""
+		if !reuseCluster {
+			kindClusterName = envconf.RandomName("maestro-e2e", 16)
+		}
 
 		testenv.Setup(
-			envfuncs.CreateClusterWithConfig(kind.NewProvider(), kindClusterName, "kind-config.yaml", kind.WithImage("kindest/node:v1.27.1")),
+			envfuncs.CreateClusterWithConfig(kind.NewProvider(), kindClusterName, "kind-config.yaml", kind.WithImage(matrix.NodeImageFromEnv())),
 			installComponent("../manifests/mqtt-broker"),
 			installComponent("../manifests/work-agent"),
 			installComponent("../manifests/dynamodb"),
 			installComponent("../manifests/maestro"),
 			createTables("us-east-1", dbEndpoint),
 			createGRPCClient(maestroGPRCBaseURL),
+			startWatchHub(),
 			createHttpClient(),
 		)
 
 		if os.Getenv("CLEAN_ENV") == "true" {
-			testenv.Finish(
+			finishFuncs := []env.Func{
 				deleteHttpClient(),
+				stopWatchHub(),
 				deleteGRPCClient(),
 				uninstallComponent("../manifests/maestro"),
 				uninstallComponent("../manifests/dynamodb"),
 				uninstallComponent("../manifests/work-agent"),
 				uninstallComponent("../manifests/mqtt-broker"),
-				envfuncs.DestroyCluster(kindClusterName),
-			)
+			}
+			if reuseCluster {
+				// the next run installs the components again on the cluster
+				finishFuncs = append(finishFuncs,
+					waitForComponentUninstalled("../manifests/maestro"),
+					waitForComponentUninstalled("../manifests/dynamodb"),
+					waitForComponentUninstalled("../manifests/work-agent"),
+					waitForComponentUninstalled("../manifests/mqtt-broker"),
+				)
+			} else {
+				finishFuncs = append(finishFuncs, envfuncs.DestroyCluster(kindClusterName))
+			}
+			testenv.Finish(finishFuncs...)
 		} else {
 			testenv.Finish(
 				deleteHttpClient(),
+				stopWatchHub(),
 				deleteGRPCClient(),
 			)
 		}
 	}
 
+	testenv.BeforeEachFeature(logGRPCCalls())
+
 	os.Exit(testenv.Run(m))
 }
 
@@ -111,6 +179,7 @@ func installComponent(kustomizationPath string) env.Func {
 	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
 		manifests, err := kustomize.Render(kustomize.Options{
 			KustomizationPath: kustomizationPath,
+			Images:            matrix.ImagesFromEnv(),
 		})
 		if err != nil {
 			fmt.Printf("Error rendering manifests: %v\n", err)
@@ -138,6 +207,7 @@ func uninstallComponent(kustomizationPath string) env.Func {
 	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
 		manifests, err := kustomize.Render(kustomize.Options{
 			KustomizationPath: kustomizationPath,
+			Images:            matrix.ImagesFromEnv(),
 		})
 		if err != nil {
 			fmt.Printf("Error rendering manifests: %v\n", err)
@@ -161,6 +231,36 @@ func uninstallComponent(kustomizationPath string) env.Func {
 	}
 }
 
+// waitForComponentUninstalled waits until the objects of the component are removed from the cluster,
+// the namespaces are terminating for a while after they are deleted
+func waitForComponentUninstalled(kustomizationPath string) env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		manifests, err := kustomize.Render(kustomize.Options{
+			KustomizationPath: kustomizationPath,
+			Images:            matrix.ImagesFromEnv(),
+		})
+		if err != nil {
+			fmt.Printf("Error rendering manifests: %v\n", err)
+			return ctx, err
+		}
+
+		objects, err := kustomize.ToObjects(manifests)
+		if err != nil {
+			fmt.Printf("Error converting manifests to objects: %v\n", err)
+			return ctx, err
+		}
+
+		for _, obj := range objects {
+			if err := wait.For(conditions.New(cfg.Client().Resources()).ResourceDeleted(obj), wait.WithTimeout(time.Minute*5)); err != nil {
+				fmt.Printf("Error waiting for object deletion: %v\n", err)
+				return ctx, err
+			}
+		}
+
+		return ctx, nil
+	}
+}
+
 func createTables(region, endpoint string) env.Func {
 	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
 		client, err := cfg.NewClient()
@@ -333,16 +433,124 @@ func deleteHttpClient() env.Func {
 
 func createGRPCClient(endpoint string) env.Func {
 	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
-		conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		dialOpts := append(grpcclient.DialOptions(grpcclient.DefaultOptions()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.Dial(endpoint, dialOpts...)
+		if err != nil {
+			fmt.Printf("Error initializing GRPC connection: %v\n", err)
+			return ctx, err
+		}
+
+		return context.WithValue(ctx, "grpc-connction", conn), nil
+	}
+}
+
+// logGRPCCalls logs the calls made on the grpc connection by the feature steps to the test log
+func logGRPCCalls() env.FeatureFunc {
+	return func(ctx context.Context, cfg *envconf.Config, t *testing.T, _ features.Feature) (context.Context, error) {
+		return grpcclient.WithLogger(ctx, t), nil
+	}
+}
+
+// startFakeMaestro starts the fake maestro and connects the grpc connection of the features to it
+func startFakeMaestro() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		server, err := fakemaestro.New()
+		if err != nil {
+			fmt.Printf("Error starting fake maestro: %v\n", err)
+			return ctx, err
+		}
+		maestroRESTBaseURL = server.URL()
+
+		conn, err := server.Dial(ctx, grpcclient.DialOptions(grpcclient.DefaultOptions())...)
 		if err != nil {
+			server.Close()
 			fmt.Printf("Error initializing GRPC connection: %v\n", err)
 			return ctx, err
 		}
 
+		ctx = context.WithValue(ctx, "fake-maestro", server)
 		return context.WithValue(ctx, "grpc-connction", conn), nil
 	}
 }
 
+// createFakeConsumer creates the consumer of the features and starts its simulated work-agent, which
+// applies the resources of the consumer to a fake cluster client
+func createFakeConsumer() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+		consumer, err := maestropbv1.NewConsumerServiceClient(conn).Create(ctx, &maestropbv1.ConsumerCreateRequest{})
+		if err != nil {
+			fmt.Printf("Error creating consumer: %v\n", err)
+			return ctx, err
+		}
+
+		consumerID = consumer.Id
+		server := ctx.Value("fake-maestro").(*fakemaestro.Server)
+		agent := fakeagent.Start(consumerID, server, fakeagent.Options{Faults: fakeAgentFaults})
+		fakeCluster = agent.Client()
+		// the fake maestro stores the statuses at once, read the resources often
+		ctx = status.WithPollInterval(ctx, pollInterval())
+		return context.WithValue(ctx, "fake-agent", agent), nil
+	}
+}
+
+func stopFakeAgent() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		agent, ok := ctx.Value("fake-agent").(*fakeagent.Agent)
+		if !ok {
+			return ctx, fmt.Errorf("stop fake agent func: context fake agent is nil")
+		}
+
+		agent.Stop()
+		return ctx, nil
+	}
+}
+
+func stopFakeMaestro() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		server, ok := ctx.Value("fake-maestro").(*fakemaestro.Server)
+		if !ok {
+			return ctx, fmt.Errorf("stop fake maestro func: context fake maestro is nil")
+		}
+
+		server.Close()
+		return ctx, nil
+	}
+}
+
+// startWatchHub opens the watch stream shared by the features for the whole run, maestro does not
+// store a status until a watch stream receives it, so the stream is never closed by a feature
+func startWatchHub() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+		client := maestropbv1.NewCloudEventsServiceClient(conn)
+
+		// maestro may still be starting after it is installed
+		var hub *watchrecorder.Hub
+		err := wait.For(func(context.Context) (done bool, err error) {
+			hub, err = watchrecorder.StartHub(ctx, client, &maestropbv1.ResourceWatchRequest{}, time.Second*2)
+			return err == nil, nil
+		}, wait.WithInterval(time.Second*5), wait.WithTimeout(time.Minute*5))
+		if err != nil {
+			fmt.Printf("Error watching the status events: %v\n", err)
+			return ctx, err
+		}
+
+		return context.WithValue(ctx, "watch-hub", hub), nil
+	}
+}
+
+func stopWatchHub() env.Func {
+	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
+		hub, ok := ctx.Value("watch-hub").(*watchrecorder.Hub)
+		if !ok {
+			return ctx, fmt.Errorf("stop watch hub func: context watch hub is nil")
+		}
+
+		return ctx, hub.Stop(time.Second * 30)
+	}
+}
+
 func deleteGRPCClient() env.Func {
 	return func(ctx context.Context, cfg *envconf.Config) (context.Context, error) {
 		connValue := ctx.Value("grpc-connction")
@@ -0,0 +1,215 @@
+package e2e
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	resourcestatus "github.com/morvencao/maestro-e2e/utils/status"
+)
+
+func TestManifestBundle(t *testing.T) {
+	valid := func() []workloadObject {
+		namespace := "bundle"
+		serviceAccount := newWorkloadObject("v1", "ServiceAccount", namespace, "bundle-sa", nil)
+		configMap := newWorkloadObject("v1", "ConfigMap", namespace, "bundle-config", map[string]interface{}{
+			"data": map[string]interface{}{"key": "value"},
+		})
+		dep := newNginxDeployment("bundle-deployment", 1)
+		dep.SetNamespace(namespace)
+
+		exists := func(live *unstructured.Unstructured) bool { return true }
+		return []workloadObject{
+			{obj: newWorkloadObject("v1", "Namespace", "", namespace, nil), ready: exists},
+			{obj: serviceAccount, ready: exists},
+			{obj: configMap, ready: exists},
+			{obj: dep, ready: readyReplicas(1)},
+		}
+	}
+
+	partial := func() []workloadObject {
+		configMap := newWorkloadObject("v1", "ConfigMap", "default", "bundle-partial-config", map[string]interface{}{
+			"data": map[string]interface{}{"key": "value"},
+		})
+		// the kind is not served by the apiserver, so the manifest cannot be applied
+		missing := newWorkloadObject("maestro-e2e.io/v1", "Missing", "default", "bundle-partial-missing", nil)
+
+		return []workloadObject{
+			{obj: configMap, ready: func(live *unstructured.Unstructured) bool { return true }},
+			{obj: missing},
+		}
+	}
+
+	// maestro rejects the manifestbundles event type with "unsupported cloudevents data type", so the
+	// bundle features are expected to fail, skip them with --skip-labels="xfail=manifestbundle"
+	testenv.Test(t,
+		features.New("Manifest bundle delivery").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifestbundle").
+			WithLabel("xfail", "manifestbundle").
+			Setup(setupDeletionClients()).
+			Setup(sendManifestBundle(valid())).
+			Assess("should apply every manifest of the bundle", assessBundleApplied(valid())).
+			Assess("should report every manifest of the bundle applied", assessBundleManifests(valid())).
+			Assess("should report the bundle applied", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				builder := ctx.Value("bundle-builder").(*manifestevent.Builder)
+				_, err := resourcestatus.WaitForResourceCondition(ctx, grpcClient, builder.ResourceID(), resourcestatus.ConditionApplied, metav1.ConditionTrue, time.Minute*2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ctx
+			}).
+			Teardown(deleteManifestBundle(valid())).
+			Feature(),
+		features.New("Manifest bundle partially applied").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifestbundle").
+			WithLabel("xfail", "manifestbundle").
+			Setup(setupDeletionClients()).
+			Setup(sendManifestBundle(partial())).
+			Assess("should apply only the valid manifests of the bundle", assessBundleApplied(partial())).
+			Assess("should report the manifest that is not applied", assessBundleManifests(partial())).
+			Assess("should report the bundle not applied", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				builder := ctx.Value("bundle-builder").(*manifestevent.Builder)
+				_, err := resourcestatus.WaitForResourceCondition(ctx, grpcClient, builder.ResourceID(), resourcestatus.ConditionApplied, metav1.ConditionFalse, time.Minute*2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ctx
+			}).
+			Teardown(deleteManifestBundle(partial())).
+			Feature(),
+	)
+}
+
+// sendManifestBundle sends the objects as the manifests of a single manifestbundles event, the feature
+// fails when maestro does not accept manifest bundles
+func sendManifestBundle(members []workloadObject) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		builder := manifestevent.NewBuilder(consumerID)
+		pbEvt, err := builder.BuildProto(manifestevent.ActionCreate, bundleObjects(members)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := grpcClient.Send(ctx, pbEvt); err != nil {
+			if strings.HasPrefix(status.Convert(err).Message(), "unsupported cloudevents data type") {
+				t.Fatalf("maestro does not support manifest bundles: %v", err)
+			}
+			t.Fatal(err)
+		}
+
+		return context.WithValue(ctx, "bundle-builder", builder)
+	}
+}
+
+func deleteManifestBundle(members []workloadObject) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		builder, ok := ctx.Value("bundle-builder").(*manifestevent.Builder)
+		if !ok {
+			return ctx
+		}
+
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		pbEvt, err := builder.BuildProto(manifestevent.ActionDelete, bundleObjects(members)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := grpcClient.Send(ctx, pbEvt); err != nil {
+			t.Logf("failed to delete the manifest bundle: %v", err)
+		}
+		return ctx
+	}
+}
+
+// assessBundleApplied verifies that the members with a readiness check are ready on the cluster,
+// and that the AppliedManifestWork of the bundle lists exactly these members
+func assessBundleApplied(members []workloadObject) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		var applied []string
+		for _, m := range members {
+			if m.ready == nil {
+				continue
+			}
+
+			live := &unstructured.Unstructured{}
+			live.SetGroupVersionKind(m.obj.GroupVersionKind())
+			live.SetName(m.obj.GetName())
+			live.SetNamespace(m.obj.GetNamespace())
+
+			err := wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(live, func(object k8s.Object) bool {
+				return m.ready(object.(*unstructured.Unstructured))
+			}), wait.WithTimeout(time.Minute*2))
+			if err != nil {
+				t.Fatalf("%s %s is not ready: %v", m.obj.GetKind(), m.obj.GetName(), err)
+			}
+			applied = append(applied, m.obj.GetName())
+		}
+
+		builder := ctx.Value("bundle-builder").(*manifestevent.Builder)
+		if err := waitForAppliedResourceNames(ctx, cfg, builder.ResourceID(), applied, time.Minute*2); err != nil {
+			t.Fatalf("the applied manifestwork does not list the applied manifests %v: %v", applied, err)
+		}
+		return ctx
+	}
+}
+
+// assessBundleManifests verifies that maestro reports the status of each manifest of the bundle, the
+// members with a readiness check are applied and the others are not
+func assessBundleManifests(members []workloadObject) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		builder := ctx.Value("bundle-builder").(*manifestevent.Builder)
+
+		expected := func(m workloadObject) metav1.ConditionStatus {
+			if m.ready == nil {
+				return metav1.ConditionFalse
+			}
+			return metav1.ConditionTrue
+		}
+		last, err := resourcestatus.WaitFor(ctx, grpcClient, builder.ResourceID(), func(r *maestropbv1.Resource, s *resourcestatus.ResourceStatus) bool {
+			if s.ResourceGenerationID < r.GenerationId {
+				return false
+			}
+			for _, m := range members {
+				if !s.HasManifestCondition(m.obj.GetKind(), m.obj.GetNamespace(), m.obj.GetName(), resourcestatus.ConditionApplied, expected(m)) {
+					return false
+				}
+			}
+			return true
+		}, time.Minute*2)
+		if err != nil {
+			for _, m := range members {
+				if last == nil || !last.HasManifestCondition(m.obj.GetKind(), m.obj.GetNamespace(), m.obj.GetName(), resourcestatus.ConditionApplied, expected(m)) {
+					t.Errorf("%s %s is not reported with the %s condition %s", m.obj.GetKind(), m.obj.GetName(), resourcestatus.ConditionApplied, expected(m))
+				}
+			}
+			t.Fatal(err)
+		}
+		return ctx
+	}
+}
+
+func bundleObjects(members []workloadObject) []*unstructured.Unstructured {
+	objs := make([]*unstructured.Unstructured, 0, len(members))
+	for _, m := range members {
+		objs = append(objs, m.obj)
+	}
+	return objs
+}
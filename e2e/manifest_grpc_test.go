@@ -3,24 +3,20 @@ package e2e
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"testing"
 	"time"
 
-	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
-	"github.com/cloudevents/sdk-go/v2/event"
-	cloudeventstypes "github.com/cloudevents/sdk-go/v2/types"
 	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
 	"google.golang.org/grpc"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
-	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/e2e-framework/klient/wait"
-	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
 	"sigs.k8s.io/e2e-framework/pkg/envconf"
 	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/watchrecorder"
 )
 
 var ceResourceID = ""
@@ -29,99 +25,34 @@ func TestManifestGRPCService(t *testing.T) {
 	manifestFeature := features.New("Manifest GRPC Service").
 		WithLabel("type", "grpc").
 		WithLabel("res", "manifest").
+		WithLabel("tier", "hermetic").
 		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			if consumerID == "" {
 				t.Fatal("consumerID is empty")
 			}
 
-			client, err := cfg.NewClient()
-			if err != nil {
-				t.Fatal(err)
-			}
-			workAgentDep := &appsv1.Deployment{
-				ObjectMeta: metav1.ObjectMeta{Name: "work-agent", Namespace: "open-cluster-management-agent"},
-			}
-			// wait for the deployment to become at least 50%
-			err = wait.For(conditions.New(client.Resources()).ResourceMatch(workAgentDep, func(object k8s.Object) bool {
-				d := object.(*appsv1.Deployment)
-				return float64(d.Status.ReadyReplicas)/float64(*d.Spec.Replicas) >= 0.50
-			}), wait.WithTimeout(time.Minute*2))
-			if err != nil {
+			if err := waitForWorkAgentAvailable(cfg, time.Minute*2); err != nil {
 				t.Fatal(err)
 			}
-			// t.Logf("deployment availability: %.2f%%", float64(workAgentDep.Status.ReadyReplicas)/float64(*workAgentDep.Spec.Replicas)*100)
 
 			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
 			grpcClient := maestropbv1.NewCloudEventsServiceClient(conn)
 
+			// the status events are recorded before the manifest is posted, the shared watch stream does
+			// not replay the events received before
+			ctx = context.WithValue(ctx, "grpc-manifest-watch", subscribeWatch(ctx))
+			ctx = context.WithValue(ctx, "grpc-manifest-builder", manifestevent.NewBuilder(consumerID))
 			return context.WithValue(ctx, "grpc-manifest-client", grpcClient)
 		}).
 		Assess("should be able to post a manifest", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			// create a manifest
 			grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
-			webDeployCEJSON := []byte(fmt.Sprintf(`
-{
-	"id": "8f61b49e-e51d-4c17-a8e2-1e1d89a77e2c",
-	"specversion": "1.0",
-	"time": "2023-09-01T17:31:00Z",
-	"datacontenttype": "application/json",
-	"source": "maestro",
-	"type": "io.open-cluster-management.works.v1alpha1.manifests.spec.create_request",
-	"clustername": "%s",
-	"resourceid": "8a178798-f445-459c-ad66-6645246ec811",
-	"resourceversion": "1",
-	"data": {
-		"manifest": {
-			"apiVersion": "apps/v1",
-			"kind": "Deployment",
-			"metadata": {
-				"name": "web2",
-				"namespace": "default"
-			},
-			"spec": {
-				"replicas": 1,
-				"selector": {
-				"matchLabels": {
-					"app": "web2"
-				}
-				},
-				"template": {
-				"metadata": {
-					"labels": {
-					"app": "web2"
-					}
-				},
-				"spec": {
-					"containers": [
-					{
-						"image": "quay.io/jitesoft/nginx",
-						"imagePullPolicy": "IfNotPresent",
-						"name": "nginx"
-					}
-					]
-				}
-				}
-			}
-		}
-	}
-}`, consumerID))
-
-			evt := &event.Event{}
-			err := json.Unmarshal(webDeployCEJSON, evt)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			evtExtensions := evt.Context.GetExtensions()
-			ceResourceID, err = cloudeventstypes.ToString(evtExtensions[cetypes.ExtensionResourceID])
+			builder := ctx.Value("grpc-manifest-builder").(*manifestevent.Builder)
+			pbEvt, err := builder.BuildProto(manifestevent.ActionCreate, newNginxDeployment("web2", 1))
 			if err != nil {
 				t.Fatal(err)
 			}
-
-			pbEvt, err := cepbv2.ToProto(evt)
-			if err != nil {
-				log.Fatal(err)
-			}
+			ceResourceID = builder.ResourceID()
 
 			pbCESendResp, err := grpcClient.Send(ctx, pbEvt)
 			if err != nil {
@@ -132,10 +63,10 @@ func TestManifestGRPCService(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{Name: "web2", Namespace: "default"},
 			}
 
-			err = wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(webDep, func(object k8s.Object) bool {
+			err = wait.For(objectMatch(cfg, webDep, func(object client.Object) bool {
 				d := object.(*appsv1.Deployment)
 				return d.Status.ReadyReplicas == 1
-			}), wait.WithTimeout(time.Minute*2))
+			}), wait.WithTimeout(time.Minute*2), wait.WithInterval(pollInterval()))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -145,25 +76,19 @@ func TestManifestGRPCService(t *testing.T) {
 		}).
 		Assess("should be able to watch the manifest status update event", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			// watch the manifest status
-			grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
-			watchClient, err := grpcClient.Watch(ctx, &maestropbv1.ResourceWatchRequest{Id: ceResourceID})
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			newPbEvt, err := watchClient.Recv()
-			if err != nil {
-				log.Fatalf("failed to receive event: %v", err)
-			}
+			recorder := ctx.Value("grpc-manifest-watch").(*watchrecorder.Recorder)
+			defer recorder.Stop(time.Second * 30)
 
-			newEvt, err := cepbv2.FromProto(newPbEvt)
+			record, err := recorder.WaitFor(func(record watchrecorder.Record) bool {
+				return record.Event.Source() == consumerID
+			}, time.Minute*2)
 			if err != nil {
-				log.Fatalf("failed to convert protobuf to cloudevent: %v", err)
+				t.Fatalf("failed to receive event: %v", err)
 			}
 
-			newEvtJSON, err := json.Marshal(newEvt)
+			newEvtJSON, err := json.Marshal(record.Event)
 			if err != nil {
-				log.Fatalf("failed to marshal cloudevent: %v", err)
+				t.Fatalf("failed to marshal cloudevent: %v", err)
 			}
 
 			t.Logf("received event for manifest status update:\n%s\n", newEvtJSON)
@@ -172,62 +97,10 @@ func TestManifestGRPCService(t *testing.T) {
 		Assess("should be able to update the manifest", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			// update the manifest
 			grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
-			webDeployCEJSON := []byte(fmt.Sprintf(`
-{
-	"id": "b89d65c9-954a-4718-8d0b-6ae70122ada7",
-	"specversion": "1.0",
-	"time": "2023-09-02T17:31:00Z",
-	"datacontenttype": "application/json",
-	"source": "maestro",
-	"type": "io.open-cluster-management.works.v1alpha1.manifests.spec.update_request",
-	"clustername": "%s",
-	"resourceid": "8a178798-f445-459c-ad66-6645246ec811",
-	"resourceversion": "2",
-	"data": {
-		"manifest": {
-			"apiVersion": "apps/v1",
-			"kind": "Deployment",
-			"metadata": {
-				"name": "web2",
-				"namespace": "default"
-			},
-			"spec": {
-				"replicas": 2,
-				"selector": {
-				"matchLabels": {
-					"app": "web2"
-				}
-				},
-				"template": {
-				"metadata": {
-					"labels": {
-					"app": "web2"
-					}
-				},
-				"spec": {
-					"containers": [
-					{
-						"image": "quay.io/jitesoft/nginx",
-						"imagePullPolicy": "IfNotPresent",
-						"name": "nginx"
-					}
-					]
-				}
-				}
-			}
-		}
-	}
-}`, consumerID))
-
-			evt := &event.Event{}
-			err := json.Unmarshal(webDeployCEJSON, evt)
+			builder := ctx.Value("grpc-manifest-builder").(*manifestevent.Builder)
+			pbEvt, err := builder.BuildProto(manifestevent.ActionUpdate, newNginxDeployment("web2", 2))
 			if err != nil {
-				log.Fatal(err)
-			}
-
-			pbEvt, err := cepbv2.ToProto(evt)
-			if err != nil {
-				log.Fatal(err)
+				t.Fatal(err)
 			}
 
 			pbCESendResp, err := grpcClient.Send(ctx, pbEvt)
@@ -239,10 +112,10 @@ func TestManifestGRPCService(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{Name: "web2", Namespace: "default"},
 			}
 
-			err = wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(webDep, func(object k8s.Object) bool {
+			err = wait.For(objectMatch(cfg, webDep, func(object client.Object) bool {
 				d := object.(*appsv1.Deployment)
 				return d.Status.ReadyReplicas == 2
-			}), wait.WithTimeout(time.Minute*2))
+			}), wait.WithTimeout(time.Minute*2), wait.WithInterval(pollInterval()))
 			if err != nil {
 				t.Fatal(err)
 			}
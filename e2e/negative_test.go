@@ -0,0 +1,438 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cepb "github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/restclient"
+)
+
+// maestro returns the errors of its services and of the database as they are, they reach the
+// clients with codes.Unknown and HTTP 500, only the request decoding errors of the gateway are
+// reported with codes.InvalidArgument and HTTP 400. The messages are matched as regular expressions.
+
+// acceptDefaultMarshaler is the Accept header that selects the default JSON marshaler of the gateway,
+// maestro registers no marshaler for application/json, so that Accept header falls back to the
+// cloudevents marshaler of the Content-Type
+const acceptDefaultMarshaler = "*"
+
+// grpcErrorCase is a gRPC call that maestro must reject
+type grpcErrorCase struct {
+	name    string
+	call    func(ctx context.Context, conn *grpc.ClientConn) error
+	code    codes.Code
+	message string
+}
+
+// restErrorCase is a REST request that maestro must reject, the gateway responds with the
+// google.rpc.Status of the failed call
+type restErrorCase struct {
+	name        string
+	method      string
+	path        string
+	contentType string
+	body        []byte
+	// the gateway marshals the errors with the marshaler registered for the Accept header, or of the
+	// Content-Type when none is, the cloudevents marshaler cannot marshal a status and replaces the error.
+	// Only the cloudevents marshaler and the default one, registered as "*", are registered by maestro
+	accept     string
+	statusCode int
+	code       codes.Code
+	message    string
+}
+
+func TestNegativeAPI(t *testing.T) {
+	testenv.Test(t,
+		features.New("Negative GRPC API").
+			WithLabel("type", "grpc").
+			WithLabel("tier", "hermetic").
+			Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				if consumerID == "" {
+					t.Fatal("consumerID is empty")
+				}
+				return ctx
+			}).
+			Assess("should reject the invalid requests", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+				for _, c := range grpcErrorCases(t) {
+					t.Run(c.name, func(t *testing.T) {
+						err := c.call(ctx, conn)
+						if err == nil {
+							t.Fatalf("expected %s error, got none", c.code)
+						}
+
+						st := status.Convert(err)
+						if st.Code() != c.code {
+							t.Errorf("expected code %s, got %s: %s", c.code, st.Code(), st.Message())
+						}
+						if !regexp.MustCompile(c.message).MatchString(st.Message()) {
+							t.Errorf("expected message matching %q, got %q", c.message, st.Message())
+						}
+					})
+				}
+				return ctx
+			}).
+			Feature(),
+		features.New("Negative REST API").
+			WithLabel("type", "rest").
+			WithLabel("tier", "hermetic").
+			Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				if consumerID == "" {
+					t.Fatal("consumerID is empty")
+				}
+				return ctx
+			}).
+			Assess("should reject the invalid requests", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				for _, c := range restErrorCases(t) {
+					t.Run(c.name, func(t *testing.T) {
+						client := newRESTClient(ctx)
+						if c.accept != "" {
+							client = client.WithHeader("Accept", c.accept)
+						}
+
+						err := client.Do(ctx, c.method, c.path, c.contentType, c.body, nil)
+						if err == nil {
+							t.Fatalf("expected HTTP %d, got 200", c.statusCode)
+						}
+
+						restErr, ok := err.(*restclient.Error)
+						if !ok {
+							t.Fatal(err)
+						}
+						if restErr.StatusCode != c.statusCode {
+							t.Errorf("expected HTTP %d, got %d: %s", c.statusCode, restErr.StatusCode, restErr.Body)
+						}
+
+						st, err := restErr.Status()
+						if err != nil {
+							t.Fatal(err)
+						}
+						if codes.Code(st.Code) != c.code {
+							t.Errorf("expected code %s, got %s: %s", c.code, codes.Code(st.Code), st.Message)
+						}
+						if !regexp.MustCompile(c.message).MatchString(st.Message) {
+							t.Errorf("expected message matching %q, got %q", c.message, st.Message)
+						}
+					})
+				}
+				return ctx
+			}).
+			Feature(),
+	)
+}
+
+func grpcErrorCases(t *testing.T) []grpcErrorCase {
+	unknownID := uuid.NewString()
+	objStruct, err := toStruct(newNginxDeployment("negative", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(evt *cloudevents.Event) func(ctx context.Context, conn *grpc.ClientConn) error {
+		return func(ctx context.Context, conn *grpc.ClientConn) error {
+			pbEvt, err := cepbv2.ToProto(evt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = maestropbv1.NewCloudEventsServiceClient(conn).Send(ctx, pbEvt)
+			return err
+		}
+	}
+
+	return []grpcErrorCase{
+		{
+			name: "read unknown consumer",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := maestropbv1.NewConsumerServiceClient(conn).Read(ctx, &maestropbv1.ConsumerReadRequest{Id: unknownID})
+				return err
+			},
+			code:    codes.Unknown,
+			message: "^Resource not found$",
+		},
+		{
+			name: "update unknown consumer",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := maestropbv1.NewConsumerServiceClient(conn).Update(ctx, &maestropbv1.ConsumerUpdateRequest{Id: unknownID})
+				return err
+			},
+			code:    codes.Unknown,
+			message: "^Resource not found$",
+		},
+		{
+			name: "read unknown resource",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := maestropbv1.NewResourceServiceClient(conn).Read(ctx, &maestropbv1.ResourceReadRequest{Id: unknownID})
+				return err
+			},
+			code:    codes.Unknown,
+			message: "^Resource not found$",
+		},
+		{
+			name: "update unknown resource",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := maestropbv1.NewResourceServiceClient(conn).Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: unknownID, Object: objStruct})
+				return err
+			},
+			code:    codes.Unknown,
+			message: "^Resource not found$",
+		},
+		{
+			name: "empty cloudevent",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := maestropbv1.NewCloudEventsServiceClient(conn).Send(ctx, &cepb.CloudEvent{})
+				return err
+			},
+			code:    codes.Unknown,
+			message: "^failed to parse cloud event type , ",
+		},
+		{
+			name:    "unknown cloudevent type",
+			call:    send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) { evt.SetType("io.example.unknown") })),
+			code:    codes.Unknown,
+			message: "^failed to parse cloud event type io.example.unknown, ",
+		},
+		{
+			name: "unsupported cloudevent data type",
+			call: send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) {
+				evt.SetType("io.open-cluster-management.works.v1alpha1.manifestbundles.spec.create_request")
+			})),
+			code:    codes.Unknown,
+			message: "^unsupported cloudevents data type io.open-cluster-management.works.v1alpha1.manifestbundles$",
+		},
+		{
+			name:    "missing resourceid extension",
+			call:    send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) { evt.SetExtension(cetypes.ExtensionResourceID, nil) })),
+			code:    codes.Unknown,
+			message: "^failed to get resourceid extension: ",
+		},
+		{
+			name:    "missing resourceversion extension",
+			call:    send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) { evt.SetExtension(cetypes.ExtensionResourceVersion, nil) })),
+			code:    codes.Unknown,
+			message: "^failed to get resourceversion extension: ",
+		},
+		{
+			name:    "missing clustername extension",
+			call:    send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) { evt.SetExtension(cetypes.ExtensionClusterName, nil) })),
+			code:    codes.Unknown,
+			message: "^failed to get clustername extension: ",
+		},
+		{
+			name:    "unsupported data content type",
+			call:    send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) { evt.SetDataContentType("application/xml") })),
+			code:    codes.Unknown,
+			message: "^unsupported data content type application/xml$",
+		},
+		{
+			name:    "malformed JSON data",
+			call:    send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) { evt.DataEncoded = []byte(`{"manifest": {`) })),
+			code:    codes.Unknown,
+			message: "^failed to unmarshal event data as resource: ",
+		},
+		{
+			name:    "non-object data",
+			call:    send(newInvalidManifestEvent(t, func(evt *cloudevents.Event) { evt.DataEncoded = []byte(`["nginx"]`) })),
+			code:    codes.Unknown,
+			message: "^failed to unmarshal event data as resource: json: cannot unmarshal array",
+		},
+	}
+}
+
+func restErrorCases(t *testing.T) []restErrorCase {
+	unknownID := uuid.NewString()
+	deployment, err := newNginxDeployment("negative", 1).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventJSON := func(mutate func(evt *cloudevents.Event)) []byte {
+		body, err := newInvalidManifestEvent(t, mutate).MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	return []restErrorCase{
+		{
+			name:       "get unknown consumer",
+			method:     http.MethodGet,
+			path:       "/v1/consumers/" + unknownID,
+			statusCode: http.StatusInternalServerError,
+			code:       codes.Unknown,
+			message:    "^Resource not found$",
+		},
+		{
+			name:        "update unknown consumer",
+			method:      http.MethodPut,
+			path:        "/v1/consumers/" + unknownID,
+			contentType: restclient.ContentTypeJSON,
+			body:        []byte(`{"labels": []}`),
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Unknown,
+			message:     "^Resource not found$",
+		},
+		{
+			name:       "get unknown resource",
+			method:     http.MethodGet,
+			path:       "/v1/resources/" + unknownID,
+			statusCode: http.StatusInternalServerError,
+			code:       codes.Unknown,
+			message:    "^Resource not found$",
+		},
+		{
+			name:        "update unknown resource",
+			method:      http.MethodPut,
+			path:        "/v1/resources/" + unknownID,
+			contentType: restclient.ContentTypeJSON,
+			body:        deployment,
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Unknown,
+			message:     "^Resource not found$",
+		},
+		{
+			name:        "create consumer with malformed JSON",
+			method:      http.MethodPost,
+			path:        "/v1/consumers",
+			contentType: restclient.ContentTypeJSON,
+			body:        []byte(`{"labels": [`),
+			statusCode:  http.StatusBadRequest,
+			code:        codes.InvalidArgument,
+			message:     "^unexpected EOF$",
+		},
+		{
+			name:        "create resource with malformed JSON",
+			method:      http.MethodPost,
+			path:        "/v1/consumers/" + consumerID + "/resources",
+			contentType: restclient.ContentTypeJSON,
+			body:        []byte(`{"apiVersion": "apps/v1", "kind": `),
+			statusCode:  http.StatusBadRequest,
+			code:        codes.InvalidArgument,
+			message:     "^unexpected EOF$",
+		},
+		{
+			name:        "create resource with a string object",
+			method:      http.MethodPost,
+			path:        "/v1/consumers/" + consumerID + "/resources",
+			contentType: restclient.ContentTypeJSON,
+			body:        []byte(`"nginx"`),
+			statusCode:  http.StatusBadRequest,
+			code:        codes.InvalidArgument,
+			message:     `^proto:.*unexpected token "nginx"`,
+		},
+		{
+			name:        "update resource with an array object",
+			method:      http.MethodPut,
+			path:        "/v1/resources/" + unknownID,
+			contentType: restclient.ContentTypeJSON,
+			body:        []byte(`["nginx"]`),
+			statusCode:  http.StatusBadRequest,
+			code:        codes.InvalidArgument,
+			message:     `^proto:.*unexpected token \[`,
+		},
+		{
+			name:        "cloudevent with malformed JSON",
+			method:      http.MethodPost,
+			path:        "/v1/cloudevents",
+			contentType: restclient.ContentTypeCloudEvents,
+			body:        []byte(`{"specversion": "1.0", `),
+			accept:      acceptDefaultMarshaler,
+			statusCode:  http.StatusBadRequest,
+			code:        codes.InvalidArgument,
+			message:     "^unexpected EOF$",
+		},
+		{
+			name:        "cloudevent with empty body",
+			method:      http.MethodPost,
+			path:        "/v1/cloudevents",
+			contentType: restclient.ContentTypeCloudEvents,
+			accept:      acceptDefaultMarshaler,
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Unknown,
+			message:     "^failed to parse cloud event type , ",
+		},
+		{
+			name:        "unknown cloudevent type",
+			method:      http.MethodPost,
+			path:        "/v1/cloudevents",
+			contentType: restclient.ContentTypeCloudEvents,
+			body:        eventJSON(func(evt *cloudevents.Event) { evt.SetType("io.example.unknown") }),
+			accept:      acceptDefaultMarshaler,
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Unknown,
+			message:     "^failed to parse cloud event type io.example.unknown, ",
+		},
+		{
+			name:        "missing resourceid extension",
+			method:      http.MethodPost,
+			path:        "/v1/cloudevents",
+			contentType: restclient.ContentTypeCloudEvents,
+			body:        eventJSON(func(evt *cloudevents.Event) { evt.SetExtension(cetypes.ExtensionResourceID, nil) }),
+			accept:      acceptDefaultMarshaler,
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Unknown,
+			message:     "^failed to get resourceid extension: ",
+		},
+		{
+			name:        "missing clustername extension",
+			method:      http.MethodPost,
+			path:        "/v1/cloudevents",
+			contentType: restclient.ContentTypeCloudEvents,
+			body:        eventJSON(func(evt *cloudevents.Event) { evt.SetExtension(cetypes.ExtensionClusterName, nil) }),
+			accept:      acceptDefaultMarshaler,
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Unknown,
+			message:     "^failed to get clustername extension: ",
+		},
+		{
+			// the event is decoded as a protobuf CloudEvent, the attributes and extensions
+			// of the structured format are unknown fields and are dropped
+			name:        "cloudevent with JSON content type",
+			method:      http.MethodPost,
+			path:        "/v1/cloudevents",
+			contentType: restclient.ContentTypeJSON,
+			body:        eventJSON(func(*cloudevents.Event) {}),
+			accept:      acceptDefaultMarshaler,
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Unknown,
+			message:     "^failed to get resourceid extension: ",
+		},
+		{
+			name:        "missing clustername extension without Accept header",
+			method:      http.MethodPost,
+			path:        "/v1/cloudevents",
+			contentType: restclient.ContentTypeCloudEvents,
+			body:        eventJSON(func(evt *cloudevents.Event) { evt.SetExtension(cetypes.ExtensionClusterName, nil) }),
+			statusCode:  http.StatusInternalServerError,
+			code:        codes.Internal,
+			message:     "^failed to marshal error message$",
+		},
+	}
+}
+
+// newInvalidManifestEvent returns a valid create request of a new resource changed by mutate,
+// maestro rejects the invalid events before they are stored
+func newInvalidManifestEvent(t *testing.T, mutate func(evt *cloudevents.Event)) *cloudevents.Event {
+	evt, err := manifestevent.NewBuilder(consumerID).Build(manifestevent.ActionCreate, newNginxDeployment("negative", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutate(evt)
+	return evt
+}
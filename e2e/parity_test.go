@@ -0,0 +1,268 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/parity"
+	"github.com/morvencao/maestro-e2e/utils/restclient"
+)
+
+// parityStep is a call of the parity scenario, it is made once through REST and once through GRPC,
+// each side keeps the ids it created in its own state
+type parityStep struct {
+	name string
+	rest func(ctx context.Context, client *restclient.Client, state map[string]string) (req parityRESTRequest, resp []byte, err error)
+	grpc func(ctx context.Context, conn *grpc.ClientConn, state map[string]string) (req, resp proto.Message, err error)
+}
+
+// parityRESTRequest is a REST request of the scenario, the body is the whole GRPC request
+// or the bodyField of it, as set by the http rule of the method
+type parityRESTRequest struct {
+	body       []byte
+	bodyField  string
+	pathParams map[string]interface{}
+}
+
+// grpcRequest returns the GRPC request the gateway makes of the REST request
+func (r parityRESTRequest) grpcRequest() (map[string]interface{}, error) {
+	req := map[string]interface{}{}
+	if r.body != nil {
+		var v interface{}
+		if err := json.Unmarshal(r.body, &v); err != nil {
+			return nil, err
+		}
+
+		if r.bodyField != "" {
+			req[r.bodyField] = v
+		} else if fields, ok := v.(map[string]interface{}); ok {
+			req = fields
+		}
+	}
+
+	for key, value := range r.pathParams {
+		req[key] = value
+	}
+	return req, nil
+}
+
+// the scenario creates a consumer of its own for each side, the consumers have no agent
+// so the resources are never applied and both sides report the same empty status
+func TestRESTGRPCParity(t *testing.T) {
+	feature := features.New("REST GRPC parity").
+		WithLabel("type", "parity").
+		WithLabel("tier", "hermetic").
+		Assess("should respond the same through REST and GRPC", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			client := newRESTClient(ctx)
+			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+			restState, grpcState := map[string]string{}, map[string]string{}
+			restNormalizer, grpcNormalizer := parity.NewNormalizer(parity.DefaultOptions()), parity.NewNormalizer(parity.DefaultOptions())
+			reported := map[string]bool{}
+
+			for _, step := range parityScenario() {
+				restReq, restResp, err := step.rest(ctx, client, restState)
+				if err != nil {
+					t.Fatalf("%s: REST: %v", step.name, err)
+				}
+				grpcReq, grpcResp, err := step.grpc(ctx, conn, grpcState)
+				if err != nil {
+					t.Fatalf("%s: GRPC: %v", step.name, err)
+				}
+
+				restGRPCReq, err := restReq.grpcRequest()
+				if err != nil {
+					t.Fatalf("%s: REST: %v", step.name, err)
+				}
+
+				reportParity(t, step.name+" request", restNormalizer, grpcNormalizer, restGRPCReq, mustFromProto(t, grpcReq), reported)
+				reportParity(t, step.name+" response", restNormalizer, grpcNormalizer, mustFromJSON(t, restResp), mustFromProto(t, grpcResp), reported)
+			}
+
+			for failure := range knownParityFailures {
+				if !reported[failure] {
+					t.Errorf("known parity failure %q is not reported anymore, remove it from knownParityFailures", failure)
+				}
+			}
+			return ctx
+		}).
+		Feature()
+
+	testenv.Test(t, feature)
+}
+
+// knownParityFailures are the fields maestro is known to handle differently over REST and GRPC, by
+// step and field path. They are logged instead of failing the test, and fail it once they are fixed
+var knownParityFailures = map[string]string{
+	// consumer_rest_test sends a name, the gateway drops it as the GRPC request has no name field
+	"create consumer request: name": "the consumer name is accepted over REST and not stored",
+}
+
+// reportParity reports every field that differs as a parity failure, the known failures are only logged
+func reportParity(t *testing.T, name string, restNormalizer, grpcNormalizer *parity.Normalizer, rest, grpc interface{}, reported map[string]bool) {
+	for _, d := range parity.Diff(restNormalizer.Normalize(rest), grpcNormalizer.Normalize(grpc)) {
+		failure := name + ": " + d.Path
+		if reason, ok := knownParityFailures[failure]; ok {
+			reported[failure] = true
+			t.Logf("known parity failure in %s: %s, %s", name, d, reason)
+			continue
+		}
+		t.Errorf("parity failure in %s: %s", name, d)
+	}
+}
+
+func parityScenario() []parityStep {
+	labels := func(value string) []*maestropbv1.ConsumerLabel {
+		return []*maestropbv1.ConsumerLabel{{Key: "maestro-e2e/parity", Value: value}}
+	}
+
+	return []parityStep{
+		{
+			name: "create consumer",
+			rest: func(ctx context.Context, client *restclient.Client, state map[string]string) (parityRESTRequest, []byte, error) {
+				resp, err := client.DoRaw(ctx, http.MethodPost, "/v1/consumers", restclient.ContentTypeJSON, restConsumerBody)
+				if err != nil {
+					return parityRESTRequest{}, nil, err
+				}
+
+				consumer := &maestropbv1.Consumer{}
+				if err := protojson.Unmarshal(resp, consumer); err != nil {
+					return parityRESTRequest{}, nil, err
+				}
+				state["consumer"] = consumer.Id
+				return parityRESTRequest{body: restConsumerBody}, resp, nil
+			},
+			grpc: func(ctx context.Context, conn *grpc.ClientConn, state map[string]string) (proto.Message, proto.Message, error) {
+				// the GRPC request carries the fields of the REST body that it has
+				req := &maestropbv1.ConsumerCreateRequest{}
+				if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(restConsumerBody, req); err != nil {
+					return nil, nil, err
+				}
+				consumer, err := maestropbv1.NewConsumerServiceClient(conn).Create(ctx, req)
+				if err != nil {
+					return nil, nil, err
+				}
+				state["consumer"] = consumer.Id
+				return req, consumer, nil
+			},
+		},
+		{
+			name: "read consumer",
+			rest: func(ctx context.Context, client *restclient.Client, state map[string]string) (parityRESTRequest, []byte, error) {
+				resp, err := client.DoRaw(ctx, http.MethodGet, "/v1/consumers/"+state["consumer"], "", nil)
+				return parityRESTRequest{pathParams: map[string]interface{}{"id": state["consumer"]}}, resp, err
+			},
+			grpc: func(ctx context.Context, conn *grpc.ClientConn, state map[string]string) (proto.Message, proto.Message, error) {
+				req := &maestropbv1.ConsumerReadRequest{Id: state["consumer"]}
+				consumer, err := maestropbv1.NewConsumerServiceClient(conn).Read(ctx, req)
+				return req, consumer, err
+			},
+		},
+		{
+			name: "update consumer",
+			rest: func(ctx context.Context, client *restclient.Client, state map[string]string) (parityRESTRequest, []byte, error) {
+				body := []byte(`{"labels": [{"key": "maestro-e2e/parity", "value": "v2"}]}`)
+				resp, err := client.DoRaw(ctx, http.MethodPut, "/v1/consumers/"+state["consumer"], restclient.ContentTypeJSON, body)
+				return parityRESTRequest{body: body, pathParams: map[string]interface{}{"id": state["consumer"]}}, resp, err
+			},
+			grpc: func(ctx context.Context, conn *grpc.ClientConn, state map[string]string) (proto.Message, proto.Message, error) {
+				req := &maestropbv1.ConsumerUpdateRequest{Id: state["consumer"], Labels: labels("v2")}
+				consumer, err := maestropbv1.NewConsumerServiceClient(conn).Update(ctx, req)
+				return req, consumer, err
+			},
+		},
+		{
+			name: "create resource",
+			rest: func(ctx context.Context, client *restclient.Client, state map[string]string) (parityRESTRequest, []byte, error) {
+				body, err := newNginxDeployment("parity", 1).MarshalJSON()
+				if err != nil {
+					return parityRESTRequest{}, nil, err
+				}
+
+				resp, err := client.DoRaw(ctx, http.MethodPost, "/v1/consumers/"+state["consumer"]+"/resources", restclient.ContentTypeJSON, body)
+				if err != nil {
+					return parityRESTRequest{}, nil, err
+				}
+
+				resource := &maestropbv1.Resource{}
+				if err := protojson.Unmarshal(resp, resource); err != nil {
+					return parityRESTRequest{}, nil, err
+				}
+				state["resource"] = resource.Id
+				return parityRESTRequest{body: body, bodyField: "object", pathParams: map[string]interface{}{"consumerId": state["consumer"]}}, resp, nil
+			},
+			grpc: func(ctx context.Context, conn *grpc.ClientConn, state map[string]string) (proto.Message, proto.Message, error) {
+				objStruct, err := toStruct(newNginxDeployment("parity", 1))
+				if err != nil {
+					return nil, nil, err
+				}
+
+				req := &maestropbv1.ResourceCreateRequest{ConsumerId: state["consumer"], Object: objStruct}
+				resource, err := maestropbv1.NewResourceServiceClient(conn).Create(ctx, req)
+				if err != nil {
+					return nil, nil, err
+				}
+				state["resource"] = resource.Id
+				return req, resource, nil
+			},
+		},
+		{
+			name: "read resource",
+			rest: func(ctx context.Context, client *restclient.Client, state map[string]string) (parityRESTRequest, []byte, error) {
+				resp, err := client.DoRaw(ctx, http.MethodGet, "/v1/resources/"+state["resource"], "", nil)
+				return parityRESTRequest{pathParams: map[string]interface{}{"id": state["resource"]}}, resp, err
+			},
+			grpc: func(ctx context.Context, conn *grpc.ClientConn, state map[string]string) (proto.Message, proto.Message, error) {
+				req := &maestropbv1.ResourceReadRequest{Id: state["resource"]}
+				resource, err := maestropbv1.NewResourceServiceClient(conn).Read(ctx, req)
+				return req, resource, err
+			},
+		},
+		{
+			name: "update resource",
+			rest: func(ctx context.Context, client *restclient.Client, state map[string]string) (parityRESTRequest, []byte, error) {
+				body, err := newNginxDeployment("parity", 2).MarshalJSON()
+				if err != nil {
+					return parityRESTRequest{}, nil, err
+				}
+
+				resp, err := client.DoRaw(ctx, http.MethodPut, "/v1/resources/"+state["resource"], restclient.ContentTypeJSON, body)
+				return parityRESTRequest{body: body, bodyField: "object", pathParams: map[string]interface{}{"id": state["resource"]}}, resp, err
+			},
+			grpc: func(ctx context.Context, conn *grpc.ClientConn, state map[string]string) (proto.Message, proto.Message, error) {
+				objStruct, err := toStruct(newNginxDeployment("parity", 2))
+				if err != nil {
+					return nil, nil, err
+				}
+
+				req := &maestropbv1.ResourceUpdateRequest{Id: state["resource"], Object: objStruct}
+				resource, err := maestropbv1.NewResourceServiceClient(conn).Update(ctx, req)
+				return req, resource, err
+			},
+		},
+	}
+}
+
+func mustFromProto(t *testing.T, msg proto.Message) interface{} {
+	v, err := parity.FromProto(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func mustFromJSON(t *testing.T, data []byte) interface{} {
+	v, err := parity.FromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
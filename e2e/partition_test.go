@@ -0,0 +1,161 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+// the work-agent is routed through an in-cluster toxiproxy stand-in to simulate network
+// partitions between the agent and the broker, kind does not enforce network policies
+const (
+	brokerHost      = "mosquitto.mqtt:1883"
+	brokerProxyHost = "mqtt-proxy.mqtt:1883"
+	brokerProxyName = "mosquitto"
+	brokerProxyPath = "../manifests/mqtt-proxy"
+)
+
+var brokerProxyTarget = chaosTarget{Name: "mqtt-proxy", Namespace: "mqtt"}
+
+// setWorkAgentBrokerHost points the work-agent to the given broker host and waits for the rollout
+func setWorkAgentBrokerHost(ctx context.Context, cfg *envconf.Config, host string) error {
+	var workAgentDep appsv1.Deployment
+	if err := cfg.Client().Resources().Get(ctx, workAgentTarget.Name, workAgentTarget.Namespace, &workAgentDep); err != nil {
+		return err
+	}
+
+	args := workAgentDep.Spec.Template.Spec.Containers[0].Args
+	for i, arg := range args {
+		if strings.Contains(arg, "--mqtt-broker-host=") {
+			args[i] = fmt.Sprintf("--mqtt-broker-host=%s", host)
+			break
+		}
+	}
+
+	if err := cfg.Client().Resources().Update(ctx, &workAgentDep); err != nil {
+		return err
+	}
+
+	return waitForComponentRollout(cfg, workAgentTarget)
+}
+
+// brokerProxyExec runs the toxiproxy cli in the proxy pod
+func brokerProxyExec(ctx context.Context, cfg *envconf.Config, args ...string) error {
+	pods := &corev1.PodList{}
+	if err := cfg.Client().Resources(brokerProxyTarget.Namespace).List(ctx, pods, resources.WithLabelSelector("app="+brokerProxyTarget.Name)); err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("no pod found for %s", brokerProxyTarget)
+	}
+
+	var stdout, stderr bytes.Buffer
+	command := append([]string{"/toxiproxy-cli"}, args...)
+	if err := cfg.Client().Resources().ExecInPod(ctx, brokerProxyTarget.Namespace, pods.Items[0].Name, "toxiproxy", command, &stdout, &stderr); err != nil {
+		return fmt.Errorf("failed to run %v: %v, stderr: %s", command, err, stderr.String())
+	}
+
+	return nil
+}
+
+// setupBrokerProxy installs the proxy and routes the work-agent through it
+func setupBrokerProxy() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if _, err := installComponent(brokerProxyPath)(ctx, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := waitForComponentReady(cfg, brokerProxyTarget); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := setWorkAgentBrokerHost(ctx, cfg, brokerProxyHost); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("work-agent routed through broker proxy: %s", brokerProxyHost)
+		return ctx
+	}
+}
+
+// teardownBrokerProxy routes the work-agent back to the broker and removes the proxy
+func teardownBrokerProxy() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := setWorkAgentBrokerHost(ctx, cfg, brokerHost); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := uninstallComponent(brokerProxyPath)(ctx, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		return ctx
+	}
+}
+
+// blackholeBroker silently drops all traffic between the work-agent and the broker,
+// the connections stay open so the partition is only detected by the mqtt keep alive
+func blackholeBroker() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		for _, stream := range []string{"upstream", "downstream"} {
+			if err := brokerProxyExec(ctx, cfg, "toxic", "add", "-n", "blackhole-"+stream, "-t", "timeout",
+				"--"+stream, "-a", "timeout=0", brokerProxyName); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		t.Logf("broker partitioned: blackhole")
+		return ctx
+	}
+}
+
+// delayBroker adds the given latency to the traffic from the broker to the work-agent
+func delayBroker(latency time.Duration) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := brokerProxyExec(ctx, cfg, "toxic", "add", "-n", "latency", "-t", "latency",
+			"-a", fmt.Sprintf("latency=%d", latency.Milliseconds()), brokerProxyName); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("broker partitioned: latency %s", latency)
+		return ctx
+	}
+}
+
+// healBroker removes all toxics from the proxy
+func healBroker() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		for _, toxic := range []string{"blackhole-upstream", "blackhole-downstream", "latency"} {
+			// the toxic is not there unless the matching partition was applied
+			_ = brokerProxyExec(ctx, cfg, "toxic", "remove", "-n", toxic, brokerProxyName)
+		}
+
+		t.Logf("broker partition healed")
+		return ctx
+	}
+}
+
+// flapBroker cuts the connectivity between the work-agent and the broker the given number of times,
+// the proxy closes all the connections when it is disabled
+func flapBroker(times int, interval time.Duration) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		for i := 0; i < times*2; i++ {
+			if err := brokerProxyExec(ctx, cfg, "toggle", brokerProxyName); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(interval)
+		}
+
+		t.Logf("broker connectivity flapped %d times", times)
+		return ctx
+	}
+}
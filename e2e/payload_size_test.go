@@ -0,0 +1,216 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	grpcstatus "google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+// the outcomes of a payload, a payload is expected to be applied, rejected by the API or reported
+// as failed by the agent, it must never be dropped without an error, nor applied without its status
+// reaching maestro
+const (
+	payloadApplied    = "applied"
+	payloadRejected   = "rejected"
+	payloadFailed     = "failed"
+	payloadUnreported = "unreported"
+	payloadDropped    = "dropped"
+)
+
+// payloadSizeAnnotation is the annotation of the size of the payload, it tells the payload applied on
+// the cluster from an older one of the same name
+const payloadSizeAnnotation = "maestro-e2e.io/payload-size"
+
+// payloadSizes grow across the limits of the paths: the 400 KB DynamoDB item, the 1 MB
+// ConfigMap and the 4 MB default GRPC message
+var payloadSizes = []int{16 << 10, 128 << 10, 256 << 10, 384 << 10, 512 << 10, 1 << 20, 3 << 20, 5 << 20}
+
+// payloadKind is a kind of the payloads, its objects of growing size are sent until one is not applied
+type payloadKind struct {
+	name string
+	// object returns the object whose payload is of the given size, the objects of an index have the same name
+	object func(api string, index, size int) *unstructured.Unstructured
+}
+
+var payloadKinds = []payloadKind{
+	{name: "configmap", object: newPayloadConfigMap},
+	{name: "crd", object: newPayloadCRD},
+}
+
+type payloadResult struct {
+	size    int
+	outcome string
+	message string
+}
+
+func TestPayloadSize(t *testing.T) {
+	if os.Getenv("PAYLOAD_TEST") != "true" {
+		t.Skip("payload testing is disabled, set PAYLOAD_TEST=true to enable it")
+	}
+
+	var payloadFeatures []features.Feature
+	for _, kind := range payloadKinds {
+		payloadFeatures = append(payloadFeatures,
+			payloadSizeFeature(workloadAPIResource, kind),
+			payloadSizeFeature(workloadAPIManifest, kind),
+		)
+	}
+	testenv.Test(t, payloadFeatures...)
+}
+
+// payloadSizeFeature sends objects of the kind of growing size through the API until one is not applied,
+// and reports the largest size that succeeds and the error of the first one that does not
+func payloadSizeFeature(api string, kind payloadKind) features.Feature {
+	return features.New(fmt.Sprintf("Payload size %s %s", kind.name, api)).
+		WithLabel("type", "grpc").
+		WithLabel("res", api).
+		WithLabel("mode", "payload").
+		WithLabel("kind", kind.name).
+		Setup(setupDeletionClients()).
+		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			return context.WithValue(ctx, "workload-state", &workloadState{builders: map[string]*manifestevent.Builder{}})
+		}).
+		Assess("should apply the payloads up to the limit and fail the larger one with an error", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			state := ctx.Value("workload-state").(*workloadState)
+			var results []payloadResult
+			for _, size := range payloadSizes {
+				result := sendPayload(ctx, cfg, api, kind, state, size)
+				results = append(results, result)
+				t.Logf("payload %s %s %d bytes: %s %s", kind.name, api, size, result.outcome, result.message)
+				if result.outcome != payloadApplied {
+					break
+				}
+			}
+
+			largest := 0
+			for _, result := range results {
+				if result.outcome == payloadApplied {
+					largest = result.size
+				}
+			}
+			t.Logf("largest %s payload applied through %s: %d bytes", kind.name, api, largest)
+
+			last := results[len(results)-1]
+			switch {
+			case last.outcome == payloadDropped:
+				t.Errorf("the payload of %d bytes was accepted but never applied nor reported as failed", last.size)
+			case last.outcome == payloadUnreported:
+				t.Errorf("the payload of %d bytes was applied but its status never reached maestro: %s", last.size, last.message)
+			case last.outcome != payloadApplied && last.message == "":
+				t.Errorf("the payload of %d bytes was %s without an error message", last.size, last.outcome)
+			}
+			return ctx
+		}).
+		Teardown(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			state := ctx.Value("workload-state").(*workloadState)
+			for i, id := range state.ids {
+				if err := deleteWorkloadObject(ctx, api, state, id, kind.object(api, i, 0)); err != nil {
+					t.Logf("failed to delete the payload %s: %v", id, err)
+				}
+			}
+			return ctx
+		}).
+		Feature()
+}
+
+// sendPayload sends an object of the kind of the size, and waits until it is applied or maestro reports
+// it as not applied
+func sendPayload(ctx context.Context, cfg *envconf.Config, api string, kind payloadKind, state *workloadState, size int) payloadResult {
+	index := len(state.ids)
+	obj := kind.object(api, index, size)
+	id, err := createWorkloadObject(ctx, api, state, obj)
+	if err != nil {
+		return payloadResult{size: size, outcome: payloadRejected, message: grpcstatus.Convert(err).Message()}
+	}
+
+	grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+	resourceStatus, err := status.WaitFor(ctx, grpcClient, id, func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+		return s.Condition(status.ConditionApplied) != nil
+	}, time.Minute*2)
+	if err != nil {
+		// the status may not fit in maestro, check the cluster to tell an unreported payload from a dropped one
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GroupVersionKind())
+		getErr := clusterClient(cfg).Get(ctx, client.ObjectKeyFromObject(obj), live)
+		if getErr == nil && live.GetAnnotations()[payloadSizeAnnotation] == strconv.Itoa(size) {
+			return payloadResult{size: size, outcome: payloadUnreported, message: err.Error()}
+		}
+		return payloadResult{size: size, outcome: payloadDropped, message: err.Error()}
+	}
+
+	if cond := resourceStatus.Condition(status.ConditionApplied); cond.Status != metav1.ConditionTrue {
+		return payloadResult{size: size, outcome: payloadFailed, message: cond.Message}
+	}
+	return payloadResult{size: size, outcome: payloadApplied}
+}
+
+// newPayloadConfigMap returns a ConfigMap whose payload is of the given size
+func newPayloadConfigMap(api string, index, size int) *unstructured.Unstructured {
+	cm := newWorkloadObject("v1", "ConfigMap", "default", fmt.Sprintf("payload-%s-%d", api, index), map[string]interface{}{
+		"data": map[string]interface{}{"payload": strings.Repeat("x", size)},
+	})
+	cm.SetAnnotations(map[string]string{payloadSizeAnnotation: strconv.Itoa(size)})
+	return cm
+}
+
+// newPayloadCRD returns a CustomResourceDefinition whose schema is of about the given size, the schema
+// has a documented property for each KB of the payload
+func newPayloadCRD(api string, index, size int) *unstructured.Unstructured {
+	properties := map[string]interface{}{}
+	for i := 0; i < size>>10; i++ {
+		properties[fmt.Sprintf("field%d", i)] = map[string]interface{}{
+			"type":        "string",
+			"description": strings.Repeat("x", 1<<10),
+		}
+	}
+
+	group := api + ".payload.maestro-e2e.io"
+	plural := fmt.Sprintf("payload%ds", index)
+	crd := newWorkloadObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", plural+"."+group, map[string]interface{}{
+		"spec": map[string]interface{}{
+			"group": group,
+			"names": map[string]interface{}{
+				"kind":     fmt.Sprintf("Payload%d", index),
+				"listKind": fmt.Sprintf("Payload%dList", index),
+				"plural":   plural,
+				"singular": fmt.Sprintf("payload%d", index),
+			},
+			"scope": "Namespaced",
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":    "v1",
+					"served":  true,
+					"storage": true,
+					"schema": map[string]interface{}{
+						"openAPIV3Schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"spec": map[string]interface{}{
+									"type":       "object",
+									"properties": properties,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	crd.SetAnnotations(map[string]string{payloadSizeAnnotation: strconv.Itoa(size)})
+	return crd
+}
@@ -0,0 +1,288 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/status"
+	"github.com/morvencao/maestro-e2e/utils/watchrecorder"
+)
+
+// the spec events are published by maestro, whose mqtt client id is the source id, to the spec topic of the consumer
+const (
+	brokerSourceID  = "maestro"
+	brokerUsername  = "admin"
+	brokerPassword  = "password"
+	redeliveryCount = 3
+	// redeliverySettle is the time without a new status event after which the rollout is reported
+	redeliverySettle = time.Second * 10
+)
+
+// The MQTT path is at-least-once, the same spec event may reach the work-agent several times. The agent
+// ignores the specs whose resourceversion is not greater than the applied one, so the redelivered events
+// must neither update the applied object again nor produce new status events. Each feature runs against
+// a consumer of its own, maestro does not set the resource id on the watched events, so the status events
+// of the manifest are the ones sent by its consumer.
+func TestRedelivery(t *testing.T) {
+	testenv.Test(t,
+		features.New("Manifest CloudEvents Send redelivery").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			Setup(setupWatchConsumer("work-agent-redelivery-grpc")).
+			Setup(startRedeliveryWatch()).
+			Assess("should be able to send the event", sendRedeliveryEvent("redelivery-grpc", sendWithGRPC)).
+			Assess("should apply the manifest and watch its status", assessRedeliveryApplied("redelivery-grpc")).
+			Assess("should be able to send the same event several times", redeliverEvent(sendWithGRPC)).
+			Assess("should apply the manifest once", assessAppliedOnce("redelivery-grpc")).
+			Assess("should not watch new status events", assessNoNewStatus()).
+			Teardown(deleteRedeliveryManifest("redelivery-grpc")).
+			Teardown(teardownWatchConsumer("work-agent-redelivery-grpc")).
+			Feature(),
+		features.New("Manifest REST redelivery").
+			WithLabel("type", "rest").
+			WithLabel("res", "manifest").
+			Setup(setupWatchConsumer("work-agent-redelivery-rest")).
+			Setup(startRedeliveryWatch()).
+			Assess("should be able to post the event", sendRedeliveryEvent("redelivery-rest", sendWithREST)).
+			Assess("should apply the manifest and watch its status", assessRedeliveryApplied("redelivery-rest")).
+			Assess("should be able to post the same event several times", redeliverEvent(sendWithREST)).
+			Assess("should apply the manifest once", assessAppliedOnce("redelivery-rest")).
+			Assess("should not watch new status events", assessNoNewStatus()).
+			Teardown(deleteRedeliveryManifest("redelivery-rest")).
+			Teardown(teardownWatchConsumer("work-agent-redelivery-rest")).
+			Feature(),
+		features.New("Manifest broker redelivery").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			Setup(setupWatchConsumer("work-agent-redelivery-broker")).
+			Setup(startRedeliveryWatch()).
+			Assess("should be able to send the event", sendRedeliveryEvent("redelivery-broker", sendWithGRPC)).
+			Assess("should apply the manifest and watch its status", assessRedeliveryApplied("redelivery-broker")).
+			Assess("should be able to republish the event to the broker several times", redeliverEvent(brokerPublish)).
+			Assess("should apply the manifest once", assessAppliedOnce("redelivery-broker")).
+			Assess("should not watch new status events", assessNoNewStatus()).
+			Teardown(deleteRedeliveryManifest("redelivery-broker")).
+			Teardown(teardownWatchConsumer("work-agent-redelivery-broker")).
+			Feature(),
+	)
+}
+
+// redeliverySender sends the spec event through one of the paths to the work-agent
+type redeliverySender func(ctx context.Context, cfg *envconf.Config, evt *cloudevents.Event) error
+
+func sendWithGRPC(ctx context.Context, cfg *envconf.Config, evt *cloudevents.Event) error {
+	pbEvt, err := cepbv2.ToProto(evt)
+	if err != nil {
+		return err
+	}
+
+	grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+	_, err = grpcClient.Send(ctx, pbEvt)
+	return err
+}
+
+func sendWithREST(ctx context.Context, cfg *envconf.Config, evt *cloudevents.Event) error {
+	_, err := newRESTClient(ctx).SendCloudEvent(ctx, evt)
+	return err
+}
+
+// startRedeliveryWatch records the status events of the consumer before the first event is sent
+func startRedeliveryWatch() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		ctx = context.WithValue(ctx, "redelivery-builder", manifestevent.NewBuilder(featureConsumerID(ctx)))
+		return context.WithValue(ctx, "redelivery-watch-recorder", subscribeWatch(ctx))
+	}
+}
+
+// sendRedeliveryEvent builds the spec event of the deployment and sends it once, the same event is
+// redelivered by the next steps
+func sendRedeliveryEvent(depName string, send redeliverySender) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		builder := ctx.Value("redelivery-builder").(*manifestevent.Builder)
+		evt, err := builder.Build(manifestevent.ActionCreate, newNginxDeployment(depName, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := send(ctx, cfg, evt); err != nil {
+			t.Fatal(err)
+		}
+		return context.WithValue(ctx, "redelivery-event", evt)
+	}
+}
+
+func redeliverEvent(send redeliverySender) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		evt := ctx.Value("redelivery-event").(*cloudevents.Event)
+		for i := 0; i < redeliveryCount; i++ {
+			if err := send(ctx, cfg, evt); err != nil {
+				t.Fatalf("failed to redeliver the event %s for the %d time: %v", evt.ID(), i+1, err)
+			}
+		}
+		return ctx
+	}
+}
+
+func deleteRedeliveryManifest(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if recorder, ok := ctx.Value("redelivery-watch-recorder").(*watchrecorder.Recorder); ok {
+			if err := recorder.Stop(time.Second * 30); err != nil {
+				t.Logf("failed to stop the watch recorder: %v", err)
+			}
+		}
+
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		builder := ctx.Value("redelivery-builder").(*manifestevent.Builder)
+		pbEvt, err := builder.BuildProto(manifestevent.ActionDelete, newNginxDeployment(depName, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := grpcClient.Send(ctx, pbEvt); err != nil {
+			t.Logf("failed to delete the manifest: %v", err)
+			return ctx
+		}
+
+		// the work-agent of the consumer is deleted next, the deployment must be removed before
+		if err := waitForDeploymentDeleted(cfg, depName, "default", time.Minute*2); err != nil {
+			t.Logf("failed to wait for deployment %s deletion: %v", depName, err)
+		}
+		return ctx
+	}
+}
+
+// assessRedeliveryApplied verifies that the deployment is applied and its status watched, and keeps the
+// number of status events of the first resourceversion once the agent stopped reporting the rollout
+func assessRedeliveryApplied(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 1, time.Minute*2); err != nil {
+			t.Fatal(err)
+		}
+
+		recorder := ctx.Value("redelivery-watch-recorder").(*watchrecorder.Recorder)
+		consumer := ctx.Value("watch-consumer-id").(string)
+		_, err := recorder.WaitFor(func(record watchrecorder.Record) bool {
+			version, err := record.ResourceVersion()
+			return err == nil && record.Event.Source() == consumer && version == 1 &&
+				record.HasCondition(status.ConditionApplied, metav1.ConditionTrue)
+		}, time.Minute*2)
+		if err != nil {
+			t.Fatalf("failed to watch the applied status event: %v", err)
+		}
+
+		count := redeliveryStatusCount(ctx)
+		deadline := time.Now().Add(time.Minute * 2)
+		for {
+			time.Sleep(redeliverySettle)
+			next := redeliveryStatusCount(ctx)
+			if next == count {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the status events of the consumer are still received after %s, %d so far", time.Minute*2, next)
+			}
+			count = next
+		}
+
+		t.Logf("watched %d status events of resourceversion 1", count)
+		return context.WithValue(ctx, "redelivery-status-count", count)
+	}
+}
+
+// assessAppliedOnce verifies that the deployment keeps its first generation while the redelivered
+// events reach the agent, and that maestro keeps the first generationId
+func assessAppliedOnce(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		deadline := time.Now().Add(holdWindow())
+		for time.Now().Before(deadline) {
+			var dep appsv1.Deployment
+			if err := cfg.Client().Resources().Get(ctx, depName, "default", &dep); err != nil {
+				t.Fatal(err)
+			}
+			if dep.Generation != 1 {
+				t.Fatalf("expected the deployment to be applied once, got generation %d", dep.Generation)
+			}
+			time.Sleep(pollInterval())
+		}
+
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		builder := ctx.Value("redelivery-builder").(*manifestevent.Builder)
+		pbResource, err := grpcClient.Read(ctx, &maestropbv1.ResourceReadRequest{Id: builder.ResourceID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pbResource.GenerationId != 1 {
+			t.Fatalf("expected generationId 1, got %d", pbResource.GenerationId)
+		}
+		return ctx
+	}
+}
+
+// assessNoNewStatus verifies that no status event of the first resourceversion is watched after the
+// redelivered events
+func assessNoNewStatus() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		before := ctx.Value("redelivery-status-count").(int)
+		if after := redeliveryStatusCount(ctx); after != before {
+			t.Fatalf("watched %d new status events after the redelivery", after-before)
+		}
+		return ctx
+	}
+}
+
+// redeliveryStatusCount returns the number of status events of the first resourceversion sent by the
+// consumer of the feature
+func redeliveryStatusCount(ctx context.Context) int {
+	recorder := ctx.Value("redelivery-watch-recorder").(*watchrecorder.Recorder)
+	count := 0
+	for _, record := range watchrecorder.FromSource(recorder.Records(), ctx.Value("watch-consumer-id").(string)) {
+		if version, err := record.ResourceVersion(); err == nil && version == 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// brokerPublish publishes the event in the structured mode to the spec topic of the consumer, with
+// the mosquitto client of the broker pod, the same way maestro publishes the spec events
+func brokerPublish(ctx context.Context, cfg *envconf.Config, evt *cloudevents.Event) error {
+	pods := &corev1.PodList{}
+	if err := cfg.Client().Resources(brokerTarget.Namespace).List(ctx, pods, resources.WithLabelSelector("app="+brokerTarget.Name)); err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("no pod found for %s", brokerTarget)
+	}
+
+	payload, err := evt.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	command := []string{
+		"mosquitto_pub", "-h", "localhost", "-u", brokerUsername, "-P", brokerPassword,
+		"-V", "mqttv5", "-q", "1",
+		"-t", fmt.Sprintf("sources/%s/clusters/%s/spec", brokerSourceID, featureConsumerID(ctx)),
+		"-D", "PUBLISH", "user-property", "Content-Type", cloudevents.ApplicationCloudEventsJSON,
+		"-m", string(payload),
+	}
+	if err := cfg.Client().Resources().ExecInPod(ctx, brokerTarget.Namespace, pods.Items[0].Name, brokerTarget.Name, command, &stdout, &stderr); err != nil {
+		return fmt.Errorf("failed to publish to the broker: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package e2e
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+)
+
+func TestResourceChaos(t *testing.T) {
+	if os.Getenv("CHAOS_TEST") != "true" {
+		t.Skip("chaos testing is disabled, set CHAOS_TEST=true to enable it")
+	}
+
+	testenv.Test(t,
+		resourceOutageFeature("Resource recovers from broker outage", brokerTarget, "chaos-broker"),
+		resourceOutageFeature("Resource recovers from work-agent outage", workAgentTarget, "chaos-agent"),
+		resourceMaestroRestartFeature(),
+		resourceDatabaseOutageFeature(),
+	)
+}
+
+// resourceOutageFeature creates and updates a resource while the target component is scaled to zero,
+// then brings it back and verifies both are applied and the status catches up
+func resourceOutageFeature(name string, target chaosTarget, depName string) features.Feature {
+	return features.New(name).
+		WithLabel("type", "grpc").
+		WithLabel("res", "resource").
+		WithLabel("mode", "chaos").
+		Setup(setupChaosResource(depName)).
+		Assess("stop the component", stopComponent(target)).
+		Assess("should be able to create a resource during the outage", createChaosResource(depName+"-created")).
+		Assess("should be able to update the resource during the outage", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			objStruct, err := toStruct(newNginxDeployment(depName, 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updateCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			pbResource, err := grpcClient.Update(updateCtx, &maestropbv1.ResourceUpdateRequest{
+				Id:     ctx.Value("chaos-resource-id").(string),
+				Object: objStruct,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Logf("resource updated during outage: %s", pbResource.Id)
+			return ctx
+		}).
+		Assess("start the component", startComponent(target)).
+		Assess("should apply the update and report the status after recovery", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 2, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := waitForResourceReadyReplicas(ctx, grpcClient, ctx.Value("chaos-resource-id").(string), 2, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			return ctx
+		}).
+		Assess("should apply the resource created during the outage after recovery", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			if err := waitForDeploymentReadyReplicas(cfg, depName+"-created", "default", 1, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := waitForResourceReadyReplicas(ctx, grpcClient, ctx.Value(chaosResourceKey(depName+"-created")).(string), 1, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			return ctx
+		}).
+		Teardown(startComponent(target)).
+		Teardown(deleteChaosResource(depName + "-created")).
+		Teardown(deleteChaosResource(depName)).
+		Feature()
+}
+
+// resourceMaestroRestartFeature restarts maestro right after an update, before the status is reported back
+func resourceMaestroRestartFeature() features.Feature {
+	depName := "chaos-maestro"
+	return features.New("Resource recovers from maestro restart").
+		WithLabel("type", "grpc").
+		WithLabel("res", "resource").
+		WithLabel("mode", "chaos").
+		Setup(setupChaosResource(depName)).
+		Assess("should be able to update the resource", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			objStruct, err := toStruct(newNginxDeployment(depName, 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pbResource, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{
+				Id:     ctx.Value("chaos-resource-id").(string),
+				Object: objStruct,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Logf("resource updated: %s", pbResource.Id)
+			return ctx
+		}).
+		Assess("restart maestro", restartComponent(maestroTarget)).
+		Assess("should apply the update and report the status after recovery", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 2, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := waitForResourceReadyReplicas(ctx, grpcClient, ctx.Value("chaos-resource-id").(string), 2, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			return ctx
+		}).
+		Teardown(deleteChaosResource(depName)).
+		Feature()
+}
+
+// resourceDatabaseOutageFeature updates a resource while dynamodb is scaled to zero, the update fails
+// until dynamodb is back and is retried as a client would, then it must be applied. The consumer and the
+// resource stored before the outage must still be there after it.
+func resourceDatabaseOutageFeature() features.Feature {
+	depName := "chaos-dynamodb"
+	return features.New("Resource recovers from dynamodb outage").
+		WithLabel("type", "grpc").
+		WithLabel("res", "resource").
+		WithLabel("mode", "chaos").
+		Setup(setupChaosResource(depName)).
+		Assess("stop dynamodb", stopComponent(dynamodbTarget)).
+		Assess("should fail to update the resource during the outage", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			objStruct, err := toStruct(newNginxDeployment(depName, 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := &maestropbv1.ResourceUpdateRequest{
+				Id:     ctx.Value("chaos-resource-id").(string),
+				Object: objStruct,
+			}
+			updateCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			_, err = grpcClient.Update(updateCtx, req)
+			cancel()
+			if err == nil {
+				t.Fatal("expected resource update to fail while dynamodb is down")
+			}
+			t.Logf("resource update failed as expected: %v", err)
+
+			// the update is retried in the background until dynamodb is back
+			updated := make(chan error, 1)
+			go func() {
+				updated <- wait.For(func(context.Context) (bool, error) {
+					updateCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+					defer cancel()
+					_, err := grpcClient.Update(updateCtx, req)
+					return err == nil, nil
+				}, wait.WithInterval(time.Second*5), wait.WithTimeout(time.Minute*5))
+			}()
+			return context.WithValue(ctx, "chaos-updated", updated)
+		}).
+		Assess("start dynamodb", startComponent(dynamodbTarget)).
+		Assess("should keep the consumer and the resource stored before the outage", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			if err := <-ctx.Value("chaos-updated").(chan error); err != nil {
+				t.Fatalf("failed to update the resource after recovery: %v", err)
+			}
+
+			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+			if _, err := maestropbv1.NewConsumerServiceClient(conn).Read(ctx, &maestropbv1.ConsumerReadRequest{Id: consumerID}); err != nil {
+				t.Fatalf("failed to read the consumer after recovery: %v", err)
+			}
+
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			pbResource, err := grpcClient.Read(ctx, &maestropbv1.ResourceReadRequest{Id: ctx.Value("chaos-resource-id").(string)})
+			if err != nil {
+				t.Fatalf("failed to read the resource after recovery: %v", err)
+			}
+			if pbResource.GenerationId < 2 {
+				t.Fatalf("expected the retried update to bump the generation, got %d", pbResource.GenerationId)
+			}
+
+			return ctx
+		}).
+		Assess("should apply the update and report the status after recovery", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 2, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := waitForResourceReadyReplicas(ctx, grpcClient, ctx.Value("chaos-resource-id").(string), 2, time.Minute*3); err != nil {
+				t.Fatal(err)
+			}
+
+			return ctx
+		}).
+		Teardown(startComponent(dynamodbTarget)).
+		Teardown(deleteChaosResource(depName)).
+		Feature()
+}
+
+// setupChaosResource creates a deployment for the consumer of the feature through the resource service
+// and waits for it to be applied
+func setupChaosResource(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		consumer := featureConsumerID(ctx)
+		if consumer == "" {
+			t.Fatal("consumerID is empty")
+		}
+
+		conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+		grpcClient := maestropbv1.NewResourceServiceClient(conn)
+
+		objStruct, err := toStruct(newNginxDeployment(depName, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pbResource, err := grpcClient.Create(ctx, &maestropbv1.ResourceCreateRequest{
+			ConsumerId: consumer,
+			Object:     objStruct,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 1, time.Minute*2); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("resource created: %s", pbResource.Id)
+		ctx = context.WithValue(ctx, "grpc-resource-client", grpcClient)
+		ctx = context.WithValue(ctx, chaosResourceKey(depName), pbResource.Id)
+		return context.WithValue(ctx, "chaos-resource-id", pbResource.Id)
+	}
+}
+
+// chaosResourceKey is the context key of the id of the resource of the deployment, the context is passed
+// on from one feature to the next, so the key is not shared by the features
+func chaosResourceKey(depName string) string {
+	return "chaos-resource-id/" + depName
+}
+
+// createChaosResource returns a feature step that creates a deployment through the resource service
+// without waiting for it to be applied, e.g. while a component is down
+func createChaosResource(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		objStruct, err := toStruct(newNginxDeployment(depName, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		createCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		pbResource, err := grpcClient.Create(createCtx, &maestropbv1.ResourceCreateRequest{
+			ConsumerId: consumerID,
+			Object:     objStruct,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("resource created: %s", pbResource.Id)
+		return context.WithValue(ctx, chaosResourceKey(depName), pbResource.Id)
+	}
+}
+
+// deleteChaosResource returns a feature step that deletes the resource of the deployment, if it was
+// created, and waits for the deployment to be removed
+func deleteChaosResource(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		resourceID, ok := ctx.Value(chaosResourceKey(depName)).(string)
+		if !ok {
+			return ctx
+		}
+
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		objStruct, err := toStruct(markDeleted(newNginxDeployment(depName, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: resourceID, Object: objStruct}); err != nil {
+			t.Logf("failed to delete resource %s: %v", resourceID, err)
+			return ctx
+		}
+
+		if err := waitForDeploymentDeleted(cfg, depName, "default", time.Minute*2); err != nil {
+			t.Logf("failed to wait for deployment %s deletion: %v", depName, err)
+		}
+		return ctx
+	}
+}
@@ -0,0 +1,230 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/status"
+	"github.com/morvencao/maestro-e2e/utils/watchrecorder"
+)
+
+func TestResourceDeletion(t *testing.T) {
+	testenv.Test(t,
+		features.New("Resource GRPC deletion").
+			WithLabel("type", "grpc").
+			WithLabel("res", "resource").
+			WithLabel("tier", "hermetic").
+			Setup(setupWatchConsumer("work-agent-delete-grpc")).
+			Setup(subscribeWatchRecorder()).
+			Setup(createGRPCDeletionResource("delete-grpc")).
+			Assess("should be able to delete the resource", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				objStruct, err := toStruct(markDeleted(newNginxDeployment("delete-grpc", 1)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				pbResource, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{
+					Id:     ctx.Value("deletion-resource-id").(string),
+					Object: objStruct,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				t.Logf("resource deleted: %s", pbResource.Id)
+				return ctx
+			}).
+			Assess("should remove the deployment and the applied manifestwork", assessRemoved("delete-grpc")).
+			Assess("should report the deleted condition", assessDeletedCondition()).
+			Assess("should receive the deleted status event", assessDeletedEvent()).
+			Teardown(stopWatchRecorders()).
+			Teardown(teardownWatchConsumer("work-agent-delete-grpc")).
+			Feature(),
+		features.New("Resource REST deletion").
+			WithLabel("type", "rest").
+			WithLabel("res", "resource").
+			WithLabel("tier", "hermetic").
+			Setup(setupWatchConsumer("work-agent-delete-rest")).
+			Setup(subscribeWatchRecorder()).
+			Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				objStruct, err := toStruct(newNginxDeployment("delete-rest", 1))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				resource, err := newRESTClient(ctx).CreateResource(ctx, featureConsumerID(ctx), objStruct)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := waitForDeploymentReadyReplicas(cfg, "delete-rest", "default", 1, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+
+				return context.WithValue(ctx, "deletion-resource-id", resource.Id)
+			}).
+			Assess("should be able to delete the resource", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				objStruct, err := toStruct(markDeleted(newNginxDeployment("delete-rest", 1)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				resource, err := newRESTClient(ctx).UpdateResource(ctx, ctx.Value("deletion-resource-id").(string), objStruct)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				t.Logf("resource deleted: %s", resource.Id)
+				return ctx
+			}).
+			Assess("should remove the deployment and the applied manifestwork", assessRemoved("delete-rest")).
+			Assess("should report the deleted condition", assessDeletedCondition()).
+			Assess("should receive the deleted status event", assessDeletedEvent()).
+			Teardown(stopWatchRecorders()).
+			Teardown(teardownWatchConsumer("work-agent-delete-rest")).
+			Feature(),
+		features.New("Manifest CloudEvents deletion").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			WithLabel("tier", "hermetic").
+			Setup(setupWatchConsumer("work-agent-delete-manifest")).
+			Setup(subscribeWatchRecorder()).
+			Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+				builder := manifestevent.NewBuilder(featureConsumerID(ctx))
+				pbEvt, err := builder.BuildProto(manifestevent.ActionCreate, newNginxDeployment("delete-manifest", 1))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := grpcClient.Send(ctx, pbEvt); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := waitForDeploymentReadyReplicas(cfg, "delete-manifest", "default", 1, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+
+				ctx = context.WithValue(ctx, "deletion-manifest-builder", builder)
+				return context.WithValue(ctx, "deletion-resource-id", builder.ResourceID())
+			}).
+			Assess("should be able to delete the manifest", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+				builder := ctx.Value("deletion-manifest-builder").(*manifestevent.Builder)
+				pbEvt, err := builder.BuildProto(manifestevent.ActionDelete, newNginxDeployment("delete-manifest", 1))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				pbCESendResp, err := grpcClient.Send(ctx, pbEvt)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				t.Logf("manifest deleted: %s", pbCESendResp.Status)
+				return ctx
+			}).
+			Assess("should remove the deployment and the applied manifestwork", assessRemoved("delete-manifest")).
+			Assess("should report the deleted condition", assessDeletedCondition()).
+			Assess("should receive the deleted status event", assessDeletedEvent()).
+			Teardown(stopWatchRecorders()).
+			Teardown(teardownWatchConsumer("work-agent-delete-manifest")).
+			Feature(),
+	)
+}
+
+func setupDeletionClients() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if consumerID == "" {
+			t.Fatal("consumerID is empty")
+		}
+
+		conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+		ctx = context.WithValue(ctx, "grpc-resource-client", maestropbv1.NewResourceServiceClient(conn))
+		return context.WithValue(ctx, "grpc-manifest-client", maestropbv1.NewCloudEventsServiceClient(conn))
+	}
+}
+
+func createGRPCDeletionResource(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		objStruct, err := toStruct(newNginxDeployment(depName, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pbResource, err := grpcClient.Create(ctx, &maestropbv1.ResourceCreateRequest{
+			ConsumerId: featureConsumerID(ctx),
+			Object:     objStruct,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 1, time.Minute*2); err != nil {
+			t.Fatal(err)
+		}
+
+		return context.WithValue(ctx, "deletion-resource-id", pbResource.Id)
+	}
+}
+
+// assessRemoved verifies that the deployment and the AppliedManifestWork of the resource are removed
+func assessRemoved(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := waitForDeploymentDeleted(cfg, depName, "default", time.Minute*2); err != nil {
+			t.Fatalf("deployment %s is not deleted: %v", depName, err)
+		}
+
+		// the fake work-agent keeps no AppliedManifestWork
+		if runsAgainstFake() {
+			return ctx
+		}
+
+		resourceID := ctx.Value("deletion-resource-id").(string)
+		if err := waitForAppliedManifestWorkDeleted(ctx, cfg, resourceID, time.Minute*2); err != nil {
+			t.Fatalf("applied manifestwork of resource %s is not deleted: %v", resourceID, err)
+		}
+
+		return ctx
+	}
+}
+
+func assessDeletedCondition() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		resourceID := ctx.Value("deletion-resource-id").(string)
+		_, err := status.WaitForResourceCondition(ctx, grpcClient, resourceID, status.ConditionDeleted, metav1.ConditionTrue, time.Minute*2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ctx
+	}
+}
+
+// assessDeletedEvent verifies that the deleted status is watched, maestro does not set the
+// resource id on the watched events, so they are matched by the consumer of the feature
+func assessDeletedEvent() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		recorder := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)[0]
+		watchConsumerID := ctx.Value("watch-consumer-id").(string)
+		_, err := recorder.WaitFor(func(record watchrecorder.Record) bool {
+			return record.Event.Source() == watchConsumerID && record.HasCondition(status.ConditionDeleted, metav1.ConditionTrue)
+		}, time.Minute*2)
+		if err != nil {
+			t.Fatalf("failed to watch the deleted status event: %v", err)
+		}
+
+		return ctx
+	}
+}
@@ -11,38 +11,27 @@ import (
 	"google.golang.org/protobuf/types/known/structpb"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/e2e-framework/klient/wait"
-	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
 	"sigs.k8s.io/e2e-framework/pkg/envconf"
 	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/status"
 )
 
 func TestResourceGRPCService(t *testing.T) {
 	resourceFeature := features.New("Resource GRPC Service").
 		WithLabel("type", "grpc").
 		WithLabel("res", "resource").
+		WithLabel("tier", "hermetic").
 		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			if consumerID == "" {
 				t.Fatal("consumerID is empty")
 			}
 
-			client, err := cfg.NewClient()
-			if err != nil {
+			if err := waitForWorkAgentAvailable(cfg, time.Minute*2); err != nil {
 				t.Fatal(err)
 			}
-			workAgentDep := &appsv1.Deployment{
-				ObjectMeta: metav1.ObjectMeta{Name: "work-agent", Namespace: "open-cluster-management-agent"},
-			}
-			// wait for the deployment to become at least 50%
-			err = wait.For(conditions.New(client.Resources()).ResourceMatch(workAgentDep, func(object k8s.Object) bool {
-				d := object.(*appsv1.Deployment)
-				return float64(d.Status.ReadyReplicas)/float64(*d.Spec.Replicas) >= 0.50
-			}), wait.WithTimeout(time.Minute*2))
-			if err != nil {
-				t.Fatal(err)
-			}
-			// t.Logf("deployment availability: %.2f%%", float64(workAgentDep.Status.ReadyReplicas)/float64(*workAgentDep.Spec.Replicas)*100)
 
 			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
 			grpcClient := maestropbv1.NewResourceServiceClient(conn)
@@ -107,10 +96,10 @@ func TestResourceGRPCService(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{Name: "nginx2", Namespace: "default"},
 			}
 
-			err = wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(nginxDep, func(object k8s.Object) bool {
+			err = wait.For(objectMatch(cfg, nginxDep, func(object client.Object) bool {
 				d := object.(*appsv1.Deployment)
 				return d.Status.ReadyReplicas == 1
-			}), wait.WithTimeout(time.Minute*2))
+			}), wait.WithTimeout(time.Minute*2), wait.WithInterval(pollInterval()))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -139,14 +128,17 @@ func TestResourceGRPCService(t *testing.T) {
 					return false, nil
 				}
 
-				contentStatus := pbResource.Status.Fields["contentStatus"]
-				readyReplicas := contentStatus.GetStructValue().Fields["readyReplicas"]
-				if readyReplicas.GetNumberValue() != float64(1) {
+				resourceStatus, err := status.FromResource(pbResource)
+				if err != nil {
+					return false, err
+				}
+
+				if readyReplicas, _ := resourceStatus.ContentStatus.Int64("readyReplicas"); readyReplicas != 1 {
 					return false, nil
 				}
 
 				return true, nil
-			}, wait.WithTimeout(time.Minute*2), wait.WithInterval(time.Second*5))
+			}, wait.WithTimeout(time.Minute*2), wait.WithInterval(pollInterval()))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -154,6 +146,47 @@ func TestResourceGRPCService(t *testing.T) {
 			t.Logf("resource retrieved: %s", pbResource.Id)
 			return ctx
 		}).
+		Assess("should report the resource conditions", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			for _, conditionType := range []string{status.ConditionApplied, status.ConditionAvailable} {
+				_, err := status.WaitForResourceCondition(ctx, grpcClient, resourceID, conditionType, metav1.ConditionTrue, time.Minute*2)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			t.Logf("resource conditions reported: %s", resourceID)
+			return ctx
+		}).
+		Assess("should be applied by the agent", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			if runsAgainstFake() {
+				t.Skip("the simulated work-agent keeps no AppliedManifestWork")
+			}
+
+			view, err := getAgentView(ctx, cfg, resourceID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if view == nil || view.AppliedManifestWork == nil {
+				t.Fatalf("no applied manifestwork found for resource %s", resourceID)
+			}
+
+			applied := view.AppliedResources()
+			if len(applied) != 1 || applied[0].Resource != "deployments" || applied[0].Name != "nginx2" {
+				t.Fatalf("expected the applied deployment nginx2, got %v", applied)
+			}
+
+			var dep appsv1.Deployment
+			if err := cfg.Client().Resources().Get(ctx, "nginx2", "default", &dep); err != nil {
+				t.Fatal(err)
+			}
+			if !view.Owns(&dep) {
+				t.Fatalf("deployment nginx2 is not owned by the applied manifestwork %s", view.AppliedManifestWork.Name)
+			}
+
+			t.Logf("resource applied by the agent: %s", view.AppliedManifestWork.Name)
+			return ctx
+		}).
 		Assess("should be able to update the resource", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
 			// update the resource
 			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
@@ -213,10 +246,10 @@ func TestResourceGRPCService(t *testing.T) {
 				ObjectMeta: metav1.ObjectMeta{Name: "nginx2", Namespace: "default"},
 			}
 
-			err = wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(nginxDep, func(object k8s.Object) bool {
+			err = wait.For(objectMatch(cfg, nginxDep, func(object client.Object) bool {
 				d := object.(*appsv1.Deployment)
 				return d.Status.ReadyReplicas == 2
-			}), wait.WithTimeout(time.Minute*2))
+			}), wait.WithTimeout(time.Minute*2), wait.WithInterval(pollInterval()))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -244,14 +277,17 @@ func TestResourceGRPCService(t *testing.T) {
 					return false, nil
 				}
 
-				contentStatus := pbResource.Status.Fields["contentStatus"]
-				readyReplicas := contentStatus.GetStructValue().Fields["readyReplicas"]
-				if readyReplicas.GetNumberValue() != float64(2) {
+				resourceStatus, err := status.FromResource(pbResource)
+				if err != nil {
+					return false, err
+				}
+
+				if readyReplicas, _ := resourceStatus.ContentStatus.Int64("readyReplicas"); readyReplicas != 2 {
 					return false, nil
 				}
 
 				return true, nil
-			}, wait.WithTimeout(time.Minute*2), wait.WithInterval(time.Second*5))
+			}, wait.WithTimeout(time.Minute*2), wait.WithInterval(pollInterval()))
 			if err != nil {
 				t.Fatal(err)
 			}
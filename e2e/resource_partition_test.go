@@ -0,0 +1,192 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/status"
+	"github.com/morvencao/maestro-e2e/utils/watchrecorder"
+)
+
+func TestResourcePartition(t *testing.T) {
+	if os.Getenv("CHAOS_TEST") != "true" {
+		t.Skip("chaos testing is disabled, set CHAOS_TEST=true to enable it")
+	}
+
+	// each feature runs against a consumer of its own, whose work-agent is copied from the suite work-agent
+	// once it is routed through the proxy, the events of the resource are the ones sent by the consumer
+	testenv.Test(t,
+		features.New("Resource survives broker blackhole").
+			WithLabel("type", "grpc").
+			WithLabel("res", "resource").
+			WithLabel("mode", "partition").
+			Setup(setupBrokerProxy()).
+			Setup(setupWatchConsumer("work-agent-partition-blackhole")).
+			Setup(subscribeWatchRecorder()).
+			Setup(setupChaosResource("partition-blackhole")).
+			Assess("partition the work-agent from the broker", blackholeBroker()).
+			Assess("should be able to update the resource during the partition", updateReplicas("partition-blackhole", 2)).
+			Assess("should be able to update the resource again during the partition", updateReplicas("partition-blackhole", 3)).
+			Assess("heal the partition", healBroker()).
+			Assess("should apply the last update", assessDelivered("partition-blackhole", 3)).
+			Assess("should report the status of every update", assessEveryUpdateReported("partition-blackhole")).
+			Teardown(stopWatchRecorders()).
+			Teardown(deleteChaosResource("partition-blackhole")).
+			Teardown(teardownWatchConsumer("work-agent-partition-blackhole")).
+			Teardown(teardownBrokerProxy()).
+			Feature(),
+		features.New("Resource survives broker latency").
+			WithLabel("type", "grpc").
+			WithLabel("res", "resource").
+			WithLabel("mode", "partition").
+			Setup(setupBrokerProxy()).
+			Setup(setupWatchConsumer("work-agent-partition-latency")).
+			Setup(subscribeWatchRecorder()).
+			Setup(setupChaosResource("partition-latency")).
+			Assess("delay the traffic from the broker", delayBroker(time.Second*5)).
+			Assess("should be able to update the resource with latency", updateReplicas("partition-latency", 2)).
+			Assess("should apply the update with latency", assessDelivered("partition-latency", 2)).
+			Assess("should report the status of the update with latency", assessEveryUpdateReported("partition-latency")).
+			Assess("heal the partition", healBroker()).
+			Teardown(stopWatchRecorders()).
+			Teardown(deleteChaosResource("partition-latency")).
+			Teardown(teardownWatchConsumer("work-agent-partition-latency")).
+			Teardown(teardownBrokerProxy()).
+			Feature(),
+		features.New("Resource survives flapping broker connectivity").
+			WithLabel("type", "grpc").
+			WithLabel("res", "resource").
+			WithLabel("mode", "partition").
+			Setup(setupBrokerProxy()).
+			Setup(setupWatchConsumer("work-agent-partition-flapping")).
+			Setup(subscribeWatchRecorder()).
+			Setup(setupChaosResource("partition-flapping")).
+			Assess("should be able to update the resource", updateReplicas("partition-flapping", 2)).
+			Assess("flap the broker connectivity", flapBroker(3, time.Second*10)).
+			Assess("should be able to update the resource again", updateReplicas("partition-flapping", 3)).
+			Assess("flap the broker connectivity again", flapBroker(3, time.Second*10)).
+			Assess("should apply the last update", assessDelivered("partition-flapping", 3)).
+			Assess("should report the status of every update", assessEveryUpdateReported("partition-flapping")).
+			Teardown(stopWatchRecorders()).
+			Teardown(deleteChaosResource("partition-flapping")).
+			Teardown(teardownWatchConsumer("work-agent-partition-flapping")).
+			Teardown(teardownBrokerProxy()).
+			Feature(),
+	)
+}
+
+// updateReplicas returns a feature step that updates the replicas of the resource created by setupChaosResource
+func updateReplicas(depName string, replicas int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		objStruct, err := toStruct(newNginxDeployment(depName, replicas))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updateCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		pbResource, err := grpcClient.Update(updateCtx, &maestropbv1.ResourceUpdateRequest{
+			Id:     ctx.Value("chaos-resource-id").(string),
+			Object: objStruct,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("resource updated: %s, replicas: %d, generation: %d", pbResource.Id, replicas, pbResource.GenerationId)
+		versions, _ := ctx.Value(partitionVersionsKey(depName)).([]int64)
+		return context.WithValue(ctx, partitionVersionsKey(depName), append(versions, pbResource.GenerationId))
+	}
+}
+
+// assessDelivered verifies that the last spec is applied and rolled out, and that the status reported
+// back belongs to the last spec. The deployment generation is not compared with the number of updates,
+// the status of every update is checked by assessEveryUpdateReported
+func assessDelivered(depName string, replicas int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		resourceID := ctx.Value("chaos-resource-id").(string)
+
+		if err := waitForDeploymentReadyReplicas(cfg, depName, "default", int32(replicas), time.Minute*5); err != nil {
+			t.Fatal(err)
+		}
+
+		dep := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: depName, Namespace: "default"}}
+		err := wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(dep, func(object k8s.Object) bool {
+			d := object.(*appsv1.Deployment)
+			return d.Spec.Replicas != nil && int64(*d.Spec.Replicas) == replicas && d.Status.ObservedGeneration == d.Generation
+		}), wait.WithTimeout(time.Minute*2))
+		if err != nil {
+			t.Fatalf("expected deployment replicas %d with its generation observed, got replicas %v, generation %d, observed %d: %v",
+				replicas, dep.Spec.Replicas, dep.Generation, dep.Status.ObservedGeneration, err)
+		}
+
+		if err := waitForResourceReadyReplicas(ctx, grpcClient, resourceID, replicas, time.Minute*5); err != nil {
+			t.Fatal(err)
+		}
+
+		err = wait.For(func(context.Context) (done bool, err error) {
+			pbResource, err := grpcClient.Read(ctx, &maestropbv1.ResourceReadRequest{Id: resourceID})
+			if err != nil {
+				return false, err
+			}
+
+			resourceStatus, err := status.FromResource(pbResource)
+			if err != nil {
+				return false, err
+			}
+
+			statusGeneration := resourceStatus.ResourceGenerationID
+			if statusGeneration > pbResource.GenerationId {
+				return false, fmt.Errorf("status generation %d is ahead of resource generation %d", statusGeneration, pbResource.GenerationId)
+			}
+
+			return statusGeneration == pbResource.GenerationId, nil
+		}, wait.WithTimeout(time.Minute*2), wait.WithInterval(time.Second*5))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ctx
+	}
+}
+
+// partitionVersionsKey is the context key of the resourceversions of the updates of the deployment
+func partitionVersionsKey(depName string) string {
+	return "partition-versions/" + depName
+}
+
+// assessEveryUpdateReported verifies that the consumer of the feature reports a status event for the
+// resourceversion of every update of the deployment, so no update is lost by the partition
+func assessEveryUpdateReported(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		consumer := ctx.Value("watch-consumer-id").(string)
+		recorder := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)[0]
+		versions, _ := ctx.Value(partitionVersionsKey(depName)).([]int64)
+		for _, version := range versions {
+			_, err := recorder.WaitFor(func(record watchrecorder.Record) bool {
+				recordVersion, err := record.ResourceVersion()
+				return err == nil && record.Event.Source() == consumer && recordVersion == version
+			}, time.Minute*5)
+			if err != nil {
+				t.Fatalf("no status event of resourceversion %d, the update is lost: %v", version, err)
+			}
+		}
+
+		t.Logf("received the status of the resourceversions %v", versions)
+		return ctx
+	}
+}
@@ -0,0 +1,325 @@
+package e2e
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+// The work-agent ignores the spec of a manifest whose resourceversion is not greater than the one it
+// has applied, while maestro stores every spec it receives, the last write wins: CloudEventsService.Send
+// (internal/service/v1/manifests) replaces the stored resource with db.PutResource, its generationId set
+// to the resourceversion of the event and its status reset, and the agent status is stored as it comes
+// by db.SetStatusResource, whatever the stored generationId. ResourceService.Update does not take a
+// generationId, maestro increments the stored one without any concurrency control.
+func TestResourceVersion(t *testing.T) {
+	testenv.Test(t,
+		features.New("Manifest stale resourceversion").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			WithLabel("tier", "hermetic").
+			Setup(setupDeletionClients()).
+			Setup(sendManifestVersion("version-stale", 1, 1)).
+			Assess("should apply the first version", assessAppliedVersion("version-stale", 1, 1)).
+			Assess("should be able to send the second version", sendManifestVersion("version-stale", 2, 2)).
+			Assess("should apply the second version", assessAppliedVersion("version-stale", 2, 2)).
+			Assess("should be able to send an older version", sendManifestVersion("version-stale", 1, 5)).
+			Assess("should store the older version and keep the applied one", assessVersionKept("version-stale", storedVersion{generation: 1, replicas: 5}, 2)).
+			Assess("should be able to send the third version", sendManifestVersion("version-stale", 3, 3)).
+			Assess("should converge on the third version", assessAppliedVersion("version-stale", 3, 3)).
+			Teardown(deleteManifestVersion("version-stale", 4)).
+			Feature(),
+		features.New("Manifest out-of-order resourceversions").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			WithLabel("tier", "hermetic").
+			Setup(setupDeletionClients()).
+			Setup(sendManifestVersion("version-order", 1, 1)).
+			Assess("should apply the first version", assessAppliedVersion("version-order", 1, 1)).
+			Assess("should be able to send the third version before the second", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				ctx = sendManifestVersion("version-order", 3, 3)(ctx, t, cfg)
+				return sendManifestVersion("version-order", 2, 2)(ctx, t, cfg)
+			}).
+			Assess("should converge on the third version", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				if err := waitForDeploymentReadyReplicas(cfg, "version-order", "default", 3, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+
+				// the second spec is stored last, the status of the third version is stored after it
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				last, err := status.WaitFor(ctx, grpcClient, ctx.Value(versionResourceKey("version-order")).(string), func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+					readyReplicas, _ := s.ContentStatus.Int64("readyReplicas")
+					return r.GenerationId == 2 && s.ResourceGenerationID == 3 && readyReplicas == 3 &&
+						s.HasCondition(status.ConditionApplied, metav1.ConditionTrue)
+				}, time.Minute*2)
+				if err != nil {
+					t.Fatalf("expected generationId 2 with the applied status of resourceversion 3, last status %+v: %v", last, err)
+				}
+				return ctx
+			}).
+			Assess("should store the second version and keep the third one applied", assessVersionKept("version-order", storedVersion{generation: 2, replicas: 2, reported: 3}, 3)).
+			Teardown(deleteManifestVersion("version-order", 4)).
+			Feature(),
+		features.New("Resource concurrent updates").
+			WithLabel("type", "grpc").
+			WithLabel("res", "resource").
+			WithLabel("version", "concurrent").
+			WithLabel("tier", "hermetic").
+			Setup(setupDeletionClients()).
+			Setup(createGRPCDeletionResource("version-concurrent")).
+			Assess("should converge on the highest generation", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				resourceID := ctx.Value("deletion-resource-id").(string)
+
+				// read the generationId while the resource is updated, it must never go back
+				pollCtx, stopPolling := context.WithCancel(ctx)
+				polled := make(chan []int64)
+				go func() {
+					var generations []int64
+					defer func() { polled <- generations }()
+					for {
+						if pbResource, err := grpcClient.Read(pollCtx, &maestropbv1.ResourceReadRequest{Id: resourceID}); err == nil {
+							generations = append(generations, pbResource.GenerationId)
+						}
+						select {
+						case <-pollCtx.Done():
+							return
+						case <-time.After(time.Millisecond * 200):
+						}
+					}
+				}()
+
+				const updates = 5
+				responses := make([]*maestropbv1.Resource, updates)
+				errs := make([]error, updates)
+				var wg sync.WaitGroup
+				for i := 0; i < updates; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						objStruct, err := toStruct(newNginxDeployment("version-concurrent", int64(i+2)))
+						if err != nil {
+							errs[i] = err
+							return
+						}
+						responses[i], errs[i] = grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: resourceID, Object: objStruct})
+					}(i)
+				}
+				wg.Wait()
+				stopPolling()
+
+				generations := <-polled
+				for i := 1; i < len(generations); i++ {
+					if generations[i] < generations[i-1] {
+						t.Fatalf("generationId went back from %d to %d: %v", generations[i-1], generations[i], generations)
+					}
+				}
+
+				var highest int64
+				seen := map[int64]bool{}
+				for i, err := range errs {
+					if err != nil {
+						t.Fatalf("update %d failed: %v", i, err)
+					}
+					if responses[i].GenerationId <= 1 {
+						t.Fatalf("update %d returned generationId %d, expected it to be greater than the created one", i, responses[i].GenerationId)
+					}
+					if responses[i].GenerationId > highest {
+						highest = responses[i].GenerationId
+					}
+					seen[responses[i].GenerationId] = true
+				}
+				if len(seen) < updates {
+					// maestro reads and writes the resource without a condition, concurrent updates may get the same generation
+					t.Logf("%d concurrent updates got %d distinct generations, some updates were lost", updates, len(seen))
+				}
+
+				pbResource, err := grpcClient.Read(ctx, &maestropbv1.ResourceReadRequest{Id: resourceID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if pbResource.GenerationId != highest {
+					t.Fatalf("expected generationId %d, got %d", highest, pbResource.GenerationId)
+				}
+
+				replicas := int64(pbResource.Object.Fields["spec"].GetStructValue().Fields["replicas"].GetNumberValue())
+				stored := false
+				for _, resp := range responses {
+					r := int64(resp.Object.Fields["spec"].GetStructValue().Fields["replicas"].GetNumberValue())
+					stored = stored || (resp.GenerationId == highest && r == replicas)
+				}
+				if !stored {
+					t.Fatalf("the stored replicas %d do not belong to an update of generation %d", replicas, highest)
+				}
+
+				if err := waitForResourceReadyReplicas(ctx, grpcClient, resourceID, replicas, time.Minute*3); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := status.WaitFor(ctx, grpcClient, resourceID, func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+					return s.ResourceGenerationID == highest && s.HasCondition(status.ConditionApplied, metav1.ConditionTrue)
+				}, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+				if err := waitForDeploymentReadyReplicas(cfg, "version-concurrent", "default", int32(replicas), time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+				return ctx
+			}).
+			Teardown(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				objStruct, err := toStruct(markDeleted(newNginxDeployment("version-concurrent", 1)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{
+					Id:     ctx.Value("deletion-resource-id").(string),
+					Object: objStruct,
+				}); err != nil {
+					t.Logf("failed to delete the resource: %v", err)
+				}
+				return ctx
+			}).
+			Feature(),
+	)
+}
+
+// versionResourceKey is the context key of the resource id of the deployment, the context is passed on
+// from one feature to the next so each feature keeps the id of its own deployment
+func versionResourceKey(depName string) string {
+	return "version-resource-id-" + depName
+}
+
+// sendManifestVersion sends the deployment with the given replicas and resourceversion, the first
+// version is sent as a create request and the resource id is kept in the context
+func sendManifestVersion(depName string, version, replicas int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		builder := manifestevent.NewBuilder(consumerID).WithResourceVersion(version - 1)
+		action := manifestevent.ActionCreate
+		if resourceID, ok := ctx.Value(versionResourceKey(depName)).(string); ok {
+			builder = builder.WithResourceID(resourceID)
+			action = manifestevent.ActionUpdate
+		}
+
+		pbEvt, err := builder.BuildProto(action, newNginxDeployment(depName, replicas))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := grpcClient.Send(ctx, pbEvt); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("manifest sent: %s, resourceversion: %d, replicas: %d", builder.ResourceID(), version, replicas)
+		return context.WithValue(ctx, versionResourceKey(depName), builder.ResourceID())
+	}
+}
+
+func deleteManifestVersion(depName string, version int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		resourceID, ok := ctx.Value(versionResourceKey(depName)).(string)
+		if !ok {
+			return ctx
+		}
+
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		builder := manifestevent.NewBuilder(consumerID).WithResourceID(resourceID).WithResourceVersion(version - 1)
+		pbEvt, err := builder.BuildProto(manifestevent.ActionDelete, newNginxDeployment(depName, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := grpcClient.Send(ctx, pbEvt); err != nil {
+			t.Logf("failed to delete the manifest: %v", err)
+		}
+		return ctx
+	}
+}
+
+// assessAppliedVersion verifies that the agent applied the version, and reported it back to maestro
+func assessAppliedVersion(depName string, version, replicas int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if err := waitForDeploymentReadyReplicas(cfg, depName, "default", int32(replicas), time.Minute*2); err != nil {
+			t.Fatal(err)
+		}
+
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		_, err := status.WaitFor(ctx, grpcClient, ctx.Value(versionResourceKey(depName)).(string), func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+			readyReplicas, _ := s.ContentStatus.Int64("readyReplicas")
+			return r.GenerationId == version && s.ResourceGenerationID == version && readyReplicas == replicas
+		}, time.Minute*2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+}
+
+// storedVersion is what maestro stores for a resource: the generationId and the replicas of the last
+// spec it received, and the resourceversion of the last status reported by the agent, 0 when the status
+// was reset by the last spec and nothing was reported since
+type storedVersion struct {
+	generation int64
+	replicas   int64
+	reported   int64
+}
+
+// assessVersionKept verifies for a while that maestro keeps the stored version, and that the deployment
+// keeps the applied replicas, the specs with a lower resourceversion are ignored by the agent
+func assessVersionKept(depName string, stored storedVersion, appliedReplicas int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		resourceID := ctx.Value(versionResourceKey(depName)).(string)
+
+		deadline := time.Now().Add(holdWindow())
+		for time.Now().Before(deadline) {
+			var dep appsv1.Deployment
+			if err := clusterClient(cfg).Get(ctx, client.ObjectKey{Name: depName, Namespace: "default"}, &dep); err != nil {
+				t.Fatal(err)
+			}
+			if *dep.Spec.Replicas != int32(appliedReplicas) {
+				t.Fatalf("expected the deployment to keep %d replicas, got %d", appliedReplicas, *dep.Spec.Replicas)
+			}
+
+			pbResource, err := grpcClient.Read(ctx, &maestropbv1.ResourceReadRequest{Id: resourceID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pbResource.GenerationId != stored.generation {
+				t.Fatalf("expected maestro to store the last generationId %d, got %d", stored.generation, pbResource.GenerationId)
+			}
+			if replicas := int64(pbResource.Object.Fields["spec"].GetStructValue().Fields["replicas"].GetNumberValue()); replicas != stored.replicas {
+				t.Fatalf("expected maestro to store the last spec with %d replicas, got %d", stored.replicas, replicas)
+			}
+
+			resourceStatus, err := status.FromResource(pbResource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resourceStatus.ResourceGenerationID != stored.reported {
+				t.Fatalf("expected the status of resourceversion %d, got %d", stored.reported, resourceStatus.ResourceGenerationID)
+			}
+			if stored.reported == 0 && len(resourceStatus.ReconcileStatus.Conditions) != 0 {
+				t.Fatalf("expected the status to be reset, got the conditions %v", resourceStatus.ReconcileStatus.Conditions)
+			}
+			if readyReplicas, _ := resourceStatus.ContentStatus.Int64("readyReplicas"); stored.reported != 0 && readyReplicas != appliedReplicas {
+				t.Fatalf("expected the status to report %d ready replicas, got %d", appliedReplicas, readyReplicas)
+			}
+
+			time.Sleep(pollInterval())
+		}
+		return ctx
+	}
+}
@@ -0,0 +1,436 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/e2e-framework/klient/k8s/resources"
+	"sigs.k8s.io/e2e-framework/klient/k8s/watcher"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/perf"
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+const (
+	metricCreateApplied       = "create_applied"
+	metricUpdateApplied       = "update_applied"
+	metricUpdateStatusVisible = "update_status_visible"
+)
+
+// scaleParams are read from the environment, see README for the defaults
+type scaleParams struct {
+	Consumers   int
+	Resources   int
+	Concurrency int
+	Timeout     time.Duration
+	ReportPath  string
+}
+
+func scaleParamsFromEnv() (scaleParams, error) {
+	params := scaleParams{
+		Consumers:   2,
+		Resources:   10,
+		Concurrency: 10,
+		Timeout:     time.Minute * 5,
+		ReportPath:  os.Getenv("SCALE_REPORT"),
+	}
+
+	for env, value := range map[string]*int{
+		"SCALE_CONSUMERS":   &params.Consumers,
+		"SCALE_RESOURCES":   &params.Resources,
+		"SCALE_CONCURRENCY": &params.Concurrency,
+	} {
+		if v := os.Getenv(env); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 1 {
+				return params, fmt.Errorf("invalid %s: %q", env, v)
+			}
+			*value = i
+		}
+	}
+
+	if v := os.Getenv("SCALE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return params, fmt.Errorf("invalid SCALE_TIMEOUT: %q", v)
+		}
+		params.Timeout = d
+	}
+
+	return params, nil
+}
+
+func TestResourceScale(t *testing.T) {
+	if os.Getenv("SCALE_TEST") != "true" {
+		t.Skip("scale testing is disabled, set SCALE_TEST=true to enable it")
+	}
+
+	params, err := scaleParamsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runID := envconf.RandomName("scale", 10)
+	// created are the names of the scale resources by id, they are deleted on teardown even when the
+	// assessment fails
+	var createdMu sync.Mutex
+	created := map[string]string{}
+	scaleFeature := features.New("Resource GRPC Service at scale").
+		WithLabel("type", "grpc").
+		WithLabel("res", "resource").
+		WithLabel("mode", "scale").
+		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			if consumerID == "" {
+				t.Fatal("consumerID is empty")
+			}
+
+			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+			consumerClient := maestropbv1.NewConsumerServiceClient(conn)
+
+			// the first consumer is served by the work-agent of the suite, every other
+			// consumer gets a dedicated work-agent
+			consumers := []string{consumerID}
+			for i := 1; i < params.Consumers; i++ {
+				pbConsumer, err := consumerClient.Create(ctx, &maestropbv1.ConsumerCreateRequest{
+					Labels: []*maestropbv1.ConsumerLabel{{Key: "maestro-e2e/scale", Value: runID}},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := deployWorkAgent(ctx, cfg, fmt.Sprintf("work-agent-%s-%d", runID, i), pbConsumer.Id); err != nil {
+					t.Fatal(err)
+				}
+				consumers = append(consumers, pbConsumer.Id)
+			}
+
+			t.Logf("scale consumers: %v", consumers)
+			return context.WithValue(ctx, "scale-consumers", consumers)
+		}).
+		Assess("should create and update resources for every consumer", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+			grpcClient := maestropbv1.NewResourceServiceClient(conn)
+			consumers := ctx.Value("scale-consumers").([]string)
+
+			tracker, err := startConfigMapTracker(ctx, cfg, runID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tracker.stop()
+
+			recorder := perf.NewRecorder()
+			sem := make(chan struct{}, params.Concurrency)
+			var wg sync.WaitGroup
+			start := time.Now()
+			for ci, consumer := range consumers {
+				for ri := 0; ri < params.Resources; ri++ {
+					wg.Add(1)
+					sem <- struct{}{}
+					go func(consumer, name string) {
+						defer wg.Done()
+						defer func() { <-sem }()
+						if id := runScaleResource(ctx, grpcClient, tracker, recorder, consumer, name, runID, params.Timeout); id != "" {
+							createdMu.Lock()
+							created[id] = name
+							createdMu.Unlock()
+						}
+					}(consumer, fmt.Sprintf("%s-%d-%d", runID, ci, ri))
+				}
+			}
+			wg.Wait()
+
+			report := &perf.Report{
+				Name: "resource-scale",
+				Params: map[string]string{
+					"consumers":   strconv.Itoa(params.Consumers),
+					"resources":   strconv.Itoa(params.Resources),
+					"concurrency": strconv.Itoa(params.Concurrency),
+					"duration":    time.Since(start).Round(time.Millisecond).String(),
+				},
+				Metrics: recorder.Summarize(),
+			}
+
+			reportJSON, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("scale summary:\n%s", reportJSON)
+
+			if params.ReportPath != "" {
+				if err := report.WriteFile(params.ReportPath); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for metric, summary := range report.Metrics {
+				if summary.Errors > 0 {
+					t.Errorf("metric %s: %d errors", metric, summary.Errors)
+				}
+			}
+
+			gatePerfReport(t, report)
+
+			return context.WithValue(ctx, "scale-report", report)
+		}).
+		Teardown(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			// the resources are deleted before the work-agents, which remove their configmaps
+			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+			grpcClient := maestropbv1.NewResourceServiceClient(conn)
+			createdMu.Lock()
+			defer createdMu.Unlock()
+			for id, name := range created {
+				objStruct, err := toStruct(markDeleted(newScaleConfigMap(name, runID, "2")))
+				if err == nil {
+					_, err = grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: id, Object: objStruct})
+				}
+				if err != nil {
+					t.Logf("failed to delete resource %s: %v", id, err)
+				}
+			}
+
+			err := wait.For(conditions.New(cfg.Client().Resources("default")).ResourceListN(&corev1.ConfigMapList{}, 0,
+				resources.WithLabelSelector("maestro-e2e/scale="+runID)), wait.WithTimeout(params.Timeout))
+			if err != nil {
+				t.Errorf("scale configmaps are not deleted: %v", err)
+			}
+
+			for i := 1; i < params.Consumers; i++ {
+				name := fmt.Sprintf("work-agent-%s-%d", runID, i)
+				if err := deleteWorkAgent(ctx, cfg, name); err != nil {
+					t.Logf("failed to delete %s: %v", name, err)
+				}
+			}
+			return ctx
+		}).Feature()
+
+	testenv.Test(t, scaleFeature)
+}
+
+// defaultPerfBaseline is the baseline committed to the repo, record it with SCALE_REPORT
+const defaultPerfBaseline = "testdata/scale-baseline.json"
+
+// gatePerfReport compares the report against the baseline in PERF_BASELINE, or the committed one,
+// and fails the test when a metric regresses past PERF_THRESHOLD percent, or only warns when PERF_GATE=warn.
+// A run without a baseline fails too, unless it records one with SCALE_REPORT or PERF_GATE=warn
+func gatePerfReport(t *testing.T, report *perf.Report) {
+	baselinePath := os.Getenv("PERF_BASELINE")
+	if baselinePath == "" {
+		if _, err := os.Stat(defaultPerfBaseline); err != nil {
+			msg := fmt.Sprintf("no performance baseline found at %s, the regression gate is NOT applied, "+
+				"record one with SCALE_REPORT=%s or set PERF_BASELINE", defaultPerfBaseline, defaultPerfBaseline)
+			if os.Getenv("SCALE_REPORT") != "" || os.Getenv("PERF_GATE") == "warn" {
+				t.Logf("WARNING: %s", msg)
+				return
+			}
+			t.Error(msg)
+			return
+		}
+		baselinePath = defaultPerfBaseline
+	}
+
+	baseline, err := perf.ReadReport(baselinePath)
+	if err != nil {
+		t.Fatalf("failed to read performance baseline %s: %v", baselinePath, err)
+	}
+
+	threshold := 20.0
+	if v := os.Getenv("PERF_THRESHOLD"); v != "" {
+		threshold, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			t.Fatalf("invalid PERF_THRESHOLD: %q", v)
+		}
+	}
+
+	for param, value := range baseline.Params {
+		if param != "duration" && report.Params[param] != value {
+			t.Logf("warning: baseline %s is %s, current run uses %s", param, value, report.Params[param])
+		}
+	}
+
+	comparisons, err := perf.Compare(baseline, report, perf.CompareOptions{Threshold: threshold / 100})
+	if err != nil {
+		t.Fatalf("failed to compare against performance baseline %s: %v", baselinePath, err)
+	}
+	t.Logf("performance comparison against %s (threshold %.1f%%):\n%s", baselinePath, threshold, perf.FormatTable(comparisons))
+
+	regressions := perf.Regressions(comparisons)
+	if len(regressions) == 0 {
+		return
+	}
+
+	if os.Getenv("PERF_GATE") == "warn" {
+		t.Logf("warning: %d performance regressions", len(regressions))
+		return
+	}
+	t.Errorf("%d performance regressions:\n%s", len(regressions), perf.FormatTable(regressions))
+}
+
+// runScaleResource creates a configmap through maestro, updates it and records the latencies, it returns
+// the id of the resource, or an empty id when it is not created
+func runScaleResource(ctx context.Context, client maestropbv1.ResourceServiceClient, tracker *configMapTracker,
+	recorder *perf.Recorder, consumer, name, runID string, timeout time.Duration) string {
+	objStruct, err := toStruct(newScaleConfigMap(name, runID, "1"))
+	if err != nil {
+		recorder.Error(metricCreateApplied)
+		return ""
+	}
+
+	start := time.Now()
+	pbResource, err := client.Create(ctx, &maestropbv1.ResourceCreateRequest{
+		ConsumerId: consumer,
+		Object:     objStruct,
+	})
+	if err != nil {
+		recorder.Error(metricCreateApplied)
+		return ""
+	}
+	id := pbResource.Id
+
+	seen, ok := tracker.waitFor(name, "1", timeout)
+	if !ok {
+		recorder.Error(metricCreateApplied)
+		return id
+	}
+	recorder.Observe(metricCreateApplied, seen.Sub(start))
+
+	objStruct, err = toStruct(newScaleConfigMap(name, runID, "2"))
+	if err != nil {
+		recorder.Error(metricUpdateApplied)
+		return id
+	}
+
+	start = time.Now()
+	pbResource, err = client.Update(ctx, &maestropbv1.ResourceUpdateRequest{
+		Id:     pbResource.Id,
+		Object: objStruct,
+	})
+	if err != nil {
+		recorder.Error(metricUpdateApplied)
+		recorder.Error(metricUpdateStatusVisible)
+		return id
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		seen, ok := tracker.waitFor(name, "2", timeout)
+		if !ok {
+			recorder.Error(metricUpdateApplied)
+			return
+		}
+		recorder.Observe(metricUpdateApplied, seen.Sub(start))
+	}()
+
+	// the status is visible once it reports the generation of the update
+	deadline := start.Add(timeout)
+	for {
+		read, err := client.Read(ctx, &maestropbv1.ResourceReadRequest{Id: pbResource.Id})
+		if err == nil {
+			if resourceStatus, err := status.FromResource(read); err == nil && resourceStatus.ResourceGenerationID >= pbResource.GenerationId {
+				recorder.Observe(metricUpdateStatusVisible, time.Since(start))
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			recorder.Error(metricUpdateStatusVisible)
+			break
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+
+	wg.Wait()
+	return id
+}
+
+func newScaleConfigMap(name, runID, version string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+				"labels": map[string]interface{}{
+					"maestro-e2e/scale": runID,
+				},
+			},
+			"data": map[string]interface{}{
+				"version": version,
+			},
+		},
+	}
+}
+
+// configMapTracker records when each version of the scale configmaps is first observed in the cluster
+type configMapTracker struct {
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	cancel  context.CancelFunc
+	handler *watcher.EventHandlerFuncs
+}
+
+func startConfigMapTracker(ctx context.Context, cfg *envconf.Config, runID string) (*configMapTracker, error) {
+	tracker := &configMapTracker{seen: map[string]time.Time{}}
+	record := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return
+		}
+
+		key := cm.Name + "/" + cm.Data["version"]
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		if _, ok := tracker.seen[key]; !ok {
+			tracker.seen[key] = time.Now()
+		}
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	handler := cfg.Client().Resources("default").
+		Watch(&corev1.ConfigMapList{}, resources.WithLabelSelector("maestro-e2e/scale="+runID)).
+		WithAddFunc(record).
+		WithUpdateFunc(record)
+	if err := handler.Start(watchCtx); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	tracker.cancel = cancel
+	tracker.handler = handler
+	return tracker, nil
+}
+
+func (c *configMapTracker) waitFor(name, version string, timeout time.Duration) (time.Time, bool) {
+	key := name + "/" + version
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		seen, ok := c.seen[key]
+		c.mu.Unlock()
+		if ok {
+			return seen, true
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return time.Time{}, false
+}
+
+func (c *configMapTracker) stop() {
+	c.cancel()
+	c.handler.Stop()
+}
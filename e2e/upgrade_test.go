@@ -0,0 +1,308 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+// upgradeAgentName is the work-agent deployed for the consumer the upgrade feature creates
+const upgradeAgentName = "work-agent-upgrade"
+
+// upgradeImage is the image override of a component, the component is rolled from the "from" image
+// to the "to" image, and back to the image the suite deployed on teardown
+type upgradeImage struct {
+	target             chaosTarget
+	from               string
+	to                 string
+	original           string
+	originalPullPolicy corev1.PullPolicy
+}
+
+// upgradeState is what the old maestro stored and the old work-agents applied before the upgrade
+type upgradeState struct {
+	consumers map[string]*maestropbv1.Consumer
+	resources map[string]*maestropbv1.Resource
+	// deployments are the names of the applied deployments, keyed by resource id
+	deployments map[string]string
+	// generations are the generations of the applied deployments, keyed by resource id
+	generations map[string]int64
+}
+
+func upgradeImagesFromEnv() ([]*upgradeImage, error) {
+	images := []*upgradeImage{
+		{target: maestroTarget, from: os.Getenv("UPGRADE_MAESTRO_FROM"), to: os.Getenv("UPGRADE_MAESTRO_TO")},
+		{target: workAgentTarget, from: os.Getenv("UPGRADE_WORK_AGENT_FROM"), to: os.Getenv("UPGRADE_WORK_AGENT_TO")},
+	}
+
+	for env, image := range map[string]*upgradeImage{"UPGRADE_MAESTRO_FROM": images[0], "UPGRADE_WORK_AGENT_FROM": images[1]} {
+		if image.from == "" {
+			return nil, fmt.Errorf("%s is required to test the upgrade of %s", env, image.target)
+		}
+	}
+	return images, nil
+}
+
+// The upgrade rolls the components that serve live resources, it restores the images the suite deployed
+// on teardown so it can run along with the other features
+func TestUpgrade(t *testing.T) {
+	if os.Getenv("UPGRADE_TEST") != "true" {
+		t.Skip("upgrade testing is disabled, set UPGRADE_TEST=true to enable it")
+	}
+
+	images, err := upgradeImagesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upgradeFeature := features.New("Upgrade of maestro and the work-agent").
+		WithLabel("type", "grpc").
+		WithLabel("res", "resource").
+		WithLabel("mode", "upgrade").
+		Setup(setupDeletionClients()).
+		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			for _, image := range images {
+				container, err := componentContainer(ctx, cfg, image.target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				image.original, image.originalPullPolicy = container.Image, container.ImagePullPolicy
+				if image.to == "" {
+					image.to = image.original
+				}
+
+				if err := setComponentImage(ctx, cfg, image.target, image.from, corev1.PullIfNotPresent); err != nil {
+					t.Fatalf("failed to roll %s to %s: %v", image.target, image.from, err)
+				}
+				t.Logf("component %s rolled to %s", image.target, image.from)
+			}
+			return ctx
+		}).
+		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+			consumerClient := maestropbv1.NewConsumerServiceClient(conn)
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+
+			// the suite consumer is served by the suite work-agent, the new one by a copy of it that
+			// runs the same "from" image
+			pbConsumer, err := consumerClient.Create(ctx, &maestropbv1.ConsumerCreateRequest{
+				Labels: []*maestropbv1.ConsumerLabel{{Key: "maestro-e2e/upgrade", Value: "true"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := deployWorkAgent(ctx, cfg, upgradeAgentName, pbConsumer.Id); err != nil {
+				t.Fatal(err)
+			}
+
+			state := &upgradeState{
+				consumers:   map[string]*maestropbv1.Consumer{},
+				resources:   map[string]*maestropbv1.Resource{},
+				deployments: map[string]string{},
+				generations: map[string]int64{},
+			}
+			for i, id := range []string{consumerID, pbConsumer.Id} {
+				consumer, err := consumerClient.Read(ctx, &maestropbv1.ConsumerReadRequest{Id: id})
+				if err != nil {
+					t.Fatal(err)
+				}
+				state.consumers[id] = consumer
+
+				depName := fmt.Sprintf("upgrade-%d", i)
+				objStruct, err := toStruct(newNginxDeployment(depName, 1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				pbResource, err := grpcClient.Create(ctx, &maestropbv1.ResourceCreateRequest{ConsumerId: id, Object: objStruct})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := waitForResourceReadyReplicas(ctx, grpcClient, pbResource.Id, 1, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := status.WaitForResourceCondition(ctx, grpcClient, pbResource.Id, status.ConditionApplied, metav1.ConditionTrue, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+
+				pbResource, err = grpcClient.Read(ctx, &maestropbv1.ResourceReadRequest{Id: pbResource.Id})
+				if err != nil {
+					t.Fatal(err)
+				}
+				state.resources[pbResource.Id] = pbResource
+				state.deployments[pbResource.Id] = depName
+
+				var dep appsv1.Deployment
+				if err := cfg.Client().Resources().Get(ctx, depName, "default", &dep); err != nil {
+					t.Fatal(err)
+				}
+				state.generations[pbResource.Id] = dep.Generation
+			}
+
+			return context.WithValue(ctx, "upgrade-state", state)
+		}).
+		Assess("should roll maestro and the work-agents to the new images", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			upgradeAgentTarget := chaosTarget{Name: upgradeAgentName, Namespace: workAgentTarget.Namespace}
+			rollouts := []struct {
+				target chaosTarget
+				image  string
+			}{
+				{target: maestroTarget, image: images[0].to},
+				{target: workAgentTarget, image: images[1].to},
+				{target: upgradeAgentTarget, image: images[1].to},
+			}
+
+			for _, rollout := range rollouts {
+				if err := setComponentImage(ctx, cfg, rollout.target, rollout.image, corev1.PullIfNotPresent); err != nil {
+					t.Fatalf("failed to roll %s to %s: %v", rollout.target, rollout.image, err)
+				}
+				t.Logf("component %s rolled to %s", rollout.target, rollout.image)
+			}
+			return ctx
+		}).
+		Assess("should read the consumers stored by the old maestro", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			state := ctx.Value("upgrade-state").(*upgradeState)
+			consumerClient := maestropbv1.NewConsumerServiceClient(ctx.Value("grpc-connction").(*grpc.ClientConn))
+			for id, expected := range state.consumers {
+				var consumer *maestropbv1.Consumer
+				err := wait.For(func(context.Context) (done bool, err error) {
+					// the connection may still be re-established to the new maestro
+					consumer, err = consumerClient.Read(ctx, &maestropbv1.ConsumerReadRequest{Id: id})
+					return err == nil, nil
+				}, wait.WithTimeout(time.Minute), wait.WithInterval(time.Second*5))
+				if err != nil {
+					t.Fatalf("failed to read the consumer %s: %v", id, err)
+				}
+
+				if !proto.Equal(expected, consumer) {
+					t.Errorf("consumer %s changed across the upgrade, expected %v, got %v", id, expected, consumer)
+				}
+			}
+			return ctx
+		}).
+		Assess("should keep the status of the resources", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			state := ctx.Value("upgrade-state").(*upgradeState)
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			for id, expected := range state.resources {
+				var last *maestropbv1.Resource
+				_, err := status.WaitFor(ctx, grpcClient, id, func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+					last = r
+					readyReplicas, _ := s.ContentStatus.Int64("readyReplicas")
+					return readyReplicas == 1 && s.HasCondition(status.ConditionApplied, metav1.ConditionTrue)
+				}, time.Minute*2)
+				if err != nil {
+					t.Fatalf("the status of resource %s is lost: %v", id, err)
+				}
+
+				if last.ConsumerId != expected.ConsumerId || last.GenerationId != expected.GenerationId || !proto.Equal(last.Object, expected.Object) {
+					t.Errorf("resource %s changed across the upgrade, expected generation %d of consumer %s, got generation %d of consumer %s",
+						id, expected.GenerationId, expected.ConsumerId, last.GenerationId, last.ConsumerId)
+				}
+
+				// the new work-agents must not reapply the unchanged deployments
+				var dep appsv1.Deployment
+				if err := cfg.Client().Resources().Get(ctx, state.deployments[id], "default", &dep); err != nil {
+					t.Fatal(err)
+				}
+				if dep.Generation != state.generations[id] {
+					t.Errorf("deployment %s is updated by the upgrade, expected generation %d, got %d", dep.Name, state.generations[id], dep.Generation)
+				}
+			}
+			return ctx
+		}).
+		Assess("should apply the updates after the upgrade", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			state := ctx.Value("upgrade-state").(*upgradeState)
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			for id, depName := range state.deployments {
+				objStruct, err := toStruct(newNginxDeployment(depName, 2))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: id, Object: objStruct}); err != nil {
+					t.Fatal(err)
+				}
+				if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 2, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+				if err := waitForResourceReadyReplicas(ctx, grpcClient, id, 2, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return ctx
+		}).
+		Teardown(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			if state, ok := ctx.Value("upgrade-state").(*upgradeState); ok {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				for id, depName := range state.deployments {
+					objStruct, err := toStruct(markDeleted(newNginxDeployment(depName, 1)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: id, Object: objStruct}); err != nil {
+						t.Logf("failed to delete the resource %s: %v", id, err)
+						continue
+					}
+					if err := waitForDeploymentDeleted(cfg, depName, "default", time.Minute*2); err != nil {
+						t.Logf("failed to wait for the deployment %s to be deleted: %v", depName, err)
+					}
+				}
+			}
+
+			if err := deleteWorkAgent(ctx, cfg, upgradeAgentName); err != nil {
+				t.Logf("failed to delete %s: %v", upgradeAgentName, err)
+			}
+
+			for _, image := range images {
+				if image.original == "" {
+					continue
+				}
+				if err := setComponentImage(ctx, cfg, image.target, image.original, image.originalPullPolicy); err != nil {
+					t.Errorf("failed to restore %s to %s: %v", image.target, image.original, err)
+				}
+			}
+			return ctx
+		}).Feature()
+
+	testenv.Test(t, upgradeFeature)
+}
+
+// componentContainer returns the container of the component deployment
+func componentContainer(ctx context.Context, cfg *envconf.Config, target chaosTarget) (*corev1.Container, error) {
+	var dep appsv1.Deployment
+	if err := cfg.Client().Resources().Get(ctx, target.Name, target.Namespace, &dep); err != nil {
+		return nil, err
+	}
+	return &dep.Spec.Template.Spec.Containers[0], nil
+}
+
+// setComponentImage overrides the image of the component deployment and waits for the rollout, the
+// overrides are pinned images that may be loaded into the kind cluster, so they are not pulled again
+func setComponentImage(ctx context.Context, cfg *envconf.Config, target chaosTarget, image string, pullPolicy corev1.PullPolicy) error {
+	var dep appsv1.Deployment
+	if err := cfg.Client().Resources().Get(ctx, target.Name, target.Namespace, &dep); err != nil {
+		return err
+	}
+
+	container := &dep.Spec.Template.Spec.Containers[0]
+	container.Image, container.ImagePullPolicy = image, pullPolicy
+	if err := cfg.Client().Resources().Update(ctx, &dep); err != nil {
+		return err
+	}
+
+	return waitForComponentRollout(cfg, target)
+}
@@ -0,0 +1,165 @@
+package e2e
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	maestrostatus "github.com/morvencao/maestro-e2e/utils/status"
+	"github.com/morvencao/maestro-e2e/utils/watchrecorder"
+)
+
+func TestWatchReconnection(t *testing.T) {
+	if os.Getenv("CHAOS_TEST") != "true" {
+		t.Skip("chaos testing is disabled, set CHAOS_TEST=true to enable it")
+	}
+
+	depName := "watch-restart"
+	testenv.Test(t,
+		features.New("Watch stream reconnects after maestro restart").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			WithLabel("mode", "chaos").
+			Setup(setupWatchConsumer("work-agent-watch-restart")).
+			Setup(createWatchResource(depName)).
+			Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				// the plain watch shows what a long-lived client sees, the shared stream of the suite
+				// reconnects and keeps watching across the restart
+				grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+				recorder, err := watchrecorder.Start(ctx, grpcClient, &maestropbv1.ResourceWatchRequest{})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ctx = context.WithValue(ctx, "watch-restart-recorder", recorder)
+				return context.WithValue(ctx, "watch-recorders", []*watchrecorder.Recorder{subscribeWatch(ctx)})
+			}).
+			Assess("should be able to update the resource", updateWatchResource(depName, 2)).
+			Assess("should apply the update before maestro is stopped", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				if err := waitForResourceReadyReplicas(ctx, grpcClient, ctx.Value("watch-resource-id").(string), 2, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+				return ctx
+			}).
+			Assess("stop maestro", stopComponent(maestroTarget)).
+			Assess("should end the watch stream with Unavailable", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				recorder := ctx.Value("watch-restart-recorder").(*watchrecorder.Recorder)
+				select {
+				case <-recorder.Done():
+				case <-time.After(time.Minute * 2):
+					t.Fatal("watch stream is not closed after maestro is stopped")
+				}
+
+				if code := status.Code(recorder.Err()); code != codes.Unavailable {
+					t.Fatalf("expected the watch stream to end with %s, got %s: %v", codes.Unavailable, code, recorder.Err())
+				}
+
+				t.Logf("watch stream ended: %v", recorder.Err())
+				return ctx
+			}).
+			Assess("should change the status of the deployment while maestro is down", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				// the deployment is scaled on the cluster, the agent reports the new ready replicas and
+				// only puts the spec back on its periodic resync
+				err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+					var dep appsv1.Deployment
+					if err := cfg.Client().Resources().Get(ctx, depName, "default", &dep); err != nil {
+						return err
+					}
+					replicas := int32(3)
+					dep.Spec.Replicas = &replicas
+					return cfg.Client().Resources().Update(ctx, &dep)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 3, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+				return context.WithValue(ctx, "watch-restart-changed", time.Now())
+			}).
+			Assess("start maestro", startComponent(maestroTarget)).
+			Assess("should reconnect the watch stream", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				reconnecting := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)[0]
+				err := wait.For(func(context.Context) (bool, error) {
+					return len(reconnecting.Disconnects()) > 0, nil
+				}, wait.WithTimeout(time.Minute*2))
+				if err != nil {
+					t.Fatal("the shared watch stream did not observe the maestro outage")
+				}
+
+				for _, d := range reconnecting.Disconnects() {
+					t.Logf("watch stream reconnected after: %v", d.Err)
+				}
+				return ctx
+			}).
+			Assess("should observe the status change made while maestro was down", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				// maestro stores a status once a watch stream receives it, the watched events carry the
+				// conditions only, so the ready replicas are read and the event is matched by its consumer
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				_, err := maestrostatus.WaitFor(ctx, grpcClient, ctx.Value("watch-resource-id").(string), func(_ *maestropbv1.Resource, s *maestrostatus.ResourceStatus) bool {
+					readyReplicas, _ := s.ContentStatus.Int64("readyReplicas")
+					return readyReplicas == 3
+				}, time.Minute*3)
+				if err != nil {
+					t.Fatalf("the status change made while maestro was down is not read: %v", err)
+				}
+
+				reconnecting := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)[0]
+				consumer := ctx.Value("watch-consumer-id").(string)
+				changed := ctx.Value("watch-restart-changed").(time.Time)
+				_, err = reconnecting.WaitFor(func(record watchrecorder.Record) bool {
+					return record.Event.Source() == consumer && record.Received.After(changed)
+				}, time.Second*30)
+				if err != nil {
+					t.Fatalf("the status change made while maestro was down is not watched: %v", err)
+				}
+				return ctx
+			}).
+			Assess("should be able to update the resource after reconnecting", updateWatchResource(depName, 1)).
+			Assess("should receive the status with the reconnected stream", assessWatchedGeneration(3)).
+			Teardown(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				if recorder, ok := ctx.Value("watch-restart-recorder").(*watchrecorder.Recorder); ok {
+					_ = recorder.Stop(time.Second * 30)
+				}
+				return ctx
+			}).
+			Teardown(stopWatchRecorders()).
+			Teardown(deleteWatchResource(depName)).
+			Teardown(teardownWatchConsumer("work-agent-watch-restart")).
+			Feature(),
+	)
+}
+
+// updateWatchResource updates the replicas of the watch resource, each update is a new generation
+func updateWatchResource(depName string, replicas int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		objStruct, err := toStruct(newNginxDeployment(depName, replicas))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pbResource, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{
+			Id:     ctx.Value("watch-resource-id").(string),
+			Object: objStruct,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("resource updated: %s", pbResource.Id)
+		return ctx
+	}
+}
@@ -0,0 +1,332 @@
+package e2e
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/fakeagent"
+	"github.com/morvencao/maestro-e2e/utils/fakemaestro"
+	"github.com/morvencao/maestro-e2e/utils/status"
+	"github.com/morvencao/maestro-e2e/utils/watchrecorder"
+)
+
+// the watch features run against a consumer of their own, maestro does not set the resource id
+// on the watched events, so the events of the resource are the ones sent by its consumer
+func TestWatchStream(t *testing.T) {
+	testenv.Test(t,
+		features.New("Watch stream ordering").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			Setup(setupWatchConsumer("work-agent-watch-order")).
+			Setup(startConsumerWatch()).
+			Setup(createWatchResource("watch-order")).
+			Assess("should be able to update the resource", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+				for replicas := int64(2); replicas <= 3; replicas++ {
+					objStruct, err := toStruct(newNginxDeployment("watch-order", replicas))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if _, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{
+						Id:     ctx.Value("watch-resource-id").(string),
+						Object: objStruct,
+					}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if err := waitForDeploymentReadyReplicas(cfg, "watch-order", "default", 3, time.Minute*2); err != nil {
+					t.Fatal(err)
+				}
+				return ctx
+			}).
+			Assess("should receive the status of the last generation", assessWatchedGeneration(3)).
+			Assess("should receive the events in resourceversion order without duplicates", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				records := watchedRecords(ctx, 0)
+				if err := watchrecorder.CheckOrdered(records); err != nil {
+					t.Fatal(err)
+				}
+				if err := watchrecorder.CheckNoDuplicates(records); err != nil {
+					t.Fatal(err)
+				}
+
+				t.Logf("received %d events in order", len(records))
+				return ctx
+			}).
+			Assess("should not receive events of other consumers", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				if os.Getenv("WATCH_SCOPED") != "true" {
+					t.Skip("maestro ignores the ResourceWatchRequest and sends the events of every consumer, set WATCH_SCOPED=true to scope the watch to the consumer")
+				}
+
+				recorder := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)[0]
+				if foreign := watchrecorder.ForeignEvents(recorder.Records(), ctx.Value("watch-consumer-id").(string)); len(foreign) > 0 {
+					t.Fatalf("received %d events of other consumers, the first one from %s", len(foreign), foreign[0].Event.Source())
+				}
+				return ctx
+			}).
+			Teardown(stopWatchRecorders()).
+			Teardown(deleteWatchResource("watch-order")).
+			Teardown(teardownWatchConsumer("work-agent-watch-order")).
+			Feature(),
+		// maestro hands each status event to a single watcher, the shared stream of the suite included,
+		// skip the feature with --skip-labels="watch=fanout" until the events are broadcasted
+		features.New("Watch stream fan-out").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			WithLabel("watch", "fanout").
+			Setup(setupWatchConsumer("work-agent-watch-fanout")).
+			Setup(startWatchStreams(3)).
+			Setup(createWatchResource("watch-fanout")).
+			Assess("should deliver the status to every watcher", assessWatchedGeneration(1)).
+			Assess("should deliver the same events to every watcher", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				expected := watchedContents(watchedRecords(ctx, 0))
+				for i := 1; i < len(ctx.Value("watch-recorders").([]*watchrecorder.Recorder)); i++ {
+					contents := watchedContents(watchedRecords(ctx, i))
+					if fmt.Sprint(contents) != fmt.Sprint(expected) {
+						t.Fatalf("watcher %d received %v, watcher 0 received %v", i, contents, expected)
+					}
+				}
+				return ctx
+			}).
+			Teardown(stopWatchRecorders()).
+			Teardown(deleteWatchResource("watch-fanout")).
+			Teardown(teardownWatchConsumer("work-agent-watch-fanout")).
+			Feature(),
+		features.New("Watch stream cancellation").
+			WithLabel("type", "grpc").
+			WithLabel("res", "manifest").
+			Setup(setupWatchConsumer("work-agent-watch-cancel")).
+			Setup(startWatchStreams(1)).
+			Assess("should close the stream when the watch is cancelled", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				recorder := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)[0]
+				if err := recorder.Stop(time.Second * 30); err != nil {
+					t.Fatal(err)
+				}
+
+				select {
+				case <-recorder.Done():
+				default:
+					t.Fatal("watch stream is not closed after the cancellation")
+				}
+
+				ctx = context.WithValue(ctx, "watch-cancelled-recorder", recorder)
+				return context.WithValue(ctx, "watch-cancelled-records", len(recorder.Records()))
+			}).
+			Assess("should keep watching with the shared stream", subscribeWatchRecorder()).
+			Assess("should be able to create a resource", createWatchResource("watch-cancel")).
+			Assess("should receive the status with the shared stream", assessWatchedGeneration(1)).
+			Assess("should not receive events after the cancellation", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+				recorder := ctx.Value("watch-cancelled-recorder").(*watchrecorder.Recorder)
+				if received, cancelled := len(recorder.Records()), ctx.Value("watch-cancelled-records").(int); received != cancelled {
+					t.Fatalf("received %d events after the cancellation", received-cancelled)
+				}
+				return ctx
+			}).
+			Teardown(stopWatchRecorders()).
+			Teardown(deleteWatchResource("watch-cancel")).
+			Teardown(teardownWatchConsumer("work-agent-watch-cancel")).
+			Feature(),
+	)
+}
+
+// setupWatchConsumer creates a consumer served by a work-agent of its own, against the fake
+// maestro the work-agent is simulated and applies the manifests to the fake cluster of the suite
+func setupWatchConsumer(agentName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		conn := ctx.Value("grpc-connction").(*grpc.ClientConn)
+		pbConsumer, err := maestropbv1.NewConsumerServiceClient(conn).Create(ctx, &maestropbv1.ConsumerCreateRequest{
+			Labels: []*maestropbv1.ConsumerLabel{{Key: "maestro-e2e/watch", Value: agentName}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if runsAgainstFake() {
+			server := ctx.Value("fake-maestro").(*fakemaestro.Server)
+			agent := fakeagent.Start(pbConsumer.Id, server, fakeagent.Options{Client: fakeCluster, Faults: fakeAgentFaults})
+			ctx = context.WithValue(ctx, "watch-fake-agent", agent)
+		} else if err := deployWorkAgent(ctx, cfg, agentName, pbConsumer.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("watch consumer: %s", pbConsumer.Id)
+		ctx = context.WithValue(ctx, "grpc-resource-client", maestropbv1.NewResourceServiceClient(conn))
+		ctx = context.WithValue(ctx, "grpc-manifest-client", maestropbv1.NewCloudEventsServiceClient(conn))
+		return context.WithValue(ctx, "watch-consumer-id", pbConsumer.Id)
+	}
+}
+
+func teardownWatchConsumer(agentName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if runsAgainstFake() {
+			if agent, ok := ctx.Value("watch-fake-agent").(*fakeagent.Agent); ok {
+				agent.Stop()
+			}
+			return ctx
+		}
+
+		if err := deleteWorkAgent(ctx, cfg, agentName); err != nil {
+			t.Logf("failed to delete %s: %v", agentName, err)
+		}
+		return ctx
+	}
+}
+
+// subscribeWatch returns a recorder of the events of the watch stream shared by the features
+func subscribeWatch(ctx context.Context) *watchrecorder.Recorder {
+	return ctx.Value("watch-hub").(*watchrecorder.Hub).Subscribe()
+}
+
+// subscribeWatchRecorder subscribes a watcher to the shared watch stream, it replaces the watchers of
+// a previous step
+func subscribeWatchRecorder() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		return context.WithValue(ctx, "watch-recorders", []*watchrecorder.Recorder{subscribeWatch(ctx)})
+	}
+}
+
+// startConsumerWatch watches the events of the watch consumer. Maestro ignores the ResourceWatchRequest
+// (CloudEventsService.Watch in internal/service/v1/manifests) and sends the events of every consumer,
+// so the watcher subscribes to the shared stream, with WATCH_SCOPED=true it opens a stream of its own
+// scoped to the consumer instead
+func startConsumerWatch() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		if os.Getenv("WATCH_SCOPED") != "true" {
+			return subscribeWatchRecorder()(ctx, t, cfg)
+		}
+
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		recorder, err := watchrecorder.Start(ctx, grpcClient, &maestropbv1.ResourceWatchRequest{
+			Id: ctx.Value("watch-consumer-id").(string),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return context.WithValue(ctx, "watch-recorders", []*watchrecorder.Recorder{recorder})
+	}
+}
+
+// startWatchStreams opens watch streams of their own next to the shared one, for the features of
+// the watch stream itself, maestro hands each status event to one of the open streams
+func startWatchStreams(count int) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		recorders := make([]*watchrecorder.Recorder, 0, count)
+		for i := 0; i < count; i++ {
+			recorder, err := watchrecorder.Start(ctx, grpcClient, &maestropbv1.ResourceWatchRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorders = append(recorders, recorder)
+		}
+		return context.WithValue(ctx, "watch-recorders", recorders)
+	}
+}
+
+func stopWatchRecorders() features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		recorders, _ := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)
+		for _, recorder := range recorders {
+			if err := recorder.Stop(time.Second * 30); err != nil {
+				t.Logf("failed to stop the watch recorder: %v", err)
+			}
+		}
+		return ctx
+	}
+}
+
+func createWatchResource(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		objStruct, err := toStruct(newNginxDeployment(depName, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pbResource, err := grpcClient.Create(ctx, &maestropbv1.ResourceCreateRequest{
+			ConsumerId: ctx.Value("watch-consumer-id").(string),
+			Object:     objStruct,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := waitForDeploymentReadyReplicas(cfg, depName, "default", 1, time.Minute*2); err != nil {
+			t.Fatal(err)
+		}
+
+		return context.WithValue(ctx, "watch-resource-id", pbResource.Id)
+	}
+}
+
+func deleteWatchResource(depName string) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		resourceID, ok := ctx.Value("watch-resource-id").(string)
+		if !ok {
+			return ctx
+		}
+
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		objStruct, err := toStruct(markDeleted(newNginxDeployment(depName, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: resourceID, Object: objStruct}); err != nil {
+			t.Logf("failed to delete resource %s: %v", resourceID, err)
+			return ctx
+		}
+
+		if err := waitForDeploymentDeleted(cfg, depName, "default", time.Minute*2); err != nil {
+			t.Logf("failed to wait for deployment %s deletion: %v", depName, err)
+		}
+		return ctx
+	}
+}
+
+// assessWatchedGeneration verifies that every watcher receives the applied status of the generation
+func assessWatchedGeneration(generation int64) features.Func {
+	return func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+		consumer := ctx.Value("watch-consumer-id").(string)
+		recorders := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)
+		for i, recorder := range recorders {
+			_, err := recorder.WaitFor(func(record watchrecorder.Record) bool {
+				version, err := record.ResourceVersion()
+				return err == nil && record.Event.Source() == consumer && version == generation &&
+					record.HasCondition(status.ConditionApplied, metav1.ConditionTrue)
+			}, time.Minute*2)
+			if err != nil {
+				t.Fatalf("watcher %d did not receive the status of generation %d: %v", i, generation, err)
+			}
+		}
+		return ctx
+	}
+}
+
+// watchedRecords returns the events of the watch consumer received by the i-th watcher
+func watchedRecords(ctx context.Context, i int) []watchrecorder.Record {
+	recorder := ctx.Value("watch-recorders").([]*watchrecorder.Recorder)[i]
+	return watchrecorder.FromSource(recorder.Records(), ctx.Value("watch-consumer-id").(string))
+}
+
+// watchedContents identifies the events by their resourceversion and data, the event ids are
+// generated for each watcher
+func watchedContents(records []watchrecorder.Record) []string {
+	contents := make([]string, 0, len(records))
+	for _, record := range records {
+		version, _ := record.ResourceVersion()
+		contents = append(contents, fmt.Sprintf("%d/%x", version, sha256.Sum256(record.Event.Data())))
+	}
+	return contents
+}
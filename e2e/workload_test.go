@@ -0,0 +1,471 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/e2e-framework/klient/k8s"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+	"sigs.k8s.io/e2e-framework/klient/wait/conditions"
+	"sigs.k8s.io/e2e-framework/pkg/envconf"
+	"sigs.k8s.io/e2e-framework/pkg/features"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+// the APIs the workloads are delivered through
+const (
+	workloadAPIResource = "resource"
+	workloadAPIManifest = "manifest"
+)
+
+// workloadCase is a workload of the matrix, each object is delivered as a resource of its own,
+// in order, so the namespace or the CRD is applied before the objects that depend on it
+type workloadCase struct {
+	name    string
+	objects func(api string) []workloadObject
+}
+
+type workloadObject struct {
+	obj *unstructured.Unstructured
+	// ready checks the object on the cluster, it is nil for an object that is not expected to be applied
+	ready func(live *unstructured.Unstructured) bool
+	// feedback checks the status feedback maestro reports for the object, maestro
+	// returns the .status of the object so the objects without a status report nothing
+	feedback func(status.ContentStatus) bool
+}
+
+// workloadState is the state of a matrix feature, the resources created so far
+type workloadState struct {
+	ids      []string
+	builders map[string]*manifestevent.Builder
+}
+
+func TestWorkloadMatrix(t *testing.T) {
+	var feats []features.Feature
+	for _, api := range []string{workloadAPIResource, workloadAPIManifest} {
+		for _, c := range workloadCases() {
+			feats = append(feats, workloadFeature(api, c))
+		}
+	}
+
+	testenv.Test(t, feats...)
+}
+
+func workloadFeature(api string, c workloadCase) features.Feature {
+	objects := c.objects(api)
+	return features.New(fmt.Sprintf("Workload matrix %s %s", api, c.name)).
+		WithLabel("type", "grpc").
+		WithLabel("res", api).
+		WithLabel("workload", c.name).
+		Setup(setupDeletionClients()).
+		Setup(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			return context.WithValue(ctx, "workload-state", &workloadState{builders: map[string]*manifestevent.Builder{}})
+		}).
+		Assess("should apply the objects in order", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+			state := ctx.Value("workload-state").(*workloadState)
+			for _, o := range objects {
+				id, err := createWorkloadObject(ctx, api, state, o.obj)
+				if err != nil {
+					t.Fatalf("failed to create %s %s: %v", o.obj.GetKind(), o.obj.GetName(), err)
+				}
+
+				last, err := status.WaitFor(ctx, grpcClient, id, func(r *maestropbv1.Resource, s *status.ResourceStatus) bool {
+					return s.ResourceGenerationID >= r.GenerationId &&
+						s.HasCondition(status.ConditionApplied, metav1.ConditionTrue) &&
+						o.feedback(s.ContentStatus)
+				}, time.Minute*3)
+				if err != nil {
+					t.Fatalf("%s %s is not applied with the expected status feedback: %v, last status: %+v", o.obj.GetKind(), o.obj.GetName(), err, last)
+				}
+			}
+			return ctx
+		}).
+		Assess("should be ready on the cluster", func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			for _, o := range objects {
+				live := &unstructured.Unstructured{}
+				live.SetGroupVersionKind(o.obj.GroupVersionKind())
+				live.SetName(o.obj.GetName())
+				live.SetNamespace(o.obj.GetNamespace())
+
+				err := wait.For(conditions.New(cfg.Client().Resources()).ResourceMatch(live, func(object k8s.Object) bool {
+					return o.ready(object.(*unstructured.Unstructured))
+				}), wait.WithTimeout(time.Minute*3))
+				if err != nil {
+					t.Fatalf("%s %s is not ready: %v", o.obj.GetKind(), o.obj.GetName(), err)
+				}
+			}
+			return ctx
+		}).
+		Teardown(func(ctx context.Context, t *testing.T, cfg *envconf.Config) context.Context {
+			state := ctx.Value("workload-state").(*workloadState)
+			// the dependent objects are deleted first
+			for i := len(state.ids) - 1; i >= 0; i-- {
+				if err := deleteWorkloadObject(ctx, api, state, state.ids[i], objects[i].obj); err != nil {
+					t.Logf("failed to delete %s %s: %v", objects[i].obj.GetKind(), objects[i].obj.GetName(), err)
+				}
+			}
+			return ctx
+		}).
+		Feature()
+}
+
+// createWorkloadObject delivers the object through the API and returns the id of the resource
+func createWorkloadObject(ctx context.Context, api string, state *workloadState, obj *unstructured.Unstructured) (string, error) {
+	var id string
+	switch api {
+	case workloadAPIResource:
+		objStruct, err := toStruct(obj)
+		if err != nil {
+			return "", err
+		}
+
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		pbResource, err := grpcClient.Create(ctx, &maestropbv1.ResourceCreateRequest{
+			ConsumerId: consumerID,
+			Object:     objStruct,
+		})
+		if err != nil {
+			return "", err
+		}
+		id = pbResource.Id
+	case workloadAPIManifest:
+		builder := manifestevent.NewBuilder(consumerID)
+		pbEvt, err := builder.BuildProto(manifestevent.ActionCreate, obj)
+		if err != nil {
+			return "", err
+		}
+
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		if _, err := grpcClient.Send(ctx, pbEvt); err != nil {
+			return "", err
+		}
+		id = builder.ResourceID()
+		state.builders[id] = builder
+	default:
+		return "", fmt.Errorf("unknown workload API %s", api)
+	}
+
+	state.ids = append(state.ids, id)
+	return id, nil
+}
+
+func deleteWorkloadObject(ctx context.Context, api string, state *workloadState, id string, obj *unstructured.Unstructured) error {
+	switch api {
+	case workloadAPIResource:
+		objStruct, err := toStruct(markDeleted(obj))
+		if err != nil {
+			return err
+		}
+
+		grpcClient := ctx.Value("grpc-resource-client").(maestropbv1.ResourceServiceClient)
+		_, err = grpcClient.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: id, Object: objStruct})
+		return err
+	case workloadAPIManifest:
+		pbEvt, err := state.builders[id].BuildProto(manifestevent.ActionDelete, obj)
+		if err != nil {
+			return err
+		}
+
+		grpcClient := ctx.Value("grpc-manifest-client").(maestropbv1.CloudEventsServiceClient)
+		_, err = grpcClient.Send(ctx, pbEvt)
+		return err
+	}
+	return fmt.Errorf("unknown workload API %s", api)
+}
+
+func workloadCases() []workloadCase {
+	return []workloadCase{
+		{
+			name: "configmap",
+			objects: func(api string) []workloadObject {
+				obj := newWorkloadObject("v1", "ConfigMap", "default", "matrix-configmap-"+api, map[string]interface{}{
+					"data": map[string]interface{}{"key": "value"},
+				})
+				return []workloadObject{{
+					obj: obj,
+					ready: func(live *unstructured.Unstructured) bool {
+						value, _, _ := unstructured.NestedString(live.Object, "data", "key")
+						return value == "value"
+					},
+					feedback: noContentStatus,
+				}}
+			},
+		},
+		{
+			name: "secret",
+			objects: func(api string) []workloadObject {
+				obj := newWorkloadObject("v1", "Secret", "default", "matrix-secret-"+api, map[string]interface{}{
+					"type": "Opaque",
+					// base64 of "secret"
+					"data": map[string]interface{}{"password": "c2VjcmV0"},
+				})
+				return []workloadObject{{
+					obj: obj,
+					ready: func(live *unstructured.Unstructured) bool {
+						password, _, _ := unstructured.NestedString(live.Object, "data", "password")
+						return password == "c2VjcmV0"
+					},
+					feedback: noContentStatus,
+				}}
+			},
+		},
+		{
+			name: "service",
+			objects: func(api string) []workloadObject {
+				name := "matrix-service-" + api
+				obj := newWorkloadObject("v1", "Service", "default", name, map[string]interface{}{
+					"spec": map[string]interface{}{
+						"selector": map[string]interface{}{"app": name},
+						"ports": []interface{}{
+							map[string]interface{}{"port": int64(80), "targetPort": int64(80)},
+						},
+					},
+				})
+				return []workloadObject{{
+					obj: obj,
+					ready: func(live *unstructured.Unstructured) bool {
+						clusterIP, _, _ := unstructured.NestedString(live.Object, "spec", "clusterIP")
+						return clusterIP != ""
+					},
+					feedback: func(c status.ContentStatus) bool {
+						_, found := c["loadBalancer"]
+						return found
+					},
+				}}
+			},
+		},
+		{
+			name: "job",
+			objects: func(api string) []workloadObject {
+				obj := newWorkloadObject("batch/v1", "Job", "default", "matrix-job-"+api, map[string]interface{}{
+					"spec": newWorkloadJobSpec(),
+				})
+				return []workloadObject{{
+					obj: obj,
+					ready: func(live *unstructured.Unstructured) bool {
+						succeeded, _, _ := unstructured.NestedInt64(live.Object, "status", "succeeded")
+						return succeeded == 1
+					},
+					feedback: func(c status.ContentStatus) bool {
+						succeeded, _ := c.Int64("succeeded")
+						return succeeded == 1
+					},
+				}}
+			},
+		},
+		{
+			name: "cronjob",
+			objects: func(api string) []workloadObject {
+				obj := newWorkloadObject("batch/v1", "CronJob", "default", "matrix-cronjob-"+api, map[string]interface{}{
+					"spec": map[string]interface{}{
+						// once a year, the cronjob is not expected to run during the test
+						"schedule":    "0 0 1 1 *",
+						"jobTemplate": map[string]interface{}{"spec": newWorkloadJobSpec()},
+					},
+				})
+				return []workloadObject{{
+					obj: obj,
+					ready: func(live *unstructured.Unstructured) bool {
+						schedule, _, _ := unstructured.NestedString(live.Object, "spec", "schedule")
+						return schedule == "0 0 1 1 *"
+					},
+					// the cronjob has not been scheduled, its status is empty
+					feedback: noContentStatus,
+				}}
+			},
+		},
+		{
+			name: "statefulset",
+			objects: func(api string) []workloadObject {
+				name := "matrix-statefulset-" + api
+				dep := newNginxDeployment(name, 1)
+				spec, _, _ := unstructured.NestedMap(dep.Object, "spec")
+				spec["serviceName"] = name
+				obj := newWorkloadObject("apps/v1", "StatefulSet", "default", name, map[string]interface{}{
+					"spec": spec,
+				})
+				return []workloadObject{{
+					obj:      obj,
+					ready:    readyReplicas(1),
+					feedback: readyReplicasFeedback(1),
+				}}
+			},
+		},
+		{
+			name: "namespace-deployment",
+			objects: func(api string) []workloadObject {
+				namespace := "matrix-namespace-" + api
+				dep := newNginxDeployment("matrix-deployment-"+api, 1)
+				dep.SetNamespace(namespace)
+				return []workloadObject{
+					{
+						obj: newWorkloadObject("v1", "Namespace", "", namespace, nil),
+						ready: func(live *unstructured.Unstructured) bool {
+							phase, _, _ := unstructured.NestedString(live.Object, "status", "phase")
+							return phase == "Active"
+						},
+						feedback: func(c status.ContentStatus) bool {
+							phase, _ := c.String("phase")
+							return phase == "Active"
+						},
+					},
+					{
+						obj:      dep,
+						ready:    readyReplicas(1),
+						feedback: readyReplicasFeedback(1),
+					},
+				}
+			},
+		},
+		{
+			name: "clusterrole",
+			objects: func(api string) []workloadObject {
+				obj := newWorkloadObject("rbac.authorization.k8s.io/v1", "ClusterRole", "", "maestro-e2e-matrix-"+api, map[string]interface{}{
+					"rules": []interface{}{
+						map[string]interface{}{
+							"apiGroups": []interface{}{""},
+							"resources": []interface{}{"configmaps"},
+							"verbs":     []interface{}{"get"},
+						},
+					},
+				})
+				return []workloadObject{{
+					obj: obj,
+					ready: func(live *unstructured.Unstructured) bool {
+						rules, _, _ := unstructured.NestedSlice(live.Object, "rules")
+						return len(rules) == 1
+					},
+					feedback: noContentStatus,
+				}}
+			},
+		},
+		{
+			name: "crd-customresource",
+			objects: func(api string) []workloadObject {
+				group := api + ".matrix.maestro-e2e.io"
+				crd := newWorkloadObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "widgets."+group, map[string]interface{}{
+					"spec": map[string]interface{}{
+						"group": group,
+						"names": map[string]interface{}{
+							"kind":     "Widget",
+							"listKind": "WidgetList",
+							"plural":   "widgets",
+							"singular": "widget",
+						},
+						"scope": "Namespaced",
+						"versions": []interface{}{
+							map[string]interface{}{
+								"name":    "v1",
+								"served":  true,
+								"storage": true,
+								"schema": map[string]interface{}{
+									"openAPIV3Schema": map[string]interface{}{
+										"type":                                 "object",
+										"x-kubernetes-preserve-unknown-fields": true,
+									},
+								},
+							},
+						},
+					},
+				})
+				cr := newWorkloadObject(group+"/v1", "Widget", "default", "matrix-widget", map[string]interface{}{
+					"spec": map[string]interface{}{"size": int64(1)},
+				})
+				return []workloadObject{
+					{
+						obj: crd,
+						ready: func(live *unstructured.Unstructured) bool {
+							conds, _, _ := unstructured.NestedSlice(live.Object, "status", "conditions")
+							return hasTrueCondition(conds, "Established")
+						},
+						feedback: func(c status.ContentStatus) bool {
+							conds, _, _ := unstructured.NestedSlice(c, "conditions")
+							return hasTrueCondition(conds, "Established")
+						},
+					},
+					{
+						obj: cr,
+						ready: func(live *unstructured.Unstructured) bool {
+							size, _, _ := unstructured.NestedInt64(live.Object, "spec", "size")
+							return size == 1
+						},
+						// the custom resource has no status
+						feedback: noContentStatus,
+					},
+				}
+			},
+		},
+	}
+}
+
+// newWorkloadObject returns an object of the kind with the given fields besides the metadata
+func newWorkloadObject(apiVersion, kind, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	for key, value := range fields {
+		obj.Object[key] = value
+	}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	if namespace != "" {
+		obj.SetNamespace(namespace)
+	}
+	return obj
+}
+
+// newWorkloadJobSpec returns a job that runs to completion with the nginx image of the suite
+func newWorkloadJobSpec() map[string]interface{} {
+	return map[string]interface{}{
+		"backoffLimit": int64(2),
+		"template": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"restartPolicy": "Never",
+				"containers": []interface{}{
+					map[string]interface{}{
+						"name":            "nginx",
+						"image":           "quay.io/jitesoft/nginx",
+						"imagePullPolicy": "IfNotPresent",
+						"command":         []interface{}{"nginx", "-v"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func readyReplicas(replicas int64) func(*unstructured.Unstructured) bool {
+	return func(live *unstructured.Unstructured) bool {
+		ready, _, _ := unstructured.NestedInt64(live.Object, "status", "readyReplicas")
+		return ready == replicas
+	}
+}
+
+func readyReplicasFeedback(replicas int64) func(status.ContentStatus) bool {
+	return func(c status.ContentStatus) bool {
+		ready, _ := c.Int64("readyReplicas")
+		return ready == replicas
+	}
+}
+
+func noContentStatus(c status.ContentStatus) bool {
+	return len(c) == 0
+}
+
+// hasTrueCondition returns true when the unstructured conditions have the condition of the type with status True
+func hasTrueCondition(conds []interface{}, conditionType string) bool {
+	for _, cond := range conds {
+		c, ok := cond.(map[string]interface{})
+		if ok && c["type"] == conditionType && c["status"] == string(metav1.ConditionTrue) {
+			return true
+		}
+	}
+	return false
+}
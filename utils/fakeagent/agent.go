@@ -0,0 +1,382 @@
+// Package fakeagent is a simulated work agent, it applies the spec events of a consumer to a fake
+// Kubernetes client and publishes the status events of the applied objects with a synthetic status,
+// so the create→apply→status loop can run without a cluster.
+package fakeagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	agentclient "open-cluster-management.io/api/cloudevents/work/agent/client"
+	agentcodec "open-cluster-management.io/api/cloudevents/work/agent/codec"
+	workpayload "open-cluster-management.io/api/cloudevents/work/payload"
+	workv1 "open-cluster-management.io/api/work/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/morvencao/maestro-e2e/utils/fakemaestro"
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+)
+
+// queueSize is the number of the spec events buffered for the agent
+const queueSize = 1024
+
+// Transport delivers the spec events of the consumer to the agent and its status events to maestro,
+// *fakemaestro.Server is the in-memory transport
+type Transport interface {
+	SubscribeSpecs(consumerID string, handler fakemaestro.SpecHandler) (unsubscribe func())
+	PublishStatus(evt *cloudevents.Event) error
+}
+
+// Logger is the logger the errors of the agent are reported to, *testing.T satisfies it
+type Logger interface {
+	Logf(format string, args ...interface{})
+}
+
+// Faults are the faults injected in the handling of the spec events
+type Faults struct {
+	// Delay is the wait before each spec event is handled, the events are handled one at a time
+	Delay time.Duration
+	// DropRate is the fraction of the status events that are not published, from 0 to 1
+	DropRate float64
+	// ErrorRate is the fraction of the manifests that are not applied and reported as failed, from 0 to 1
+	ErrorRate float64
+}
+
+// Options configures the agent
+type Options struct {
+	// Client is the cluster the manifests are applied to, a fake client of the client-go scheme by default,
+	// its RESTMapper must map the kinds of the manifests
+	Client client.Client
+	// StatusFunc returns the synthetic status of an applied object, DefaultStatus by default
+	StatusFunc func(obj *unstructured.Unstructured) map[string]interface{}
+	// Faults are the faults injected from the start, they can be changed with SetFaults
+	Faults Faults
+	// Logger is the logger of the errors of the agent, they are dropped when it is nil
+	Logger Logger
+}
+
+// Agent is a simulated work agent of a consumer
+type Agent struct {
+	consumerID string
+	transport  Transport
+	client     client.Client
+	codec      *agentcodec.ManifestCodec
+	statusFunc func(obj *unstructured.Unstructured) map[string]interface{}
+	logger     Logger
+
+	mu     sync.Mutex
+	faults Faults
+	// works are the last works of the resources, they are only handled by the run goroutine
+	works map[string]*workv1.ManifestWork
+
+	specs       chan *cloudevents.Event
+	unsubscribe func()
+	done        chan struct{}
+	stopped     chan struct{}
+	stopOnce    sync.Once
+}
+
+// Start subscribes the agent to the spec events of the consumer and handles them until Stop is called
+func Start(consumerID string, transport Transport, opts Options) *Agent {
+	if opts.Client == nil {
+		opts.Client = fake.NewClientBuilder().
+			WithScheme(clientgoscheme.Scheme).
+			WithRESTMapper(testrestmapper.TestOnlyStaticRESTMapper(clientgoscheme.Scheme)).
+			Build()
+	}
+	if opts.StatusFunc == nil {
+		opts.StatusFunc = DefaultStatus
+	}
+
+	a := &Agent{
+		consumerID: consumerID,
+		transport:  transport,
+		client:     opts.Client,
+		codec:      agentcodec.NewManifestCodec(opts.Client.RESTMapper()),
+		statusFunc: opts.StatusFunc,
+		logger:     opts.Logger,
+		faults:     opts.Faults,
+		works:      map[string]*workv1.ManifestWork{},
+		specs:      make(chan *cloudevents.Event, queueSize),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	a.unsubscribe = transport.SubscribeSpecs(consumerID, func(evt *cloudevents.Event) {
+		select {
+		case a.specs <- evt:
+		case <-a.done:
+		}
+	})
+	go a.run()
+
+	return a
+}
+
+// Stop unsubscribes the agent and waits for the spec event being handled, the queued ones are dropped
+func (a *Agent) Stop() {
+	a.stopOnce.Do(func() {
+		a.unsubscribe()
+		close(a.done)
+		<-a.stopped
+	})
+}
+
+// Client returns the client the manifests are applied to
+func (a *Agent) Client() client.Client {
+	return a.client
+}
+
+// SetFaults replaces the faults injected in the handling of the next spec events
+func (a *Agent) SetFaults(faults Faults) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults = faults
+}
+
+func (a *Agent) getFaults() Faults {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.faults
+}
+
+func (a *Agent) run() {
+	defer close(a.stopped)
+	for {
+		select {
+		case <-a.done:
+			return
+		case evt := <-a.specs:
+			if delay := a.getFaults().Delay; delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-a.done:
+					return
+				}
+			}
+
+			if err := a.handle(evt); err != nil {
+				a.logf("failed to handle spec event %s: %v", evt.ID(), err)
+			}
+		}
+	}
+}
+
+// handle applies or deletes the manifest of the spec event and publishes the status of the work
+func (a *Agent) handle(evt *cloudevents.Event) error {
+	work, err := a.codec.Decode(evt)
+	if err != nil {
+		return err
+	}
+
+	// the spec events may be redelivered or reordered, the work agent ignores a spec that is not newer
+	// than the last one of the resource
+	if last, ok := a.works[string(work.UID)]; ok {
+		newer, err := isNewer(work, last)
+		if err != nil {
+			return err
+		}
+		if !newer {
+			a.logf("dropped the spec of resource %s with resourceversion %s, the last one is %s", work.UID, work.ResourceVersion, last.ResourceVersion)
+			return nil
+		}
+	}
+
+	action := cetypes.EventAction(manifestevent.ActionUpdate)
+	if !work.DeletionTimestamp.IsZero() {
+		// the deletion carries no manifest, the manifest of the last work is deleted and the deletion
+		// of an unknown work is ignored, the deleted work is kept so the older specs are still dropped
+		last, ok := a.works[string(work.UID)]
+		if !ok {
+			return nil
+		}
+		if err := a.delete(last); err != nil {
+			return err
+		}
+		last.DeletionTimestamp = work.DeletionTimestamp
+		last.ResourceVersion = work.ResourceVersion
+		work = last
+		a.works[string(work.UID)] = work
+
+		// the agent reports the deletion once the manifests are removed, as the work agent does
+		meta.SetStatusCondition(&work.Status.Conditions, metav1.Condition{
+			Type:    agentclient.ManifestsDeleted,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ManifestsDeleted",
+			Message: fmt.Sprintf("The manifests are deleted from the cluster %s", work.Namespace),
+		})
+		action = agentclient.DeleteRequestAction
+	} else {
+		if err := a.apply(work); err != nil {
+			return err
+		}
+		a.works[string(work.UID)] = work
+	}
+
+	if rand.Float64() < a.getFaults().DropRate {
+		a.logf("dropped the status of resource %s", work.UID)
+		return nil
+	}
+
+	eventType := cetypes.CloudEventsType{
+		CloudEventsDataType: workpayload.ManifestEventDataType,
+		SubResource:         cetypes.SubResourceStatus,
+		Action:              action,
+	}
+	statusEvt, err := a.codec.Encode(a.consumerID+"-work-agent", eventType, work)
+	if err != nil {
+		return err
+	}
+	return a.transport.PublishStatus(statusEvt)
+}
+
+// apply applies the manifest of the work and sets the status of the work, a manifest that cannot be
+// applied, or is failed by the injected faults, is reported with the Applied condition set to false
+func (a *Agent) apply(work *workv1.ManifestWork) error {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(work.Spec.Workload.Manifests[0].Raw); err != nil {
+		return err
+	}
+
+	objStatus := a.statusFunc(obj)
+	var applyErr error
+	if rand.Float64() < a.getFaults().ErrorRate {
+		applyErr = fmt.Errorf("injected apply error")
+	} else {
+		applyErr = a.createOrUpdate(obj, objStatus)
+	}
+
+	manifestCondition := workv1.ManifestCondition{
+		ResourceMeta: workv1.ManifestResourceMeta{
+			Group:     obj.GroupVersionKind().Group,
+			Version:   obj.GroupVersionKind().Version,
+			Kind:      obj.GetKind(),
+			Name:      obj.GetName(),
+			Namespace: obj.GetNamespace(),
+		},
+	}
+	if applyErr != nil {
+		condition := metav1.Condition{
+			Type:    workv1.WorkApplied,
+			Status:  metav1.ConditionFalse,
+			Reason:  "AppliedManifestWorkFailed",
+			Message: fmt.Sprintf("Failed to apply manifest: %v", applyErr),
+		}
+		meta.SetStatusCondition(&manifestCondition.Conditions, condition)
+		meta.SetStatusCondition(&work.Status.Conditions, condition)
+		work.Status.ResourceStatus.Manifests = []workv1.ManifestCondition{manifestCondition}
+		return nil
+	}
+
+	conditions := []metav1.Condition{
+		{Type: workv1.WorkApplied, Status: metav1.ConditionTrue, Reason: "AppliedManifestWorkComplete", Message: "Apply manifest work complete"},
+		{Type: workv1.WorkAvailable, Status: metav1.ConditionTrue, Reason: "ResourcesAvailable", Message: "All resources are available"},
+	}
+	for _, condition := range conditions {
+		meta.SetStatusCondition(&manifestCondition.Conditions, condition)
+		meta.SetStatusCondition(&work.Status.Conditions, condition)
+	}
+
+	// the status is the "status" feedback maestro asks for, an object without status has no feedback
+	if objStatus != nil {
+		raw, err := json.Marshal(objStatus)
+		if err != nil {
+			return err
+		}
+		rawString := string(raw)
+		manifestCondition.StatusFeedbacks.Values = []workv1.FeedbackValue{{
+			Name:  "status",
+			Value: workv1.FieldValue{Type: workv1.JsonRaw, JsonRaw: &rawString},
+		}}
+		meta.SetStatusCondition(&manifestCondition.Conditions, metav1.Condition{
+			Type: "StatusFeedbackSynced", Status: metav1.ConditionTrue, Reason: "StatusFeedbackSynced",
+		})
+	}
+	work.Status.ResourceStatus.Manifests = []workv1.ManifestCondition{manifestCondition}
+	return nil
+}
+
+// createOrUpdate writes the object with its synthetic status to the client
+func (a *Agent) createOrUpdate(obj *unstructured.Unstructured, objStatus map[string]interface{}) error {
+	ctx := context.Background()
+	desired := obj.DeepCopy()
+	if objStatus != nil {
+		if err := unstructured.SetNestedField(desired.Object, objStatus, "status"); err != nil {
+			return err
+		}
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+	err := a.client.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	switch {
+	case apierrors.IsNotFound(err):
+		return a.client.Create(ctx, desired)
+	case err != nil:
+		return err
+	}
+
+	desired.SetResourceVersion(existing.GetResourceVersion())
+	if err := a.client.Update(ctx, desired); err != nil {
+		return err
+	}
+	if objStatus == nil {
+		return nil
+	}
+
+	// the update keeps the status of the kinds with a status subresource, it is written through the
+	// subresource, which the other kinds do not have
+	if err := unstructured.SetNestedField(desired.Object, objStatus, "status"); err != nil {
+		return err
+	}
+	if err := a.client.Status().Update(ctx, desired); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// delete deletes the object of the manifest of the work, the objects of the works that failed to
+// apply are not in the client
+func (a *Agent) delete(work *workv1.ManifestWork) error {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(work.Spec.Workload.Manifests[0].Raw); err != nil {
+		return err
+	}
+	if err := a.client.Delete(context.Background(), obj); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// isNewer returns true when the resourceversion of the work is greater than the one of the last work
+func isNewer(work, last *workv1.ManifestWork) (bool, error) {
+	version, err := strconv.ParseInt(work.ResourceVersion, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the resourceversion of the work %s, %v", work.UID, err)
+	}
+	lastVersion, err := strconv.ParseInt(last.ResourceVersion, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the resourceversion of the work %s, %v", last.UID, err)
+	}
+	return version > lastVersion, nil
+}
+
+func (a *Agent) logf(format string, args ...interface{}) {
+	if a.logger != nil {
+		a.logger.Logf(format, args...)
+	}
+}
@@ -0,0 +1,222 @@
+package fakeagent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	agentclient "open-cluster-management.io/api/cloudevents/work/agent/client"
+	workv1 "open-cluster-management.io/api/work/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/morvencao/maestro-e2e/utils/fakemaestro"
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+const consumerID = "cluster1"
+
+func newDeployment(name string, replicas int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": name}},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": name}},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{map[string]interface{}{"name": "nginx", "image": "nginx:1.14.2"}},
+				},
+			},
+		},
+	}}
+}
+
+func setup(t *testing.T, opts Options) (*fakemaestro.Server, *Agent, maestropbv1.ResourceServiceClient, maestropbv1.CloudEventsServiceClient) {
+	server, err := fakemaestro.New()
+	require.NoError(t, err, "fakemaestro.New()")
+	t.Cleanup(server.Close)
+
+	opts.Logger = t
+	agent := Start(consumerID, server, opts)
+	t.Cleanup(agent.Stop)
+
+	conn, err := server.Dial(context.Background())
+	require.NoError(t, err, "Dial()")
+	t.Cleanup(func() { conn.Close() })
+	cloudEvents := maestropbv1.NewCloudEventsServiceClient(conn)
+
+	// the statuses are only stored once a watcher receives them
+	watchCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	watch, err := cloudEvents.Watch(watchCtx, &maestropbv1.ResourceWatchRequest{})
+	require.NoError(t, err, "Watch()")
+	go func() {
+		for {
+			if _, err := watch.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return server, agent, maestropbv1.NewResourceServiceClient(conn), cloudEvents
+}
+
+func createResource(t *testing.T, resources maestropbv1.ResourceServiceClient, obj *unstructured.Unstructured) *maestropbv1.Resource {
+	object, err := structpb.NewStruct(obj.Object)
+	require.NoError(t, err)
+	res, err := resources.Create(context.Background(), &maestropbv1.ResourceCreateRequest{ConsumerId: consumerID, Object: object})
+	require.NoError(t, err, "Create()")
+	return res
+}
+
+func updateResource(t *testing.T, resources maestropbv1.ResourceServiceClient, id string, obj *unstructured.Unstructured) *maestropbv1.Resource {
+	object, err := structpb.NewStruct(obj.Object)
+	require.NoError(t, err)
+	res, err := resources.Update(context.Background(), &maestropbv1.ResourceUpdateRequest{Id: id, Object: object})
+	require.NoError(t, err, "Update()")
+	return res
+}
+
+// waitForStatus waits until the status of the generation of the resource matches
+func waitForStatus(t *testing.T, resources maestropbv1.ResourceServiceClient, id string, generation int64, match func(*status.ResourceStatus) bool) *status.ResourceStatus {
+	var last *status.ResourceStatus
+	require.Eventually(t, func() bool {
+		res, err := resources.Read(context.Background(), &maestropbv1.ResourceReadRequest{Id: id})
+		require.NoError(t, err, "Read()")
+		last, err = status.FromResource(res)
+		require.NoError(t, err, "FromResource()")
+		return last.ResourceGenerationID >= generation && match(last)
+	}, 5*time.Second, 10*time.Millisecond, "status of resource %s generation %d", id, generation)
+	return last
+}
+
+func getDeployment(t *testing.T, c client.Client, name string) (*appsv1.Deployment, error) {
+	deploy := &appsv1.Deployment{}
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, deploy)
+	return deploy, err
+}
+
+func TestApplyAndDelete(t *testing.T) {
+	_, agent, resources, cloudEvents := setup(t, Options{})
+
+	created := createResource(t, resources, newDeployment("nginx", 1))
+	resourceStatus := waitForStatus(t, resources, created.Id, 1, func(s *status.ResourceStatus) bool {
+		return s.HasCondition(workv1.WorkApplied, metav1.ConditionTrue)
+	})
+	assert.True(t, resourceStatus.HasCondition(workv1.WorkAvailable, metav1.ConditionTrue), "available condition")
+	readyReplicas, _ := resourceStatus.ContentStatus.Int64("readyReplicas")
+	assert.Equal(t, int64(1), readyReplicas, "readyReplicas")
+
+	deploy, err := getDeployment(t, agent.Client(), "nginx")
+	require.NoError(t, err, "applied deployment")
+	assert.Equal(t, int32(1), deploy.Status.ReadyReplicas, "synthetic status of the applied deployment")
+
+	updated := updateResource(t, resources, created.Id, newDeployment("nginx", 3))
+	resourceStatus = waitForStatus(t, resources, created.Id, updated.GenerationId, func(s *status.ResourceStatus) bool {
+		readyReplicas, _ := s.ContentStatus.Int64("readyReplicas")
+		return readyReplicas == 3
+	})
+	deploy, err = getDeployment(t, agent.Client(), "nginx")
+	require.NoError(t, err, "updated deployment")
+	assert.Equal(t, int32(3), *deploy.Spec.Replicas, "replicas of the updated deployment")
+	assert.Equal(t, int32(3), deploy.Status.ReadyReplicas, "synthetic status of the updated deployment")
+
+	builder := manifestevent.NewBuilder(consumerID).WithResourceID(created.Id).WithResourceVersion(updated.GenerationId)
+	pbEvt, err := builder.BuildProto(manifestevent.ActionDelete, newDeployment("nginx", 3))
+	require.NoError(t, err, "BuildProto()")
+	_, err = cloudEvents.Send(context.Background(), pbEvt)
+	require.NoError(t, err, "Send()")
+
+	waitForStatus(t, resources, created.Id, builder.ResourceVersion(), func(s *status.ResourceStatus) bool {
+		return s.HasCondition(agentclient.ManifestsDeleted, metav1.ConditionTrue)
+	})
+	_, err = getDeployment(t, agent.Client(), "nginx")
+	assert.True(t, apierrors.IsNotFound(err), "deleted deployment, got %v", err)
+}
+
+func TestErrorFault(t *testing.T) {
+	_, agent, resources, _ := setup(t, Options{Faults: Faults{ErrorRate: 1}})
+
+	created := createResource(t, resources, newDeployment("nginx", 1))
+	resourceStatus := waitForStatus(t, resources, created.Id, 1, func(s *status.ResourceStatus) bool {
+		return s.HasCondition(workv1.WorkApplied, metav1.ConditionFalse)
+	})
+	assert.Contains(t, resourceStatus.Condition(workv1.WorkApplied).Message, "injected apply error")
+	assert.Nil(t, resourceStatus.ContentStatus, "no status feedback of a failed apply")
+
+	_, err := getDeployment(t, agent.Client(), "nginx")
+	assert.True(t, apierrors.IsNotFound(err), "the failed deployment is not applied, got %v", err)
+
+	agent.SetFaults(Faults{})
+	updated := updateResource(t, resources, created.Id, newDeployment("nginx", 2))
+	waitForStatus(t, resources, created.Id, updated.GenerationId, func(s *status.ResourceStatus) bool {
+		return s.HasCondition(workv1.WorkApplied, metav1.ConditionTrue)
+	})
+}
+
+func TestDropAndDelayFaults(t *testing.T) {
+	_, agent, resources, _ := setup(t, Options{Faults: Faults{DropRate: 1}})
+
+	created := createResource(t, resources, newDeployment("nginx", 1))
+	require.Eventually(t, func() bool {
+		_, err := getDeployment(t, agent.Client(), "nginx")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "the deployment is applied")
+
+	res, err := resources.Read(context.Background(), &maestropbv1.ResourceReadRequest{Id: created.Id})
+	require.NoError(t, err, "Read()")
+	resourceStatus, err := status.FromResource(res)
+	require.NoError(t, err, "FromResource()")
+	assert.Empty(t, resourceStatus.ReconcileStatus.Conditions, "the status is dropped")
+
+	delay := 200 * time.Millisecond
+	agent.SetFaults(Faults{Delay: delay})
+	start := time.Now()
+	updated := updateResource(t, resources, created.Id, newDeployment("nginx", 2))
+	waitForStatus(t, resources, created.Id, updated.GenerationId, func(s *status.ResourceStatus) bool {
+		return s.HasCondition(workv1.WorkApplied, metav1.ConditionTrue)
+	})
+	assert.GreaterOrEqual(t, time.Since(start), delay, "the status is delayed")
+}
+
+func TestStaleSpecs(t *testing.T) {
+	_, agent, resources, cloudEvents := setup(t, Options{})
+
+	created := createResource(t, resources, newDeployment("nginx", 1))
+	updated := updateResource(t, resources, created.Id, newDeployment("nginx", 3))
+	waitForStatus(t, resources, created.Id, updated.GenerationId, func(s *status.ResourceStatus) bool {
+		return s.HasCondition(workv1.WorkApplied, metav1.ConditionTrue)
+	})
+
+	// a redelivered spec and a deletion with the last resource version are dropped
+	builder := manifestevent.NewBuilder(consumerID).WithResourceID(created.Id)
+	pbEvt, err := builder.BuildProto(manifestevent.ActionUpdate, newDeployment("nginx", 5))
+	require.NoError(t, err, "BuildProto()")
+	_, err = cloudEvents.Send(context.Background(), pbEvt)
+	require.NoError(t, err, "Send()")
+	pbEvt, err = builder.BuildProto(manifestevent.ActionDelete, newDeployment("nginx", 5))
+	require.NoError(t, err, "BuildProto()")
+	_, err = cloudEvents.Send(context.Background(), pbEvt)
+	require.NoError(t, err, "Send()")
+
+	// the specs are handled in order, the stale ones are handled once the next resource is applied
+	next := createResource(t, resources, newDeployment("next", 1))
+	waitForStatus(t, resources, next.Id, 1, func(s *status.ResourceStatus) bool {
+		return s.HasCondition(workv1.WorkApplied, metav1.ConditionTrue)
+	})
+
+	deploy, err := getDeployment(t, agent.Client(), "nginx")
+	require.NoError(t, err, "the deployment is not deleted by the stale deletion")
+	assert.Equal(t, int32(3), *deploy.Spec.Replicas, "replicas of the last spec")
+}
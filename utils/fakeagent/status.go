@@ -0,0 +1,41 @@
+package fakeagent
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DefaultStatus returns the status of the object once it is rolled out, the workloads have all their
+// replicas ready, the pods are running, and the other objects keep the status of their manifest
+func DefaultStatus(obj *unstructured.Unstructured) map[string]interface{} {
+	switch obj.GroupVersionKind().GroupKind().String() {
+	case "Deployment.apps", "StatefulSet.apps", "ReplicaSet.apps":
+		replicas, found, err := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+		if err != nil || !found {
+			replicas = 1
+		}
+		return map[string]interface{}{
+			"observedGeneration": obj.GetGeneration(),
+			"replicas":           replicas,
+			"readyReplicas":      replicas,
+			"availableReplicas":  replicas,
+			"updatedReplicas":    replicas,
+		}
+	case "DaemonSet.apps":
+		return map[string]interface{}{
+			"observedGeneration":     obj.GetGeneration(),
+			"currentNumberScheduled": int64(1),
+			"desiredNumberScheduled": int64(1),
+			"numberReady":            int64(1),
+			"numberAvailable":        int64(1),
+			"updatedNumberScheduled": int64(1),
+		}
+	case "Pod":
+		return map[string]interface{}{"phase": "Running"}
+	}
+
+	objStatus, found, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil || !found {
+		return nil
+	}
+	return objStatus
+}
@@ -0,0 +1,186 @@
+package fakemaestro
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cloudeventstypes "github.com/cloudevents/sdk-go/v2/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	agentcodec "open-cluster-management.io/api/cloudevents/work/agent/codec"
+	workpayload "open-cluster-management.io/api/cloudevents/work/payload"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+// the codec mirrors the one of maestro, the spec events ask the agent for the .status of the object
+// as the "status" feedback, and the status events are decoded into the resource status
+
+// encodeSpec returns the spec event maestro publishes to the agent of the consumer for the resource
+func encodeSpec(r *resource) (*cloudevents.Event, error) {
+	eventType := cetypes.CloudEventsType{
+		CloudEventsDataType: workpayload.ManifestEventDataType,
+		SubResource:         cetypes.SubResourceSpec,
+		Action:              cetypes.EventAction(manifestevent.ActionCreate),
+	}
+	evtBuilder := cetypes.NewEventBuilder(manifestevent.DefaultSource, eventType).
+		WithResourceID(r.id).
+		WithResourceVersion(r.generation).
+		WithClusterName(r.consumerID)
+
+	if !r.object.GetDeletionTimestamp().IsZero() {
+		evtBuilder.WithDeletionTimestamp(r.object.GetDeletionTimestamp().Time)
+	}
+
+	evt := evtBuilder.NewEvent()
+
+	resourcePayload := &workpayload.Manifest{
+		Manifest: *r.object,
+		DeleteOption: &workv1.DeleteOption{
+			PropagationPolicy: workv1.DeletePropagationPolicyTypeForeground,
+		},
+		ConfigOption: &workpayload.ManifestConfigOption{
+			FeedbackRules: []workv1.FeedbackRule{
+				{
+					Type:      workv1.JSONPathsType,
+					JsonPaths: []workv1.JsonPath{{Name: "status", Path: ".status"}},
+				},
+			},
+			UpdateStrategy: &workv1.UpdateStrategy{
+				Type: workv1.UpdateStrategyTypeUpdate,
+			},
+		},
+	}
+
+	resourcePayloadJSON, err := json.Marshal(resourcePayload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal resource payload: %v", err)
+	}
+
+	if err := evt.SetData(cloudevents.ApplicationJSON, resourcePayloadJSON); err != nil {
+		return nil, fmt.Errorf("failed to encode resource to cloud event: %v", err)
+	}
+
+	return &evt, nil
+}
+
+// decodeStatus returns the resource of a status event of the agent, only the ids and the status are set
+func decodeStatus(evt *cloudevents.Event) (*resource, error) {
+	eventType, err := cetypes.ParseCloudEventsType(evt.Type())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cloud event type %s, %v", evt.Type(), err)
+	}
+
+	if eventType.CloudEventsDataType != workpayload.ManifestEventDataType {
+		return nil, fmt.Errorf("unsupported cloudevents data type %s", eventType.CloudEventsDataType)
+	}
+
+	evtExtensions := evt.Context.GetExtensions()
+
+	resourceID, err := cloudeventstypes.ToString(evtExtensions[cetypes.ExtensionResourceID])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resourceid extension: %v", err)
+	}
+
+	resourceVersion, err := cloudeventstypes.ToString(evtExtensions[cetypes.ExtensionResourceVersion])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resourceversion extension: %v", err)
+	}
+
+	resourceVersionInt, err := strconv.ParseInt(resourceVersion, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert resourceversion - %v to int64", resourceVersion)
+	}
+
+	clusterName, err := cloudeventstypes.ToString(evtExtensions[cetypes.ExtensionClusterName])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clustername extension: %v", err)
+	}
+
+	resourceStatusPayload := &workpayload.ManifestStatus{}
+	if err := json.Unmarshal(evt.Data(), resourceStatusPayload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event data as resource status: %v", err)
+	}
+
+	r := &resource{
+		id:         resourceID,
+		consumerID: clusterName,
+		generation: resourceVersionInt,
+		object:     &unstructured.Unstructured{Object: map[string]interface{}{}},
+		status: status.ResourceStatus{
+			ResourceGenerationID: resourceVersionInt,
+			ReconcileStatus: status.ReconcileStatus{
+				Conditions: resourceStatusPayload.Conditions,
+			},
+		},
+	}
+
+	if resourceStatusPayload.Status != nil {
+		for _, value := range resourceStatusPayload.Status.StatusFeedbacks.Values {
+			if value.Name == "status" && value.Value.JsonRaw != nil {
+				contentStatus := status.ContentStatus{}
+				if err := json.Unmarshal([]byte(*value.Value.JsonRaw), &contentStatus); err != nil {
+					return nil, fmt.Errorf("failed to convert status feedback value to content status: %v", err)
+				}
+				r.status.ContentStatus = contentStatus
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// encodeWatchEvent returns the event maestro streams to the watchers for a status of the agent, maestro
+// encodes the status as a ManifestWork without uid and namespace, so the event carries no resourceid
+// nor clustername and its source is the consumer id
+func encodeWatchEvent(r *resource) (*cloudevents.Event, error) {
+	codec := agentcodec.NewManifestCodec(nil)
+	eventType := cetypes.CloudEventsType{
+		CloudEventsDataType: codec.EventDataType(),
+		SubResource:         cetypes.SubResourceStatus,
+		Action:              cetypes.EventAction(manifestevent.ActionUpdate),
+	}
+
+	work := &workv1.ManifestWork{
+		ObjectMeta: metav1.ObjectMeta{
+			ResourceVersion: strconv.FormatInt(r.generation, 10),
+			Annotations: map[string]string{
+				agentcodec.CloudEventsOriginalSourceAnnotationKey: manifestevent.DefaultSource,
+			},
+		},
+		Spec: workv1.ManifestWorkSpec{
+			Workload: workv1.ManifestsTemplate{
+				Manifests: []workv1.Manifest{{RawExtension: runtime.RawExtension{Object: r.object}}},
+			},
+		},
+		Status: workv1.ManifestWorkStatus{
+			Conditions: r.status.ReconcileStatus.Conditions,
+		},
+	}
+
+	evt, err := codec.Encode(r.consumerID, eventType, work)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode resource to cloud event: %v", err)
+	}
+	return evt, nil
+}
+
+// overMQTT returns a copy of the event as it is received over MQTT, where the extensions are strings,
+// e.g. the resourceversion is set as an integer and received as a string
+func overMQTT(evt *cloudevents.Event) (*cloudevents.Event, error) {
+	received := evt.Clone()
+	for name, value := range evt.Extensions() {
+		formatted, err := cloudeventstypes.Format(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to format %s extension: %v", name, err)
+		}
+		received.SetExtension(name, formatted)
+	}
+	return &received, nil
+}
@@ -0,0 +1,243 @@
+package fakemaestro
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	workpayload "open-cluster-management.io/api/cloudevents/work/payload"
+	workv1 "open-cluster-management.io/api/work/v1"
+
+	"github.com/morvencao/maestro-e2e/utils/manifestevent"
+	"github.com/morvencao/maestro-e2e/utils/restclient"
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+func newServer(t *testing.T) (*Server, context.Context) {
+	s, err := New()
+	require.NoError(t, err, "New()")
+	t.Cleanup(s.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return s, ctx
+}
+
+func dial(t *testing.T, ctx context.Context, s *Server) (maestropbv1.ConsumerServiceClient, maestropbv1.ResourceServiceClient, maestropbv1.CloudEventsServiceClient) {
+	conn, err := s.Dial(ctx)
+	require.NoError(t, err, "Dial()")
+	t.Cleanup(func() { conn.Close() })
+	return maestropbv1.NewConsumerServiceClient(conn), maestropbv1.NewResourceServiceClient(conn), maestropbv1.NewCloudEventsServiceClient(conn)
+}
+
+func newConfigMap(name, value string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"data":       map[string]interface{}{"key": value},
+	}}
+}
+
+// newStatusEvent returns the status event the agent publishes for the applied resource
+func newStatusEvent(t *testing.T, consumerID, resourceID string, generation int64, readyReplicas int64) *cloudevents.Event {
+	eventType := cetypes.CloudEventsType{
+		CloudEventsDataType: workpayload.ManifestEventDataType,
+		SubResource:         cetypes.SubResourceStatus,
+		Action:              cetypes.EventAction(manifestevent.ActionUpdate),
+	}
+	evt := cetypes.NewEventBuilder("work-agent", eventType).
+		WithResourceID(resourceID).
+		WithResourceVersion(generation).
+		WithClusterName(consumerID).
+		NewEvent()
+
+	raw := `{"readyReplicas":` + strconv.FormatInt(readyReplicas, 10) + `}`
+	payload := &workpayload.ManifestStatus{
+		Conditions: []metav1.Condition{{Type: workv1.WorkApplied, Status: metav1.ConditionTrue, Reason: "Applied"}},
+		Status: &workv1.ManifestCondition{
+			StatusFeedbacks: workv1.StatusFeedbackResult{
+				Values: []workv1.FeedbackValue{{
+					Name:  "status",
+					Value: workv1.FieldValue{Type: workv1.JsonRaw, JsonRaw: &raw},
+				}},
+			},
+		},
+	}
+	require.NoError(t, evt.SetData(cloudevents.ApplicationJSON, payload), "SetData()")
+	return &evt
+}
+
+func TestConsumers(t *testing.T) {
+	s, ctx := newServer(t)
+	consumers, _, _ := dial(t, ctx, s)
+
+	created, err := consumers.Create(ctx, &maestropbv1.ConsumerCreateRequest{Labels: []*maestropbv1.ConsumerLabel{{Key: "env", Value: "test"}}})
+	require.NoError(t, err, "Create()")
+	assert.NotEmpty(t, created.Id, "generated id")
+
+	read, err := consumers.Read(ctx, &maestropbv1.ConsumerReadRequest{Id: created.Id})
+	require.NoError(t, err, "Read()")
+	assert.True(t, proto.Equal(created, read), "read consumer")
+
+	_, err = consumers.Create(ctx, &maestropbv1.ConsumerCreateRequest{Id: created.Id})
+	assert.ErrorContains(t, err, "Consumer already exists, use method PUT to update")
+
+	_, err = consumers.Create(ctx, &maestropbv1.ConsumerCreateRequest{Id: "unknown"})
+	assert.ErrorContains(t, err, "Resource not found")
+
+	_, err = consumers.Update(ctx, &maestropbv1.ConsumerUpdateRequest{Id: "unknown"})
+	assert.ErrorContains(t, err, "Resource not found")
+
+	// the same store is served over REST
+	rest := restclient.New(s.URL(), http.DefaultClient)
+	got, err := rest.GetConsumer(ctx, created.Id)
+	require.NoError(t, err, "GetConsumer()")
+	assert.True(t, proto.Equal(created, got), "consumer read over REST")
+
+	_, err = rest.GetConsumer(ctx, "unknown")
+	require.Error(t, err, "GetConsumer() of an unknown consumer")
+	st, err := err.(*restclient.Error).Status()
+	require.NoError(t, err, "Status()")
+	assert.Equal(t, "Resource not found", st.Message)
+}
+
+func TestResources(t *testing.T) {
+	s, ctx := newServer(t)
+	_, resources, cloudEvents := dial(t, ctx, s)
+
+	specs := make(chan *cloudevents.Event, 10)
+	unsubscribe := s.SubscribeSpecs("cluster1", func(evt *cloudevents.Event) { specs <- evt })
+	defer unsubscribe()
+
+	object, err := structpb.NewStruct(newConfigMap("cm1", "v1").Object)
+	require.NoError(t, err)
+	created, err := resources.Create(ctx, &maestropbv1.ResourceCreateRequest{ConsumerId: "cluster1", Object: object})
+	require.NoError(t, err, "Create()")
+	assert.Equal(t, int64(1), created.GenerationId, "generation of a new resource")
+
+	evt := receive(t, specs)
+	assert.Equal(t, manifestevent.DefaultSource, evt.Source(), "source")
+	assert.Equal(t, created.Id, evt.Extensions()[cetypes.ExtensionResourceID], "resourceid")
+	manifest := &workpayload.Manifest{}
+	require.NoError(t, json.Unmarshal(evt.Data(), manifest))
+	assert.Equal(t, "cm1", manifest.Manifest.GetName(), "manifest")
+	assert.Equal(t, ".status", manifest.ConfigOption.FeedbackRules[0].JsonPaths[0].Path, "status feedback")
+
+	// the status is stored once a watcher receives it
+	require.NoError(t, s.PublishStatus(newStatusEvent(t, "cluster1", created.Id, 1, 1)), "PublishStatus()")
+	time.Sleep(100 * time.Millisecond)
+	read, err := resources.Read(ctx, &maestropbv1.ResourceReadRequest{Id: created.Id})
+	require.NoError(t, err, "Read()")
+	assert.Nil(t, read.Status.AsMap()["reconcileStatus"].(map[string]interface{})["conditions"], "no status is stored without a watcher")
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch, err := cloudEvents.Watch(watchCtx, &maestropbv1.ResourceWatchRequest{})
+	require.NoError(t, err, "Watch()")
+	_, err = watch.Recv()
+	require.NoError(t, err, "Recv()")
+
+	var resourceStatus *status.ResourceStatus
+	require.Eventually(t, func() bool {
+		read, err := resources.Read(ctx, &maestropbv1.ResourceReadRequest{Id: created.Id})
+		require.NoError(t, err, "Read()")
+		resourceStatus, err = status.FromResource(read)
+		require.NoError(t, err, "FromResource()")
+		return resourceStatus.HasCondition(workv1.WorkApplied, metav1.ConditionTrue)
+	}, 5*time.Second, 10*time.Millisecond, "applied condition")
+	readyReplicas, _ := resourceStatus.ContentStatus.Int64("readyReplicas")
+	assert.Equal(t, int64(1), readyReplicas, "content status")
+
+	object, err = structpb.NewStruct(newConfigMap("cm1", "v2").Object)
+	require.NoError(t, err)
+	updated, err := resources.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: created.Id, Object: object})
+	require.NoError(t, err, "Update()")
+	assert.Equal(t, int64(2), updated.GenerationId, "generation of an updated resource")
+	assert.Equal(t, "2", receive(t, specs).Extensions()[cetypes.ExtensionResourceVersion], "resourceversion")
+
+	read, err = resources.Read(ctx, &maestropbv1.ResourceReadRequest{Id: created.Id})
+	require.NoError(t, err, "Read()")
+	keptStatus, err := status.FromResource(read)
+	require.NoError(t, err, "FromResource()")
+	assert.Equal(t, resourceStatus, keptStatus, "status is kept on update")
+
+	_, err = resources.Read(ctx, &maestropbv1.ResourceReadRequest{Id: "unknown"})
+	assert.ErrorContains(t, err, "Resource not found")
+	_, err = resources.Update(ctx, &maestropbv1.ResourceUpdateRequest{Id: "unknown", Object: object})
+	assert.ErrorContains(t, err, "Resource not found")
+	assert.ErrorContains(t, s.PublishStatus(newStatusEvent(t, "cluster1", "unknown", 1, 1)), "Resource not found")
+}
+
+func TestCloudEvents(t *testing.T) {
+	s, ctx := newServer(t)
+	_, resources, cloudEvents := dial(t, ctx, s)
+
+	specs := make(chan *cloudevents.Event, 10)
+	defer s.SubscribeSpecs("cluster1", func(evt *cloudevents.Event) { specs <- evt })()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch, err := cloudEvents.Watch(watchCtx, &maestropbv1.ResourceWatchRequest{})
+	require.NoError(t, err, "Watch()")
+
+	builder := manifestevent.NewBuilder("cluster1")
+	pbEvt, err := builder.BuildProto(manifestevent.ActionCreate, newConfigMap("cm1", "v1"))
+	require.NoError(t, err, "BuildProto()")
+	resp, err := cloudEvents.Send(ctx, pbEvt)
+	require.NoError(t, err, "Send()")
+	assert.Equal(t, "Manifest posted successfully.", resp.Message)
+
+	evt := receive(t, specs)
+	assert.Equal(t, builder.ResourceID(), evt.Extensions()[cetypes.ExtensionResourceID], "resourceid")
+
+	require.NoError(t, s.PublishStatus(newStatusEvent(t, "cluster1", builder.ResourceID(), 1, 1)), "PublishStatus()")
+	watched, err := watch.Recv()
+	require.NoError(t, err, "Recv()")
+	watchedEvt, err := cepbv2.FromProto(watched)
+	require.NoError(t, err, "FromProto()")
+	assert.Equal(t, "cluster1", watchedEvt.Source(), "the source of a watched status is the consumer")
+	require.Eventually(t, func() bool {
+		read, err := resources.Read(ctx, &maestropbv1.ResourceReadRequest{Id: builder.ResourceID()})
+		require.NoError(t, err, "Read()")
+		return read.Status.AsMap()["reconcileStatus"].(map[string]interface{})["conditions"] != nil
+	}, 5*time.Second, 10*time.Millisecond, "the watched status is stored")
+
+	// a spec request posted over REST resets the status
+	data, err := builder.BuildJSON(manifestevent.ActionUpdate, newConfigMap("cm1", "v2"))
+	require.NoError(t, err, "BuildJSON()")
+	_, err = restclient.New(s.URL(), http.DefaultClient).DoRaw(ctx, http.MethodPost, "/v1/cloudevents", restclient.ContentTypeCloudEvents, data)
+	require.NoError(t, err, "post cloudevent")
+	assert.Equal(t, "2", receive(t, specs).Extensions()[cetypes.ExtensionResourceVersion], "resourceversion")
+
+	read, err := resources.Read(ctx, &maestropbv1.ResourceReadRequest{Id: builder.ResourceID()})
+	require.NoError(t, err, "Read()")
+	assert.Equal(t, int64(2), read.GenerationId, "generation")
+	assert.Nil(t, read.Status.AsMap()["reconcileStatus"].(map[string]interface{})["conditions"], "status is reset")
+
+	_, err = restclient.New(s.URL(), http.DefaultClient).DoRaw(ctx, http.MethodPost, "/v1/cloudevents", restclient.ContentTypeCloudEvents, []byte(`{"specversion":"1.0","id":"1","source":"test","type":"unknown"}`))
+	assert.Error(t, err, "unsupported event type")
+}
+
+func receive(t *testing.T, specs <-chan *cloudevents.Event) *cloudevents.Event {
+	select {
+	case evt := <-specs:
+		return evt
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no spec event received")
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+package fakemaestro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// contentTypeCloudEvents is the content type of the structured cloudevents posted to /v1/cloudevents
+const contentTypeCloudEvents = "application/x-cloudevents"
+
+// cloudEventJSON is the gateway marshaler maestro registers for the cloudevents content type, it decodes
+// the structured JSON cloudevents into protobuf cloudevents and only encodes the send responses, any
+// other message, the errors included, fails to encode
+type cloudEventJSON struct{}
+
+var _ runtime.Marshaler = &cloudEventJSON{}
+
+func (c *cloudEventJSON) Marshal(v interface{}) ([]byte, error) {
+	resp, ok := v.(*maestropbv1.CloudEventSendResponse)
+	if !ok {
+		return nil, fmt.Errorf("v must be a CloudEventSendResponse")
+	}
+	return protojson.Marshal(resp)
+}
+
+func (c *cloudEventJSON) Unmarshal(data []byte, v interface{}) error {
+	evt := &event.Event{}
+	if err := json.Unmarshal(data, evt); err != nil {
+		return err
+	}
+	return setProto(evt, v)
+}
+
+func (c *cloudEventJSON) NewDecoder(r io.Reader) runtime.Decoder {
+	decoder := json.NewDecoder(r)
+	return runtime.DecoderFunc(func(v interface{}) error {
+		evt := &event.Event{}
+		if err := decoder.Decode(evt); err != nil {
+			return err
+		}
+		return setProto(evt, v)
+	})
+}
+
+func (c *cloudEventJSON) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		data, err := c.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+func (c *cloudEventJSON) ContentType(v interface{}) string {
+	return contentTypeCloudEvents
+}
+
+// setProto sets the protobuf cloudevent pointed to by v to the event
+func setProto(evt *event.Event, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("v must be a non-nil pointer")
+	}
+
+	pbEvt, err := cepbv2.ToProto(evt)
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(reflect.ValueOf(pbEvt).Elem())
+	return nil
+}
@@ -0,0 +1,241 @@
+// Package fakemaestro is an in-process fake of the maestro gRPC and REST APIs over an in-memory store,
+// it serves the consumer, resource and cloudevents services over a bufconn listener and the REST gateway
+// over an httptest server, and lets the tests stand in for the work agent of the consumers.
+package fakemaestro
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	bufSize = 1024 * 1024
+	// queueSize is the number of the spec and status events buffered for the subscribers and the receiver
+	queueSize = 1024
+)
+
+// SpecHandler handles a spec event maestro publishes to the agent of a consumer
+type SpecHandler func(evt *cloudevents.Event)
+
+// Server is an in-process fake maestro, it is ready once New returns
+type Server struct {
+	store    *store
+	specs    chan *resource
+	received chan *resource
+	statuses chan *resource
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	listener    *bufconn.Listener
+	grpcServer  *grpc.Server
+	gatewayConn *grpc.ClientConn
+	gateway     *httptest.Server
+
+	mu          sync.RWMutex
+	subscribers map[string]map[int]SpecHandler
+	nextID      int
+	closeOnce   sync.Once
+}
+
+// New starts a fake maestro with an empty store
+func New() (*Server, error) {
+	s := &Server{
+		store:       newStore(),
+		specs:       make(chan *resource, queueSize),
+		received:    make(chan *resource, queueSize),
+		statuses:    make(chan *resource),
+		done:        make(chan struct{}),
+		listener:    bufconn.Listen(bufSize),
+		grpcServer:  grpc.NewServer(),
+		subscribers: map[string]map[int]SpecHandler{},
+	}
+
+	maestropbv1.RegisterConsumerServiceServer(s.grpcServer, &consumerService{store: s.store})
+	maestropbv1.RegisterResourceServiceServer(s.grpcServer, &resourceService{store: s.store, publish: s.publish})
+	maestropbv1.RegisterCloudEventsServiceServer(s.grpcServer, &cloudEventsService{store: s.store, publish: s.publish, statuses: s.statuses})
+
+	s.wg.Add(3)
+	go func() {
+		defer s.wg.Done()
+		// Serve returns once the server is stopped
+		_ = s.grpcServer.Serve(s.listener)
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.dispatch()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.receiveStatuses()
+	}()
+
+	ctx := context.Background()
+	conn, err := s.Dial(ctx)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.gatewayConn = conn
+
+	mux := runtime.NewServeMux(runtime.WithMarshalerOption(contentTypeCloudEvents, &cloudEventJSON{}))
+	if err := maestropbv1.RegisterConsumerServiceHandler(ctx, mux, conn); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := maestropbv1.RegisterResourceServiceHandler(ctx, mux, conn); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := maestropbv1.RegisterCloudEventsServiceHandler(ctx, mux, conn); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.gateway = httptest.NewServer(mux)
+
+	return s, nil
+}
+
+// Dial returns a gRPC client connection to the fake
+func (s *Server) Dial(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)
+	return grpc.DialContext(ctx, "bufnet", opts...)
+}
+
+// URL returns the base URL of the REST gateway, e.g. http://127.0.0.1:41234
+func (s *Server) URL() string {
+	return s.gateway.URL
+}
+
+// SubscribeSpecs calls the handler with the spec events of the resources of the consumer until the
+// returned func is called, the handlers are called one at a time in the order the resources are stored
+// and the events are received as the agent receives them over MQTT
+func (s *Server) SubscribeSpecs(consumerID string, handler SpecHandler) (unsubscribe func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	if s.subscribers[consumerID] == nil {
+		s.subscribers[consumerID] = map[int]SpecHandler{}
+	}
+	s.subscribers[consumerID][id] = handler
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers[consumerID], id)
+	}
+}
+
+// PublishStatus queues the status of a status event of the agent for the status receiver, which hands
+// it to one of the watchers and only then stores it, as maestro does, so the statuses wait for a watcher
+// and none is stored while no watcher is open. The status of an unknown resource is rejected here, where
+// maestro logs the error once a watcher received it
+func (s *Server) PublishStatus(evt *cloudevents.Event) error {
+	received, err := overMQTT(evt)
+	if err != nil {
+		return err
+	}
+
+	res, err := decodeStatus(received)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.store.getResource(res.id); err != nil {
+		return err
+	}
+
+	select {
+	case s.received <- res:
+	case <-s.done:
+	}
+	return nil
+}
+
+// Close stops the gateway and the gRPC server, the client connections are closed by their owners
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		if s.gateway != nil {
+			s.gateway.Close()
+		}
+		if s.gatewayConn != nil {
+			s.gatewayConn.Close()
+		}
+		close(s.done)
+		s.grpcServer.Stop()
+		s.wg.Wait()
+	})
+}
+
+// publish queues the spec event of the resource for the subscribers of its consumer
+func (s *Server) publish(res *resource) {
+	select {
+	case s.specs <- res.deepCopy():
+	case <-s.done:
+	}
+}
+
+// receiveStatuses hands each received status to one of the watchers and then stores it, it blocks until
+// a watcher receives the status, as the status receiver of maestro does
+func (s *Server) receiveStatuses() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case res := <-s.received:
+			select {
+			case s.statuses <- res:
+			case <-s.done:
+				return
+			}
+
+			// the resource was checked when the status was published, resources are never removed
+			_ = s.store.setStatus(res.id, res.status)
+		}
+	}
+}
+
+func (s *Server) dispatch() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case res := <-s.specs:
+			evt, err := encodeSpec(res)
+			if err == nil {
+				evt, err = overMQTT(evt)
+			}
+			if err != nil {
+				// the object was decoded from JSON, it is always encoded back
+				continue
+			}
+
+			s.mu.RLock()
+			handlers := make([]SpecHandler, 0, len(s.subscribers[res.consumerID]))
+			for _, handler := range s.subscribers[res.consumerID] {
+				handlers = append(handlers, handler)
+			}
+			s.mu.RUnlock()
+
+			for _, handler := range handlers {
+				c := evt.Clone()
+				handler(&c)
+			}
+		}
+	}
+}
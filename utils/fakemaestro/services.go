@@ -0,0 +1,217 @@
+package fakemaestro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cepb "github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	cloudeventstypes "github.com/cloudevents/sdk-go/v2/types"
+	"github.com/google/uuid"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	workpayload "open-cluster-management.io/api/cloudevents/work/payload"
+)
+
+// the services mirror the ones of maestro, including the errors and their messages
+
+// ConsumerExistsError is returned when a consumer is created with the id of a stored consumer
+type ConsumerExistsError struct{}
+
+func (e *ConsumerExistsError) Error() string {
+	return "Consumer already exists, use method PUT to update"
+}
+
+type consumerService struct {
+	maestropbv1.UnimplementedConsumerServiceServer
+	store *store
+}
+
+func (svc *consumerService) Read(_ context.Context, r *maestropbv1.ConsumerReadRequest) (*maestropbv1.Consumer, error) {
+	return svc.store.getConsumer(r.Id)
+}
+
+// Create always generates the id of the consumer, maestro looks the requested id up first
+// so an unknown id fails with a not found error
+func (svc *consumerService) Create(_ context.Context, r *maestropbv1.ConsumerCreateRequest) (*maestropbv1.Consumer, error) {
+	if r.Id != "" {
+		if _, err := svc.store.getConsumer(r.Id); err != nil {
+			return nil, err
+		}
+		return nil, &ConsumerExistsError{}
+	}
+
+	consumer := &maestropbv1.Consumer{
+		Id:     uuid.NewString(),
+		Labels: r.Labels,
+	}
+	svc.store.putConsumer(consumer)
+	return consumer, nil
+}
+
+func (svc *consumerService) Update(_ context.Context, r *maestropbv1.ConsumerUpdateRequest) (*maestropbv1.Consumer, error) {
+	if _, err := svc.store.getConsumer(r.Id); err != nil {
+		return nil, err
+	}
+
+	consumer := &maestropbv1.Consumer{
+		Id:     r.Id,
+		Labels: r.Labels,
+	}
+	svc.store.putConsumer(consumer)
+	return consumer, nil
+}
+
+type resourceService struct {
+	maestropbv1.UnimplementedResourceServiceServer
+	store   *store
+	publish func(*resource)
+}
+
+func (svc *resourceService) Read(_ context.Context, r *maestropbv1.ResourceReadRequest) (*maestropbv1.Resource, error) {
+	res, err := svc.store.getResource(r.Id)
+	if err != nil {
+		return nil, err
+	}
+	return res.toProto()
+}
+
+// Create does not check the consumer, the resource of an unknown consumer is stored and never applied
+func (svc *resourceService) Create(_ context.Context, r *maestropbv1.ResourceCreateRequest) (*maestropbv1.Resource, error) {
+	res := &resource{
+		id:         uuid.NewString(),
+		consumerID: r.ConsumerId,
+		generation: 1,
+		object:     &unstructured.Unstructured{Object: r.Object.AsMap()},
+	}
+	svc.store.putResource(res)
+	svc.publish(res)
+
+	return &maestropbv1.Resource{
+		Id:           res.id,
+		ConsumerId:   res.consumerID,
+		GenerationId: res.generation,
+		Object:       r.Object,
+	}, nil
+}
+
+// Update bumps the generation of the stored resource and keeps its status
+func (svc *resourceService) Update(_ context.Context, r *maestropbv1.ResourceUpdateRequest) (*maestropbv1.Resource, error) {
+	res, err := svc.store.getResource(r.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	res.object = &unstructured.Unstructured{Object: r.Object.AsMap()}
+	res.generation++
+	svc.store.putResource(res)
+	svc.publish(res)
+
+	return &maestropbv1.Resource{
+		Id:           res.id,
+		ConsumerId:   res.consumerID,
+		GenerationId: res.generation,
+		Object:       r.Object,
+	}, nil
+}
+
+type cloudEventsService struct {
+	maestropbv1.UnimplementedCloudEventsServiceServer
+	store    *store
+	publish  func(*resource)
+	statuses <-chan *resource
+}
+
+// Send stores the manifest with the resource version of the event as the generation, the last
+// event wins whatever its version, and the status of the resource is reset
+func (svc *cloudEventsService) Send(_ context.Context, r *cepb.CloudEvent) (*maestropbv1.CloudEventSendResponse, error) {
+	evt, err := cepbv2.FromProto(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert protobuf to cloudevent: %v", err)
+	}
+
+	eventType, err := cetypes.ParseCloudEventsType(evt.Type())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cloud event type %s, %v", evt.Type(), err)
+	}
+
+	if eventType.CloudEventsDataType != workpayload.ManifestEventDataType {
+		return nil, fmt.Errorf("unsupported cloudevents data type %s", eventType.CloudEventsDataType)
+	}
+
+	evtExtensions := evt.Context.GetExtensions()
+
+	resourceID, err := cloudeventstypes.ToString(evtExtensions[cetypes.ExtensionResourceID])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resourceid extension: %v", err)
+	}
+
+	resourceVersion, err := cloudeventstypes.ToString(evtExtensions[cetypes.ExtensionResourceVersion])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resourceversion extension: %v", err)
+	}
+
+	resourceVersionInt, err := strconv.ParseInt(resourceVersion, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert resourceversion - %v to int64", resourceVersion)
+	}
+
+	clusterName, err := cloudeventstypes.ToString(evtExtensions[cetypes.ExtensionClusterName])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clustername extension: %v", err)
+	}
+
+	var object unstructured.Unstructured
+	switch evt.Context.GetDataContentType() {
+	case "application/json", "":
+		manifest := &workpayload.Manifest{}
+		if err := json.Unmarshal(evt.Data(), manifest); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event data as resource: %v", err)
+		}
+		object = manifest.Manifest
+	case "application/protobuf":
+		return nil, fmt.Errorf("protobuf data content type not supported")
+	default:
+		return nil, fmt.Errorf("unsupported data content type %s", evt.Context.GetDataContentType())
+	}
+
+	res := &resource{
+		id:         resourceID,
+		consumerID: clusterName,
+		generation: resourceVersionInt,
+		object:     &object,
+	}
+	svc.store.putResource(res)
+	svc.publish(res)
+
+	return &maestropbv1.CloudEventSendResponse{
+		Message: "Manifest posted successfully.",
+		Status:  maestropbv1.CloudEventSendResponse_OK,
+	}, nil
+}
+
+// Watch streams the status events to the watcher until the stream or the server is closed, the
+// request is ignored and each status event is handed to one of the open watchers, as maestro does
+func (svc *cloudEventsService) Watch(_ *maestropbv1.ResourceWatchRequest, srv maestropbv1.CloudEventsService_WatchServer) error {
+	for {
+		select {
+		case <-srv.Context().Done():
+			return srv.Context().Err()
+		case res := <-svc.statuses:
+			evt, err := encodeWatchEvent(res)
+			if err != nil {
+				return err
+			}
+			pbEvt, err := cepbv2.ToProto(evt)
+			if err != nil {
+				return fmt.Errorf("failed to convert cloudevent to protobuf: %v", err)
+			}
+			if err := srv.Send(pbEvt); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package fakemaestro
+
+import (
+	"encoding/json"
+	"sync"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/morvencao/maestro-e2e/utils/status"
+)
+
+// ErrNotFound is returned for the consumers and resources that are not stored, it has the message of
+// the maestro database error so the clients see the same error
+type ErrNotFound struct{}
+
+func (e *ErrNotFound) Error() string {
+	return "Resource not found"
+}
+
+// resource is a stored resource, the status is the zero status until the agent reports one
+type resource struct {
+	id         string
+	consumerID string
+	generation int64
+	object     *unstructured.Unstructured
+	status     status.ResourceStatus
+}
+
+// toProto returns the resource as maestro reads it from the database
+func (r *resource) toProto() (*maestropbv1.Resource, error) {
+	object, err := structpb.NewStruct(r.object.UnstructuredContent())
+	if err != nil {
+		return nil, err
+	}
+
+	statusJSON, err := json.Marshal(&r.status)
+	if err != nil {
+		return nil, err
+	}
+	var statusMap map[string]interface{}
+	if err := json.Unmarshal(statusJSON, &statusMap); err != nil {
+		return nil, err
+	}
+	statusStruct, err := structpb.NewStruct(statusMap)
+	if err != nil {
+		return nil, err
+	}
+
+	return &maestropbv1.Resource{
+		Id:           r.id,
+		ConsumerId:   r.consumerID,
+		GenerationId: r.generation,
+		Object:       object,
+		Status:       statusStruct,
+	}, nil
+}
+
+// store is the in-memory stand-in of the maestro tables, the items are copied in and out
+type store struct {
+	mu        sync.RWMutex
+	consumers map[string]*maestropbv1.Consumer
+	resources map[string]*resource
+}
+
+func newStore() *store {
+	return &store{
+		consumers: map[string]*maestropbv1.Consumer{},
+		resources: map[string]*resource{},
+	}
+}
+
+func (s *store) getConsumer(id string) (*maestropbv1.Consumer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.consumers[id]
+	if !ok {
+		return nil, &ErrNotFound{}
+	}
+	return proto.Clone(c).(*maestropbv1.Consumer), nil
+}
+
+func (s *store) putConsumer(c *maestropbv1.Consumer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consumers[c.Id] = proto.Clone(c).(*maestropbv1.Consumer)
+}
+
+func (s *store) getResource(id string) (*resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.resources[id]
+	if !ok {
+		return nil, &ErrNotFound{}
+	}
+	return r.deepCopy(), nil
+}
+
+// putResource replaces the whole item, the status included, as the PutItem of maestro does
+func (s *store) putResource(r *resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[r.id] = r.deepCopy()
+}
+
+// setStatus sets the status of a stored resource
+func (s *store) setStatus(id string, resourceStatus status.ResourceStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.resources[id]
+	if !ok {
+		return &ErrNotFound{}
+	}
+	r.status = resourceStatus
+	return nil
+}
+
+func (r *resource) deepCopy() *resource {
+	c := *r
+	c.object = r.object.DeepCopy()
+	c.status.ReconcileStatus.Conditions = append(r.status.ReconcileStatus.Conditions[:0:0], r.status.ReconcileStatus.Conditions...)
+	if r.status.ContentStatus != nil {
+		c.status.ContentStatus = runtime.DeepCopyJSON(r.status.ContentStatus)
+	}
+	return &c
+}
@@ -0,0 +1,181 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Options configures the interceptors of the harness connection
+type Options struct {
+	// Timeout is the deadline of each unary attempt, it is not applied when the caller's deadline is shorter
+	Timeout time.Duration
+	// MaxRetries is the number of times a call of an idempotent method failed with Unavailable is retried
+	MaxRetries int
+	// Backoff is the wait before the first retry, it doubles on each retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultOptions returns the options used by the e2e harness
+func DefaultOptions() Options {
+	return Options{
+		Timeout:    time.Second * 30,
+		MaxRetries: 5,
+		Backoff:    time.Millisecond * 500,
+		MaxBackoff: time.Second * 8,
+	}
+}
+
+// Logger is the logger the calls are reported to, *testing.T satisfies it
+type Logger interface {
+	Logf(format string, args ...interface{})
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose calls are logged to the logger
+func WithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func logf(ctx context.Context, format string, args ...interface{}) {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok && logger != nil {
+		logger.Logf(format, args...)
+	}
+}
+
+// idempotentPrefixes are the prefixes of the names of the methods that only read, e.g. Read and Watch
+// of the maestro services, or Check of the health service
+var idempotentPrefixes = []string{"Read", "Get", "List", "Watch", "Check"}
+
+// Idempotent reports whether the full method name, e.g. /api.v1.ResourceService/Read, is safe to retry.
+// Create, Update and Send may have been applied by maestro when they fail with Unavailable, so they are
+// not retried, gRPC still transparently retries them when they never left the client
+func Idempotent(method string) bool {
+	name := method[strings.LastIndex(method, "/")+1:]
+	for _, prefix := range idempotentPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialOptions returns the dial options that install the interceptors
+func DialOptions(opts Options) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(opts)),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor(opts)),
+	}
+}
+
+// UnaryClientInterceptor bounds each attempt with the timeout, retries the calls of the idempotent
+// methods failed with Unavailable and logs the method, latency and status code of every attempt
+func UnaryClientInterceptor(opts Options) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		var err error
+		for attempt := 0; ; attempt++ {
+			start := time.Now()
+			err = invokeWithTimeout(ctx, opts.Timeout, method, req, reply, cc, invoker, callOpts...)
+			code := status.Code(err)
+			logf(ctx, "grpc %s attempt=%d latency=%s code=%s", method, attempt+1, time.Since(start).Round(time.Millisecond), code)
+
+			if code != codes.Unavailable || attempt >= opts.MaxRetries || !Idempotent(method) {
+				return err
+			}
+
+			if waitErr := sleep(ctx, backoff(opts, attempt)); waitErr != nil {
+				return err
+			}
+		}
+	}
+}
+
+func invokeWithTimeout(ctx context.Context, timeout time.Duration, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+	if timeout > 0 {
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > timeout {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, callOpts...)
+}
+
+// StreamClientInterceptor retries opening the streams of the idempotent methods failed with Unavailable and logs the
+// method, duration and status code of the stream when it ends, the streams are long lived,
+// so they are not bounded by the timeout
+func StreamClientInterceptor(opts Options) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		for attempt := 0; ; attempt++ {
+			start := time.Now()
+			stream, err := streamer(ctx, desc, cc, method, callOpts...)
+			if err == nil {
+				logf(ctx, "grpc %s stream opened attempt=%d latency=%s", method, attempt+1, time.Since(start).Round(time.Millisecond))
+				return &loggedStream{ClientStream: stream, ctx: ctx, method: method, start: start}, nil
+			}
+
+			code := status.Code(err)
+			logf(ctx, "grpc %s stream attempt=%d latency=%s code=%s", method, attempt+1, time.Since(start).Round(time.Millisecond), code)
+			if code != codes.Unavailable || attempt >= opts.MaxRetries || !Idempotent(method) {
+				return nil, err
+			}
+
+			if waitErr := sleep(ctx, backoff(opts, attempt)); waitErr != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// loggedStream logs the status code of the stream once it ends
+type loggedStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	method string
+	start  time.Time
+	done   bool
+}
+
+func (s *loggedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && !s.done {
+		s.done = true
+		code := codes.OK
+		if !errors.Is(err, io.EOF) {
+			code = status.Code(err)
+		}
+		logf(s.ctx, "grpc %s stream closed duration=%s code=%s", s.method, time.Since(s.start).Round(time.Millisecond), code)
+	}
+	return err
+}
+
+func backoff(opts Options, attempt int) time.Duration {
+	d := opts.Backoff
+	for i := 0; i < attempt; i++ {
+		d *= 2
+		if opts.MaxBackoff > 0 && d >= opts.MaxBackoff {
+			return opts.MaxBackoff
+		}
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
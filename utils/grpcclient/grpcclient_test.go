@@ -0,0 +1,182 @@
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Logf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+// newTestClient serves the health service behind the server interceptor and dials it with the client interceptors
+func newTestClient(t *testing.T, opts Options, interceptor grpc.UnaryServerInterceptor) healthpb.HealthClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(interceptor))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	dialOpts := append(DialOptions(opts),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	conn, err := grpc.Dial("bufnet", dialOpts...)
+	require.NoError(t, err, "Dial()")
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryRetry(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, Options{Timeout: time.Second, MaxRetries: 3, Backoff: time.Millisecond},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls++
+			if calls < 3 {
+				return nil, status.Error(codes.Unavailable, "maestro is restarting")
+			}
+			return handler(ctx, req)
+		})
+
+	logger := &recordingLogger{}
+	ctx := WithLogger(context.Background(), logger)
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err, "Check()")
+	assert.Equal(t, 3, calls, "calls")
+
+	lines := logger.Lines()
+	require.Len(t, lines, 3, "log lines")
+	assert.Contains(t, lines[0], "/grpc.health.v1.Health/Check attempt=1", "first attempt")
+	assert.Contains(t, lines[0], "code=Unavailable", "first attempt code")
+	assert.Contains(t, lines[2], "code=OK", "last attempt code")
+}
+
+func TestUnaryRetryExhausted(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, Options{Timeout: time.Second, MaxRetries: 2, Backoff: time.Millisecond},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls++
+			return nil, status.Error(codes.Unavailable, "maestro is down")
+		})
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err), "code")
+	assert.Equal(t, 3, calls, "calls")
+}
+
+func TestUnaryNoRetry(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, Options{Timeout: time.Second, MaxRetries: 2, Backoff: time.Millisecond},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls++
+			return nil, status.Error(codes.InvalidArgument, "bad request")
+		})
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "code")
+	assert.Equal(t, 1, calls, "calls")
+}
+
+func TestUnaryNoRetryOfNonIdempotentMethod(t *testing.T) {
+	calls := 0
+	interceptor := UnaryClientInterceptor(Options{MaxRetries: 2, Backoff: time.Millisecond})
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.Unavailable, "maestro is restarting")
+	}
+
+	for _, method := range []string{"/api.v1.ResourceService/Create", "/api.v1.ResourceService/Update", "/api.v1.CloudEventsService/Send"} {
+		calls = 0
+		err := interceptor(context.Background(), method, nil, nil, nil, invoker)
+		assert.Equal(t, codes.Unavailable, status.Code(err), "%s code", method)
+		assert.Equal(t, 1, calls, "%s calls", method)
+	}
+
+	calls = 0
+	err := interceptor(context.Background(), "/api.v1.ResourceService/Read", nil, nil, nil, invoker)
+	assert.Equal(t, codes.Unavailable, status.Code(err), "Read code")
+	assert.Equal(t, 3, calls, "Read calls")
+}
+
+func TestIdempotent(t *testing.T) {
+	assert.True(t, Idempotent("/api.v1.ResourceService/Read"), "Read")
+	assert.True(t, Idempotent("/api.v1.CloudEventsService/Watch"), "Watch")
+	assert.False(t, Idempotent("/api.v1.ConsumerService/Create"), "Create")
+	assert.False(t, Idempotent("/api.v1.CloudEventsService/Send"), "Send")
+}
+
+func TestUnaryTimeout(t *testing.T) {
+	client := newTestClient(t, Options{Timeout: time.Millisecond * 100},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+	start := time.Now()
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "code")
+	assert.Less(t, time.Since(start), time.Second*5, "latency")
+}
+
+func TestStreamLogging(t *testing.T) {
+	client := newTestClient(t, Options{},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return handler(ctx, req)
+		})
+
+	logger := &recordingLogger{}
+	ctx, cancel := context.WithCancel(WithLogger(context.Background(), logger))
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err, "Watch()")
+
+	_, err = stream.Recv()
+	require.NoError(t, err, "Recv()")
+
+	cancel()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err), "code")
+
+	lines := strings.Join(logger.Lines(), "\n")
+	assert.Contains(t, lines, "/grpc.health.v1.Health/Watch stream opened", "opened")
+	assert.Contains(t, lines, "stream closed", "closed")
+	assert.Contains(t, lines, "code=Canceled", "closed code")
+}
+
+func TestBackoff(t *testing.T) {
+	opts := Options{Backoff: time.Second, MaxBackoff: time.Second * 5}
+	assert.Equal(t, time.Second, backoff(opts, 0), "first retry")
+	assert.Equal(t, time.Second*4, backoff(opts, 2), "third retry")
+	assert.Equal(t, time.Second*5, backoff(opts, 5), "capped")
+}
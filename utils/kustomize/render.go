@@ -2,7 +2,10 @@ package kustomize
 
 import (
 	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/filters/imagetag"
+	"sigs.k8s.io/kustomize/api/image"
 	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/yaml"
 )
 
@@ -10,6 +13,9 @@ import (
 type Options struct {
 	KustomizationPath string
 	OutputPath        string
+	// Images overrides the container images, keyed by the image name in the manifests,
+	// the values are full image references, e.g. quay.io/morvencao/maestro-api:v0.1.0
+	Images map[string]string
 }
 
 // Render is used to render the kustomization
@@ -20,6 +26,16 @@ func Render(o Options) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	for name, ref := range o.Images {
+		newName, newTag, digest := image.Split(ref)
+		filter := imagetag.LegacyFilter{
+			ImageTag: types.Image{Name: name, NewName: newName, NewTag: newTag, Digest: digest},
+		}
+		if err := m.ApplyFilter(filter); err != nil {
+			return nil, err
+		}
+	}
 	return m.AsYaml()
 }
 
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func TestRender(t *testing.T) {
@@ -33,6 +34,29 @@ func TestRender(t *testing.T) {
 
 }
 
+func TestRenderImages(t *testing.T) {
+	buf, err := Render(Options{
+		KustomizationPath: "tests/images",
+		Images: map[string]string{
+			"quay.io/foo/foo": "quay.io/foo/foo:v1.0.0",
+			"quay.io/foo/bar": "registry.local/bar@sha256:0123456789abcdef",
+			"quay.io/foo/baz": "quay.io/foo/baz:v1.0.0",
+		},
+	})
+	require.NoError(t, err, "Render()")
+
+	objs, err := ToObjects(buf)
+	require.NoError(t, err, "ToObjects()")
+	require.Len(t, objs, 1)
+
+	containers, _, _ := unstructured.NestedSlice(objs[0].Object, "spec", "template", "spec", "containers")
+	var images []string
+	for _, c := range containers {
+		images = append(images, c.(map[string]interface{})["image"].(string))
+	}
+	assert.Equal(t, []string{"quay.io/foo/foo:v1.0.0", "registry.local/bar@sha256:0123456789abcdef"}, images, "overridden images")
+}
+
 func containedNames(rendered []map[string]interface{}) (names []string) {
 	for _, o := range rendered {
 		m := o["metadata"]
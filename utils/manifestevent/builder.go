@@ -0,0 +1,155 @@
+package manifestevent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cepb "github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	workpayload "open-cluster-management.io/api/cloudevents/work/payload"
+	workv1 "open-cluster-management.io/api/work/v1"
+)
+
+// DefaultSource is the source of the spec requests sent through maestro
+const DefaultSource = "maestro"
+
+// Action is the action of a spec request
+type Action string
+
+const (
+	ActionCreate Action = "create_request"
+	ActionUpdate Action = "update_request"
+	ActionDelete Action = "delete_request"
+)
+
+// Builder builds the spec request events of one resource, each event carries the next resource version
+type Builder struct {
+	source          string
+	clusterName     string
+	resourceID      string
+	resourceVersion int64
+}
+
+// NewBuilder returns a builder of the spec requests of a new resource for the consumer
+func NewBuilder(consumerID string) *Builder {
+	return &Builder{
+		source:      DefaultSource,
+		clusterName: consumerID,
+		resourceID:  uuid.NewString(),
+	}
+}
+
+// WithSource sets the source of the events
+func (b *Builder) WithSource(source string) *Builder {
+	b.source = source
+	return b
+}
+
+// WithResourceID sets the id of the resource the events are sent for
+func (b *Builder) WithResourceID(resourceID string) *Builder {
+	b.resourceID = resourceID
+	return b
+}
+
+// WithResourceVersion sets the resource version of the last event, the next event carries version+1
+func (b *Builder) WithResourceVersion(version int64) *Builder {
+	b.resourceVersion = version
+	return b
+}
+
+// ResourceID returns the id of the resource the events are sent for
+func (b *Builder) ResourceID() string {
+	return b.resourceID
+}
+
+// ResourceVersion returns the resource version of the last event
+func (b *Builder) ResourceVersion() int64 {
+	return b.resourceVersion
+}
+
+// Build returns the spec request for the objects, a single object is sent as a manifest
+// and several objects are sent as a manifest bundle
+func (b *Builder) Build(action Action, objs ...*unstructured.Unstructured) (*cloudevents.Event, error) {
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("at least one object is required")
+	}
+
+	// maestro stores the manifest as is and only deletes the objects whose deletionTimestamp is set
+	var deletionTimestamp time.Time
+	if action == ActionDelete {
+		deletionTimestamp = time.Now()
+		deleted := make([]*unstructured.Unstructured, 0, len(objs))
+		for _, obj := range objs {
+			obj = obj.DeepCopy()
+			obj.SetDeletionTimestamp(&metav1.Time{Time: deletionTimestamp})
+			deleted = append(deleted, obj)
+		}
+		objs = deleted
+	}
+
+	dataType := workpayload.ManifestEventDataType
+	var data interface{}
+	if len(objs) == 1 {
+		data = &workpayload.Manifest{Manifest: *objs[0]}
+	} else {
+		bundle := &workpayload.ManifestBundle{}
+		for _, obj := range objs {
+			raw, err := obj.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			bundle.Manifests = append(bundle.Manifests, workv1.Manifest{RawExtension: runtime.RawExtension{Raw: raw}})
+		}
+		dataType = workpayload.ManifestBundleEventDataType
+		data = bundle
+	}
+
+	eventType := cetypes.CloudEventsType{
+		CloudEventsDataType: dataType,
+		SubResource:         cetypes.SubResourceSpec,
+		Action:              cetypes.EventAction(action),
+	}
+	builder := cetypes.NewEventBuilder(b.source, eventType).
+		WithResourceID(b.resourceID).
+		WithClusterName(b.clusterName)
+	if action == ActionDelete {
+		builder = builder.WithDeletionTimestamp(deletionTimestamp)
+	}
+	evt := builder.NewEvent()
+
+	// maestro reads the resource version as a string
+	evt.SetExtension(cetypes.ExtensionResourceVersion, strconv.FormatInt(b.resourceVersion+1, 10))
+
+	if err := evt.SetData(cloudevents.ApplicationJSON, data); err != nil {
+		return nil, fmt.Errorf("failed to set event data: %v", err)
+	}
+
+	b.resourceVersion++
+	return &evt, nil
+}
+
+// BuildJSON returns the spec request in the structured JSON format posted to /v1/cloudevents
+func (b *Builder) BuildJSON(action Action, objs ...*unstructured.Unstructured) ([]byte, error) {
+	evt, err := b.Build(action, objs...)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(evt)
+}
+
+// BuildProto returns the spec request in the protobuf format sent with CloudEventsService.Send
+func (b *Builder) BuildProto(action Action, objs ...*unstructured.Unstructured) (*cepb.CloudEvent, error) {
+	evt, err := b.Build(action, objs...)
+	if err != nil {
+		return nil, err
+	}
+	return cepbv2.ToProto(evt)
+}
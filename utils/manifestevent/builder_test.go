@@ -0,0 +1,114 @@
+package manifestevent
+
+import (
+	"encoding/json"
+	"testing"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	"github.com/cloudevents/sdk-go/v2/event"
+	cloudeventstypes "github.com/cloudevents/sdk-go/v2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	workpayload "open-cluster-management.io/api/cloudevents/work/payload"
+)
+
+func newConfigMap(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+			},
+			"data": map[string]interface{}{
+				"foo": "bar",
+			},
+		},
+	}
+}
+
+func extension(t *testing.T, evt *event.Event, name string) string {
+	val, err := cloudeventstypes.ToString(evt.Extensions()[name])
+	require.NoError(t, err, "extension %s", name)
+	return val
+}
+
+func TestBuild(t *testing.T) {
+	b := NewBuilder("consumer1")
+	require.NotEmpty(t, b.ResourceID(), "resource id")
+
+	create, err := b.Build(ActionCreate, newConfigMap("cm1"))
+	require.NoError(t, err, "Build()")
+	assert.Equal(t, "io.open-cluster-management.works.v1alpha1.manifests.spec.create_request", create.Type(), "type")
+	assert.Equal(t, DefaultSource, create.Source(), "source")
+	assert.NotEmpty(t, create.ID(), "id")
+	assert.False(t, create.Time().IsZero(), "time")
+	assert.Equal(t, "application/json", create.DataContentType(), "content type")
+	assert.Equal(t, b.ResourceID(), extension(t, create, cetypes.ExtensionResourceID), "resource id")
+	assert.Equal(t, "consumer1", extension(t, create, cetypes.ExtensionClusterName), "cluster name")
+	assert.Equal(t, "1", extension(t, create, cetypes.ExtensionResourceVersion), "resource version")
+	assert.NotContains(t, create.Extensions(), cetypes.ExtensionDeletionTimestamp, "deletion timestamp")
+
+	manifest := &workpayload.Manifest{}
+	require.NoError(t, json.Unmarshal(create.Data(), manifest), "Unmarshal()")
+	assert.Equal(t, "cm1", manifest.Manifest.GetName(), "manifest")
+
+	update, err := b.Build(ActionUpdate, newConfigMap("cm1"))
+	require.NoError(t, err, "Build()")
+	assert.Equal(t, "io.open-cluster-management.works.v1alpha1.manifests.spec.update_request", update.Type(), "type")
+	assert.NotEqual(t, create.ID(), update.ID(), "id")
+	assert.Equal(t, "2", extension(t, update, cetypes.ExtensionResourceVersion), "resource version")
+	assert.Equal(t, int64(2), b.ResourceVersion(), "resource version")
+
+	del, err := b.Build(ActionDelete, newConfigMap("cm1"))
+	require.NoError(t, err, "Build()")
+	assert.Equal(t, "io.open-cluster-management.works.v1alpha1.manifests.spec.delete_request", del.Type(), "type")
+	assert.Contains(t, del.Extensions(), cetypes.ExtensionDeletionTimestamp, "deletion timestamp")
+
+	manifest = &workpayload.Manifest{}
+	require.NoError(t, json.Unmarshal(del.Data(), manifest), "Unmarshal()")
+	assert.NotNil(t, manifest.Manifest.GetDeletionTimestamp(), "manifest deletion timestamp")
+
+	_, err = b.Build(ActionCreate)
+	assert.Error(t, err, "Build() without objects")
+}
+
+func TestBuildBundle(t *testing.T) {
+	b := NewBuilder("consumer1").WithResourceID("r1").WithResourceVersion(4)
+
+	evt, err := b.Build(ActionUpdate, newConfigMap("cm1"), newConfigMap("cm2"))
+	require.NoError(t, err, "Build()")
+	assert.Equal(t, "io.open-cluster-management.works.v1alpha1.manifestbundles.spec.update_request", evt.Type(), "type")
+	assert.Equal(t, "r1", extension(t, evt, cetypes.ExtensionResourceID), "resource id")
+	assert.Equal(t, "5", extension(t, evt, cetypes.ExtensionResourceVersion), "resource version")
+
+	bundle := &workpayload.ManifestBundle{}
+	require.NoError(t, json.Unmarshal(evt.Data(), bundle), "Unmarshal()")
+	require.Len(t, bundle.Manifests, 2, "manifests")
+	assert.Contains(t, string(bundle.Manifests[1].Raw), `"name":"cm2"`, "second manifest")
+}
+
+func TestBuildEncodings(t *testing.T) {
+	b := NewBuilder("consumer1")
+
+	data, err := b.BuildJSON(ActionCreate, newConfigMap("cm1"))
+	require.NoError(t, err, "BuildJSON()")
+	evt := event.New()
+	require.NoError(t, json.Unmarshal(data, &evt), "Unmarshal()")
+	assert.Equal(t, "1", extension(t, &evt, cetypes.ExtensionResourceVersion), "resource version")
+	assert.Equal(t, "consumer1", extension(t, &evt, cetypes.ExtensionClusterName), "cluster name")
+
+	pbEvt, err := b.BuildProto(ActionUpdate, newConfigMap("cm1"))
+	require.NoError(t, err, "BuildProto()")
+	fromProto, err := cepbv2.FromProto(pbEvt)
+	require.NoError(t, err, "FromProto()")
+	assert.Equal(t, "2", extension(t, fromProto, cetypes.ExtensionResourceVersion), "resource version")
+	assert.Equal(t, b.ResourceID(), extension(t, fromProto, cetypes.ExtensionResourceID), "resource id")
+
+	manifest := &workpayload.Manifest{}
+	require.NoError(t, json.Unmarshal(fromProto.Data(), manifest), "Unmarshal()")
+	assert.Equal(t, "cm1", manifest.Manifest.GetName(), "manifest")
+}
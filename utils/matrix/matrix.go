@@ -0,0 +1,190 @@
+package matrix
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// NodeImageEnv is the environment variable the suite reads the kind node image from
+	NodeImageEnv = "KIND_NODE_IMAGE"
+	// ClusterNameEnv is the environment variable the suite reads the kind cluster name from, an existing
+	// cluster of that name is reused and kept after the run
+	ClusterNameEnv = "KIND_CLUSTER_NAME"
+	// DefaultNodeImage is the kind node image of the suite
+	DefaultNodeImage = "kindest/node:v1.27.1"
+)
+
+// Component is a component of the suite whose image the matrix overrides
+type Component struct {
+	Name string
+	// Image is the image name in the manifests, without tag
+	Image string
+	// Env is the environment variable the suite reads the image override from
+	Env string
+}
+
+// Components are the components of the suite, in the order they are installed
+var Components = []Component{
+	{Name: "mqtt-broker", Image: "quay.io/morvencao/eclipse-mosquitto", Env: "MQTT_BROKER_IMAGE"},
+	{Name: "work-agent", Image: "quay.io/open-cluster-management/work", Env: "WORK_AGENT_IMAGE"},
+	{Name: "dynamodb", Image: "amazon/dynamodb-local", Env: "DYNAMODB_IMAGE"},
+	{Name: "maestro", Image: "quay.io/morvencao/maestro-api", Env: "MAESTRO_IMAGE"},
+}
+
+// ImagesFromEnv returns the image overrides set in the environment, keyed by the image name in the manifests
+func ImagesFromEnv() map[string]string {
+	images := map[string]string{}
+	for _, c := range Components {
+		if ref := os.Getenv(c.Env); ref != "" {
+			images[c.Image] = ref
+		}
+	}
+	return images
+}
+
+// NodeImageFromEnv returns the kind node image set in the environment, or the default one
+func NodeImageFromEnv() string {
+	if image := os.Getenv(NodeImageEnv); image != "" {
+		return image
+	}
+	return DefaultNodeImage
+}
+
+// Config is the matrix of images the suite runs against, every combination of a node image and
+// a tag of each component is a run
+type Config struct {
+	// NodeImages are the kind node images, defaults to the node image of the suite
+	NodeImages []string `json:"nodeImages"`
+	// Components are the image tags of each component, keyed by component name, a component that is
+	// not listed keeps the image of the manifests
+	Components map[string][]string `json:"components"`
+	// ReuseClusters runs all the combinations of a node image on the same kind cluster, the components
+	// are uninstalled between the runs, otherwise every run gets a fresh cluster
+	ReuseClusters bool `json:"reuseClusters"`
+}
+
+// LoadConfig reads the YAML matrix config
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse the matrix config %s: %v", path, err)
+	}
+	if len(config.NodeImages) == 0 {
+		config.NodeImages = []string{DefaultNodeImage}
+	}
+
+	for name, tags := range config.Components {
+		if _, ok := component(name); !ok {
+			return nil, fmt.Errorf("unknown component %q in the matrix config %s", name, path)
+		}
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("no tag of component %q in the matrix config %s", name, path)
+		}
+	}
+	return config, nil
+}
+
+func component(name string) (Component, bool) {
+	for _, c := range Components {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Component{}, false
+}
+
+// Combination is a run of the matrix
+type Combination struct {
+	NodeImage string `json:"nodeImage"`
+	// Images are the image references, keyed by component name
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// Combinations returns every combination of the config, the combinations of a node image are next
+// to each other so they can run on the same cluster
+func (c *Config) Combinations() []Combination {
+	names := make([]string, 0, len(c.Components))
+	for name := range c.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combinations := []Combination{}
+	for _, nodeImage := range c.NodeImages {
+		images := []map[string]string{{}}
+		for _, name := range names {
+			comp, _ := component(name)
+			var next []map[string]string
+			for _, prev := range images {
+				for _, tag := range c.Components[name] {
+					image := map[string]string{}
+					for k, v := range prev {
+						image[k] = v
+					}
+					image[name] = comp.Image + ":" + tag
+					next = append(next, image)
+				}
+			}
+			images = next
+		}
+
+		for _, image := range images {
+			combinations = append(combinations, Combination{NodeImage: nodeImage, Images: image})
+		}
+	}
+	return combinations
+}
+
+// Name returns a short name of the combination, e.g. v1.27.1 maestro=latest work-agent=v0.12.0
+func (c Combination) Name() string {
+	parts := []string{tagOf(c.NodeImage)}
+	for _, comp := range sortedComponents(c.Images) {
+		parts = append(parts, fmt.Sprintf("%s=%s", comp, tagOf(c.Images[comp])))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Env returns the environment variables that make the suite run the combination
+func (c Combination) Env() []string {
+	env := []string{fmt.Sprintf("%s=%s", NodeImageEnv, c.NodeImage)}
+	for _, name := range sortedComponents(c.Images) {
+		comp, _ := component(name)
+		env = append(env, fmt.Sprintf("%s=%s", comp.Env, c.Images[name]))
+	}
+	return env
+}
+
+var invalidClusterName = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ClusterName returns the name of the kind cluster shared by the combinations of the node image
+func ClusterName(nodeImage string) string {
+	return "maestro-matrix-" + strings.Trim(invalidClusterName.ReplaceAllString(strings.ToLower(tagOf(nodeImage)), "-"), "-")
+}
+
+func sortedComponents(images map[string]string) []string {
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tagOf returns the tag of the image reference, or the reference when it has no tag
+func tagOf(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return ref
+}
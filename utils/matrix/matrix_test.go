@@ -0,0 +1,134 @@
+package matrix
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "matrix.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0644))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `
+components:
+  maestro: [latest, v0.1.0]
+reuseClusters: true
+`))
+	require.NoError(t, err, "LoadConfig()")
+	assert.Equal(t, []string{DefaultNodeImage}, config.NodeImages, "default node image")
+	assert.True(t, config.ReuseClusters, "reuse clusters")
+
+	_, err = LoadConfig(writeConfig(t, "components:\n  unknown: [latest]\n"))
+	assert.ErrorContains(t, err, `unknown component "unknown"`)
+
+	_, err = LoadConfig(writeConfig(t, "components:\n  maestro: []\n"))
+	assert.ErrorContains(t, err, `no tag of component "maestro"`)
+
+	_, err = LoadConfig(writeConfig(t, "nodeImage: kindest/node:v1.27.1\n"))
+	assert.Error(t, err, "unknown field")
+}
+
+func TestCombinations(t *testing.T) {
+	config := &Config{
+		NodeImages: []string{"kindest/node:v1.27.1", "kindest/node:v1.28.0"},
+		Components: map[string][]string{
+			"work-agent": {"latest", "v0.12.0"},
+			"maestro":    {"latest"},
+		},
+	}
+
+	combinations := config.Combinations()
+	require.Len(t, combinations, 4)
+
+	var names []string
+	for _, c := range combinations {
+		names = append(names, c.Name())
+	}
+	assert.Equal(t, []string{
+		"v1.27.1 maestro=latest work-agent=latest",
+		"v1.27.1 maestro=latest work-agent=v0.12.0",
+		"v1.28.0 maestro=latest work-agent=latest",
+		"v1.28.0 maestro=latest work-agent=v0.12.0",
+	}, names, "the combinations of a node image are next to each other")
+
+	assert.Equal(t, []string{
+		"KIND_NODE_IMAGE=kindest/node:v1.27.1",
+		"MAESTRO_IMAGE=quay.io/morvencao/maestro-api:latest",
+		"WORK_AGENT_IMAGE=quay.io/open-cluster-management/work:v0.12.0",
+	}, combinations[1].Env(), "env")
+
+	combinations = (&Config{NodeImages: []string{"kindest/node:v1.27.1"}}).Combinations()
+	assert.Equal(t, []Combination{{NodeImage: "kindest/node:v1.27.1", Images: map[string]string{}}}, combinations, "no component override")
+}
+
+func TestClusterName(t *testing.T) {
+	assert.Equal(t, "maestro-matrix-v1-27-1", ClusterName("kindest/node:v1.27.1"))
+	assert.Equal(t, "maestro-matrix-kindest-node", ClusterName("kindest/node"))
+}
+
+func TestImagesFromEnv(t *testing.T) {
+	t.Setenv("MAESTRO_IMAGE", "quay.io/morvencao/maestro-api:v0.1.0")
+	t.Setenv("WORK_AGENT_IMAGE", "")
+	t.Setenv(NodeImageEnv, "")
+
+	assert.Equal(t, map[string]string{"quay.io/morvencao/maestro-api": "quay.io/morvencao/maestro-api:v0.1.0"}, ImagesFromEnv())
+	assert.Equal(t, DefaultNodeImage, NodeImageFromEnv())
+}
+
+func TestParseTestEvents(t *testing.T) {
+	output := `{"Action":"run","Test":"TestResourceGRPCService"}
+{"Action":"output","Test":"TestResourceGRPCService","Output":"=== RUN   TestResourceGRPCService\n"}
+{"Action":"pass","Test":"TestResourceGRPCService/Resource_GRPC_Service"}
+{"Action":"pass","Test":"TestResourceGRPCService"}
+{"Action":"skip","Test":"TestDrift"}
+{"Action":"fail","Test":"TestUpgrade"}
+{"Action":"fail","Package":"github.com/morvencao/maestro-e2e/e2e"}
+not an event
+`
+	outcomes, err := ParseTestEvents(strings.NewReader(output))
+	require.NoError(t, err, "ParseTestEvents()")
+	assert.Equal(t, map[string]string{
+		"TestResourceGRPCService":                       OutcomePass,
+		"TestResourceGRPCService/Resource_GRPC_Service": OutcomePass,
+		"TestDrift":   OutcomeSkip,
+		"TestUpgrade": OutcomeFail,
+	}, outcomes, "outcomes")
+}
+
+func TestReport(t *testing.T) {
+	report := &Report{Results: []Result{
+		{
+			Combination: Combination{NodeImage: "kindest/node:v1.27.1", Images: map[string]string{"maestro": "quay.io/morvencao/maestro-api:latest"}},
+			Duration:    120,
+			Tests:       map[string]string{"TestA": OutcomePass, "TestB": OutcomePass},
+		},
+		{
+			Combination: Combination{NodeImage: "kindest/node:v1.28.0", Images: map[string]string{"maestro": "quay.io/morvencao/maestro-api:latest"}},
+			Duration:    130,
+			Tests:       map[string]string{"TestA": OutcomePass, "TestB": OutcomeFail, "TestC": OutcomeSkip},
+		},
+	}}
+
+	assert.Equal(t, []string{"TestB", "TestC"}, report.Differences(), "differences")
+	assert.Equal(t, 1, report.Results[1].Count(OutcomeFail), "fail count")
+
+	table := report.FormatTable()
+	assert.Contains(t, table, "#2   v1.28.0 maestro=latest  1     1     1     130s")
+	assert.Contains(t, table, "* TestB  pass  fail")
+	assert.Contains(t, table, "* TestC  -     skip")
+	assert.Contains(t, table, "TestA    pass  pass")
+
+	path := filepath.Join(t.TempDir(), "matrix", "report.json")
+	require.NoError(t, report.WriteFile(path), "WriteFile()")
+	read, err := ReadReport(path)
+	require.NoError(t, err, "ReadReport()")
+	assert.Equal(t, report, read, "report")
+}
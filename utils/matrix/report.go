@@ -0,0 +1,173 @@
+package matrix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// the outcomes of a test, as reported by go test -json
+const (
+	OutcomePass = "pass"
+	OutcomeFail = "fail"
+	OutcomeSkip = "skip"
+)
+
+// testEvent is an event printed by go test -json
+type testEvent struct {
+	Action string `json:"Action"`
+	Test   string `json:"Test"`
+}
+
+// ParseTestEvents reads the output of go test -json and returns the outcome of every test and
+// subtest, keyed by test name, the lines that are not events are ignored
+func ParseTestEvents(r io.Reader) (map[string]string, error) {
+	outcomes := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Test == "" {
+			continue
+		}
+
+		switch event.Action {
+		case OutcomePass, OutcomeFail, OutcomeSkip:
+			outcomes[event.Test] = event.Action
+		}
+	}
+	return outcomes, scanner.Err()
+}
+
+// Result is the result of a run of the matrix
+type Result struct {
+	Combination Combination `json:"combination"`
+	// Duration is the duration of the run in seconds
+	Duration float64 `json:"duration_s"`
+	// Error is set when the suite could not run, e.g. the cluster could not be created
+	Error string `json:"error,omitempty"`
+	// Tests are the outcomes of the tests, keyed by test name
+	Tests map[string]string `json:"tests"`
+}
+
+// Count returns the number of tests with the given outcome
+func (r Result) Count(outcome string) int {
+	count := 0
+	for _, o := range r.Tests {
+		if o == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// Report is the comparison report of the runs of the matrix
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// WriteFile writes the report as indented JSON to the given path, creating the parent directories
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadReport reads a report written by WriteFile
+func ReadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Differences returns the names of the tests whose outcome differs across the runs, a test missing
+// from a run differs from the runs that have it
+func (r *Report) Differences() []string {
+	var names []string
+	for _, name := range r.testNames() {
+		outcomes := map[string]bool{}
+		for _, result := range r.Results {
+			outcomes[result.Tests[name]] = true
+		}
+		if len(outcomes) > 1 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (r *Report) testNames() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, result := range r.Results {
+		for name := range result.Tests {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatTable formats the report as a table with a column per run, the runs are listed first, then
+// the outcome of every test, the tests whose outcome differs across the runs are marked with *
+func (r *Report) FormatTable() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tCOMBINATION\tPASS\tFAIL\tSKIP\tDURATION\tERROR")
+	for i, result := range r.Results {
+		fmt.Fprintf(w, "#%d\t%s\t%d\t%d\t%d\t%.0fs\t%s\n", i+1, result.Combination.Name(),
+			result.Count(OutcomePass), result.Count(OutcomeFail), result.Count(OutcomeSkip), result.Duration, result.Error)
+	}
+	w.Flush()
+	fmt.Fprintln(&buf)
+
+	differences := map[string]bool{}
+	for _, name := range r.Differences() {
+		differences[name] = true
+	}
+
+	w = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	header := []string{"TEST"}
+	for i := range r.Results {
+		header = append(header, fmt.Sprintf("#%d", i+1))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, name := range r.testNames() {
+		row := []string{name}
+		if differences[name] {
+			row[0] = "* " + name
+		}
+		for _, result := range r.Results {
+			outcome := result.Tests[name]
+			if outcome == "" {
+				outcome = "-"
+			}
+			row = append(row, outcome)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	return buf.String()
+}
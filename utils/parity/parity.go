@@ -0,0 +1,204 @@
+package parity
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Options tells the normalizer which values legitimately differ between the REST and the GRPC runs
+type Options struct {
+	// IDFields are generated by maestro, their values are replaced by placeholders
+	IDFields []string
+	// IgnoreFields are removed before the comparison, e.g. timestamps
+	IgnoreFields []string
+}
+
+// DefaultOptions returns the id and timestamp fields of the maestro API
+func DefaultOptions() Options {
+	return Options{
+		IDFields:     []string{"id", "consumerId"},
+		IgnoreFields: []string{"creationTimestamp", "lastTransitionTime", "sentTimestamp", "time"},
+	}
+}
+
+// FromProto returns the generic JSON form of the message, rendered like the gateway renders the REST responses
+func FromProto(msg proto.Message) (interface{}, error) {
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return FromJSON(data)
+}
+
+// FromJSON returns the generic JSON form of the data
+func FromJSON(data []byte) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Normalizer replaces the ids with placeholders numbered in order of appearance, so the same id
+// gets the same placeholder in all the steps of a scenario. Each side of a scenario needs its own normalizer
+type Normalizer struct {
+	opts Options
+	ids  map[string]string
+}
+
+// NewNormalizer returns a normalizer with the options
+func NewNormalizer(opts Options) *Normalizer {
+	return &Normalizer{opts: opts, ids: map[string]string{}}
+}
+
+// Normalize returns a copy of the value without the ignored fields and the empty values,
+// the gateway renders the unset fields while the GRPC requests usually leave them out
+func (n *Normalizer) Normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for key, field := range val {
+			if contains(n.opts.IgnoreFields, key) {
+				continue
+			}
+
+			if id, ok := field.(string); ok && id != "" && contains(n.opts.IDFields, key) {
+				out[key] = n.placeholder(id)
+				continue
+			}
+
+			if normalized := n.Normalize(field); !isEmpty(normalized) {
+				out[key] = normalized
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, 0, len(val))
+		for _, item := range val {
+			out = append(out, n.Normalize(item))
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func (n *Normalizer) placeholder(id string) string {
+	if p, ok := n.ids[id]; ok {
+		return p
+	}
+	p := fmt.Sprintf("<id-%d>", len(n.ids)+1)
+	n.ids[id] = p
+	return p
+}
+
+// Difference is a field that differs between the REST and the GRPC side, a nil value
+// means that the field is missing on that side
+type Difference struct {
+	Path string
+	REST interface{}
+	GRPC interface{}
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: REST=%s GRPC=%s", d.Path, format(d.REST), format(d.GRPC))
+}
+
+// Diff returns the fields that differ between the REST and the GRPC values, sorted by path
+func Diff(rest, grpc interface{}) []Difference {
+	var diffs []Difference
+	diff("", rest, grpc, &diffs)
+	return diffs
+}
+
+func diff(path string, rest, grpc interface{}, diffs *[]Difference) {
+	restMap, restIsMap := rest.(map[string]interface{})
+	grpcMap, grpcIsMap := grpc.(map[string]interface{})
+	if restIsMap && grpcIsMap {
+		keys := map[string]bool{}
+		for key := range restMap {
+			keys[key] = true
+		}
+		for key := range grpcMap {
+			keys[key] = true
+		}
+
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			diff(join(path, key), restMap[key], grpcMap[key], diffs)
+		}
+		return
+	}
+
+	restList, restIsList := rest.([]interface{})
+	grpcList, grpcIsList := grpc.([]interface{})
+	if restIsList && grpcIsList {
+		for i := 0; i < len(restList) || i < len(grpcList); i++ {
+			var restItem, grpcItem interface{}
+			if i < len(restList) {
+				restItem = restList[i]
+			}
+			if i < len(grpcList) {
+				grpcItem = grpcList[i]
+			}
+			diff(fmt.Sprintf("%s[%d]", path, i), restItem, grpcItem, diffs)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(rest, grpc) {
+		*diffs = append(*diffs, Difference{Path: path, REST: rest, GRPC: grpc})
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func format(v interface{}) string {
+	if v == nil {
+		return "<missing>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func isEmpty(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return val == ""
+	case map[string]interface{}:
+		return len(val) == 0
+	case []interface{}:
+		return len(val) == 0
+	}
+	return false
+}
+
+func contains(fields []string, field string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
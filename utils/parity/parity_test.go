@@ -0,0 +1,50 @@
+package parity
+
+import (
+	"testing"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	n := NewNormalizer(DefaultOptions())
+
+	created, err := FromJSON([]byte(`{"id": "c1", "labels": [], "status": {"lastTransitionTime": "2023-09-01T17:31:00Z"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "<id-1>"}, n.Normalize(created), "created")
+
+	resource, err := FromJSON([]byte(`{"id": "r1", "consumerId": "c1", "generationId": "1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "<id-2>", "consumerId": "<id-1>", "generationId": "1"}, n.Normalize(resource),
+		"the same id keeps its placeholder")
+}
+
+func TestDiff(t *testing.T) {
+	rest, err := FromJSON([]byte(`{"name": "Test", "labels": [{"key": "foo", "value": "bar"}, {"key": "baz", "value": "qux"}]}`))
+	require.NoError(t, err)
+	grpc, err := FromProto(&maestropbv1.ConsumerCreateRequest{
+		Labels: []*maestropbv1.ConsumerLabel{{Key: "foo", Value: "goo"}},
+	})
+	require.NoError(t, err)
+
+	diffs := Diff(NewNormalizer(DefaultOptions()).Normalize(rest), NewNormalizer(DefaultOptions()).Normalize(grpc))
+	require.Len(t, diffs, 3, "differences")
+	assert.Equal(t, `labels[0].value: REST="bar" GRPC="goo"`, diffs[0].String())
+	assert.Equal(t, `labels[1]: REST={"key":"baz","value":"qux"} GRPC=<missing>`, diffs[1].String())
+	assert.Equal(t, `name: REST="Test" GRPC=<missing>`, diffs[2].String(), "the name is only settable over REST")
+}
+
+func TestDiffEqual(t *testing.T) {
+	rest, err := FromJSON([]byte(`{"id": "c1", "labels": [{"key": "foo", "value": "bar"}]}`))
+	require.NoError(t, err)
+	grpc, err := FromProto(&maestropbv1.Consumer{
+		Id:     "c2",
+		Labels: []*maestropbv1.ConsumerLabel{{Key: "foo", Value: "bar"}},
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, Diff(NewNormalizer(DefaultOptions()).Normalize(rest), NewNormalizer(DefaultOptions()).Normalize(grpc)),
+		"the generated ids are not compared")
+}
@@ -0,0 +1,269 @@
+package perf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Recorder collects latency samples and error counts per metric, it is safe for concurrent use
+type Recorder struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+	errors  map[string]int
+}
+
+// NewRecorder returns an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		samples: map[string][]time.Duration{},
+		errors:  map[string]int{},
+	}
+}
+
+// Observe records a latency sample for the metric
+func (r *Recorder) Observe(metric string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[metric] = append(r.samples[metric], latency)
+}
+
+// Error records a failed sample for the metric
+func (r *Recorder) Error(metric string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[metric]++
+}
+
+// Summarize computes the summary of every metric recorded so far
+func (r *Recorder) Summarize() map[string]MetricSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metrics := map[string]MetricSummary{}
+	for metric, samples := range r.samples {
+		metrics[metric] = Summarize(samples, r.errors[metric])
+	}
+	for metric, errors := range r.errors {
+		if _, ok := metrics[metric]; !ok {
+			metrics[metric] = Summarize(nil, errors)
+		}
+	}
+
+	return metrics
+}
+
+// MetricSummary is the latency distribution of a metric, latencies are in milliseconds
+type MetricSummary struct {
+	Count  int     `json:"count"`
+	Errors int     `json:"errors"`
+	Min    float64 `json:"min_ms"`
+	Mean   float64 `json:"mean_ms"`
+	P50    float64 `json:"p50_ms"`
+	P95    float64 `json:"p95_ms"`
+	P99    float64 `json:"p99_ms"`
+	Max    float64 `json:"max_ms"`
+}
+
+// Summarize computes the summary of the given samples
+func Summarize(samples []time.Duration, errors int) MetricSummary {
+	summary := MetricSummary{Count: len(samples), Errors: errors}
+	if len(samples) == 0 {
+		return summary
+	}
+
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, s := range sorted {
+		total += s
+	}
+
+	summary.Min = toMillis(sorted[0])
+	summary.Max = toMillis(sorted[len(sorted)-1])
+	summary.Mean = toMillis(total / time.Duration(len(sorted)))
+	summary.P50 = toMillis(percentile(sorted, 50))
+	summary.P95 = toMillis(percentile(sorted, 95))
+	summary.P99 = toMillis(percentile(sorted, 99))
+	return summary
+}
+
+// percentile returns the nearest-rank percentile of the sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Report is the JSON summary of a performance run
+type Report struct {
+	Name    string                   `json:"name"`
+	Params  map[string]string        `json:"params,omitempty"`
+	Metrics map[string]MetricSummary `json:"metrics"`
+}
+
+// WriteFile writes the report as indented JSON to the given path, creating the parent directories
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadReport reads a report written by WriteFile
+func ReadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Comparison is the comparison of a statistic of a metric against the baseline
+type Comparison struct {
+	Metric    string
+	Stat      string
+	Baseline  float64
+	Current   float64
+	Change    float64
+	Regressed bool
+}
+
+// CompareOptions configures the comparison of a report against a baseline
+type CompareOptions struct {
+	// Threshold is the allowed relative increase, 0.2 allows the current value to be 20% above the baseline
+	Threshold float64
+	// Stats are the statistics to compare, defaults to p50, p95 and p99
+	Stats []string
+}
+
+// Compare compares the current report against the baseline, metrics missing from the
+// baseline are skipped and metrics missing from the current report are regressions. It
+// returns an error when a statistic is not one of min, mean, p50, p95, p99 and max
+func Compare(baseline, current *Report, o CompareOptions) ([]Comparison, error) {
+	stats := o.Stats
+	if len(stats) == 0 {
+		stats = []string{"p50", "p95", "p99"}
+	}
+	for _, stat := range stats {
+		if _, ok := (MetricSummary{}).stat(stat); !ok {
+			return nil, fmt.Errorf("unknown statistic %q", stat)
+		}
+	}
+
+	metrics := make([]string, 0, len(baseline.Metrics))
+	for metric := range baseline.Metrics {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	comparisons := []Comparison{}
+	for _, metric := range metrics {
+		base := baseline.Metrics[metric]
+		cur, ok := current.Metrics[metric]
+		for _, stat := range stats {
+			c := Comparison{Metric: metric, Stat: stat}
+			c.Baseline, _ = base.stat(stat)
+			if !ok {
+				c.Regressed = true
+				comparisons = append(comparisons, c)
+				continue
+			}
+
+			c.Current, _ = cur.stat(stat)
+			if c.Baseline > 0 {
+				c.Change = (c.Current - c.Baseline) / c.Baseline
+			}
+			c.Regressed = c.Current > c.Baseline*(1+o.Threshold)
+			comparisons = append(comparisons, c)
+		}
+
+		// more errors than the baseline is a regression, whatever the latency
+		if ok {
+			comparisons = append(comparisons, Comparison{
+				Metric:    metric,
+				Stat:      "errors",
+				Baseline:  float64(base.Errors),
+				Current:   float64(cur.Errors),
+				Change:    float64(cur.Errors - base.Errors),
+				Regressed: cur.Errors > base.Errors,
+			})
+		}
+	}
+
+	return comparisons, nil
+}
+
+// Regressions returns the regressed comparisons
+func Regressions(comparisons []Comparison) []Comparison {
+	regressions := []Comparison{}
+	for _, c := range comparisons {
+		if c.Regressed {
+			regressions = append(regressions, c)
+		}
+	}
+	return regressions
+}
+
+// FormatTable formats the comparisons as a table
+func FormatTable(comparisons []Comparison) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tSTAT\tBASELINE\tCURRENT\tCHANGE\tRESULT")
+	for _, c := range comparisons {
+		result := "ok"
+		if c.Regressed {
+			result = "REGRESSED"
+		}
+
+		change := fmt.Sprintf("%+.1f%%", c.Change*100)
+		if c.Stat == "errors" {
+			change = fmt.Sprintf("%+.0f", c.Change)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%s\t%s\n", c.Metric, c.Stat, c.Baseline, c.Current, change, result)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func (s MetricSummary) stat(name string) (float64, bool) {
+	switch name {
+	case "min":
+		return s.Min, true
+	case "mean":
+		return s.Mean, true
+	case "p50":
+		return s.P50, true
+	case "p95":
+		return s.P95, true
+	case "p99":
+		return s.P99, true
+	case "max":
+		return s.Max, true
+	}
+	return 0, false
+}
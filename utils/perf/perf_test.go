@@ -0,0 +1,113 @@
+package perf
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize(t *testing.T) {
+	samples := []time.Duration{}
+	// 100 samples from 100ms down to 1ms
+	for i := 100; i > 0; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+
+	summary := Summarize(samples, 2)
+	assert.Equal(t, 100, summary.Count, "count")
+	assert.Equal(t, 2, summary.Errors, "errors")
+	assert.Equal(t, 1.0, summary.Min, "min")
+	assert.Equal(t, 100.0, summary.Max, "max")
+	assert.Equal(t, 50.5, summary.Mean, "mean")
+	assert.Equal(t, 50.0, summary.P50, "p50")
+	assert.Equal(t, 95.0, summary.P95, "p95")
+	assert.Equal(t, 99.0, summary.P99, "p99")
+
+	// the samples must not be reordered
+	assert.Equal(t, 100*time.Millisecond, samples[0], "first sample")
+
+	empty := Summarize(nil, 3)
+	assert.Equal(t, MetricSummary{Errors: 3}, empty, "empty summary")
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.Observe("create", time.Duration(i)*time.Second)
+		}(i)
+	}
+	wg.Wait()
+	r.Error("create")
+	r.Error("update")
+
+	metrics := r.Summarize()
+	assert.Equal(t, 10, metrics["create"].Count, "create count")
+	assert.Equal(t, 1, metrics["create"].Errors, "create errors")
+	assert.Equal(t, 5000.0, metrics["create"].P50, "create p50")
+	assert.Equal(t, 0, metrics["update"].Count, "update count")
+	assert.Equal(t, 1, metrics["update"].Errors, "update errors")
+}
+
+func TestReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "report.json")
+	report := &Report{
+		Name:    "scale",
+		Params:  map[string]string{"consumers": "2"},
+		Metrics: map[string]MetricSummary{"create": {Count: 1, P50: 1.5}},
+	}
+	require.NoError(t, report.WriteFile(path), "WriteFile()")
+
+	read, err := ReadReport(path)
+	require.NoError(t, err, "ReadReport()")
+	assert.Equal(t, report, read, "report")
+}
+
+func TestCompare(t *testing.T) {
+	baseline := &Report{Metrics: map[string]MetricSummary{
+		"create": {Count: 10, P50: 100, P95: 200, P99: 300},
+		"update": {Count: 10, P50: 100, P95: 200, P99: 300},
+		"delete": {Count: 10, P50: 100, P95: 200, P99: 300},
+	}}
+	current := &Report{Metrics: map[string]MetricSummary{
+		// within the threshold
+		"create": {Count: 10, P50: 110, P95: 190, P99: 300},
+		// p95 above the threshold and more errors
+		"update": {Count: 10, Errors: 1, P50: 100, P95: 260, P99: 300},
+		// new metrics are not compared
+		"read": {Count: 10, P50: 1000},
+	}}
+
+	comparisons, err := Compare(baseline, current, CompareOptions{Threshold: 0.2})
+	require.NoError(t, err, "Compare()")
+	// create and update have 3 stats and errors each, delete is missing so only 3 stats
+	require.Len(t, comparisons, 11, "comparisons")
+
+	regressions := Regressions(comparisons)
+	got := []string{}
+	for _, r := range regressions {
+		got = append(got, r.Metric+"/"+r.Stat)
+	}
+	assert.Equal(t, []string{"delete/p50", "delete/p95", "delete/p99", "update/p95", "update/errors"}, got, "regressions")
+	assert.InDelta(t, 0.3, regressions[3].Change, 0.0001, "update p95 change")
+
+	table := FormatTable(comparisons)
+	assert.Contains(t, table, "METRIC", "table header")
+	assert.Contains(t, table, "+30.0%", "update p95 change")
+	assert.Equal(t, 12, strings.Count(table, "\n"), "table lines")
+}
+
+func TestCompareUnknownStat(t *testing.T) {
+	report := &Report{Metrics: map[string]MetricSummary{"create": {Count: 10, P50: 100}}}
+	_, err := Compare(report, report, CompareOptions{Stats: []string{"p50", "p90"}})
+	assert.ErrorContains(t, err, `unknown statistic "p90"`, "Compare()")
+}
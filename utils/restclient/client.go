@@ -0,0 +1,226 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// ContentTypeJSON is the content type of the consumer and resource requests
+	ContentTypeJSON = "application/json"
+	// ContentTypeCloudEvents is the content type of the structured cloudevents requests
+	ContentTypeCloudEvents = "application/x-cloudevents"
+)
+
+// Error is returned when maestro responds with a non 200 status code
+type Error struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status code %d: %s", e.Method, e.URL, e.StatusCode, strings.TrimSpace(string(e.Body)))
+}
+
+// Status decodes the body of the error, the gateway responds with the google.rpc.Status of the
+// failed call, e.g. {"code": 2, "message": "Resource not found", "details": []}
+func (e *Error) Status() (*spb.Status, error) {
+	st := &spb.Status{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(e.Body, st); err != nil {
+		return nil, fmt.Errorf("failed to decode %s %s error body: %v", e.Method, e.URL, err)
+	}
+	return st, nil
+}
+
+// Code returns the gRPC code reported in the body of the error, or codes.Unknown when it cannot be decoded
+func (e *Error) Code() codes.Code {
+	st, err := e.Status()
+	if err != nil {
+		return codes.Unknown
+	}
+	return codes.Code(st.Code)
+}
+
+// StatusCode returns the HTTP status code of the error, or 0 when it is not an *Error
+func StatusCode(err error) int {
+	var restErr *Error
+	if errors.As(err, &restErr) {
+		return restErr.StatusCode
+	}
+	return 0
+}
+
+// Client is a client of the maestro REST API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	header     http.Header
+}
+
+// New returns a client for the maestro REST API at the base URL, e.g. http://127.0.0.1:31330
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+		header:     http.Header{},
+	}
+}
+
+// WithHeader returns a copy of the client that sets the header on every request, e.g. Accept
+func (c *Client) WithHeader(key, value string) *Client {
+	header := c.header.Clone()
+	header.Set(key, value)
+	return &Client{baseURL: c.baseURL, httpClient: c.httpClient, header: header}
+}
+
+// Do sends the request to the path and decodes the response into out when it is not nil,
+// it returns an *Error when the status code is not 200
+func (c *Client) Do(ctx context.Context, method, path, contentType string, body []byte, out proto.Message) error {
+	respBody, err := c.DoRaw(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode %s %s response: %v", method, c.baseURL+path, err)
+	}
+
+	return nil
+}
+
+// DoRaw sends the request to the path and returns the response body as is,
+// it returns an *Error when the status code is not 200
+func (c *Client) DoRaw(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Method: method, URL: req.URL.String(), StatusCode: resp.StatusCode, Body: respBody}
+	}
+
+	return respBody, nil
+}
+
+func (c *Client) doProto(ctx context.Context, method, path string, in, out proto.Message) error {
+	body, err := protojson.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.Do(ctx, method, path, ContentTypeJSON, body, out)
+}
+
+// CreateConsumer creates a consumer with POST /v1/consumers
+func (c *Client) CreateConsumer(ctx context.Context, req *maestropbv1.ConsumerCreateRequest) (*maestropbv1.Consumer, error) {
+	consumer := &maestropbv1.Consumer{}
+	if err := c.doProto(ctx, http.MethodPost, "/v1/consumers", req, consumer); err != nil {
+		return nil, err
+	}
+	return consumer, nil
+}
+
+// GetConsumer retrieves a consumer with GET /v1/consumers/{id}
+func (c *Client) GetConsumer(ctx context.Context, id string) (*maestropbv1.Consumer, error) {
+	consumer := &maestropbv1.Consumer{}
+	if err := c.Do(ctx, http.MethodGet, "/v1/consumers/"+id, "", nil, consumer); err != nil {
+		return nil, err
+	}
+	return consumer, nil
+}
+
+// UpdateConsumer updates a consumer with PUT /v1/consumers/{id}
+func (c *Client) UpdateConsumer(ctx context.Context, req *maestropbv1.ConsumerUpdateRequest) (*maestropbv1.Consumer, error) {
+	consumer := &maestropbv1.Consumer{}
+	if err := c.doProto(ctx, http.MethodPut, "/v1/consumers/"+req.Id, req, consumer); err != nil {
+		return nil, err
+	}
+	return consumer, nil
+}
+
+// CreateResource creates a resource for the consumer with POST /v1/consumers/{id}/resources
+func (c *Client) CreateResource(ctx context.Context, consumerID string, object *structpb.Struct) (*maestropbv1.Resource, error) {
+	resource := &maestropbv1.Resource{}
+	if err := c.doProto(ctx, http.MethodPost, "/v1/consumers/"+consumerID+"/resources", object, resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// GetResource retrieves a resource with GET /v1/resources/{id}
+func (c *Client) GetResource(ctx context.Context, id string) (*maestropbv1.Resource, error) {
+	resource := &maestropbv1.Resource{}
+	if err := c.Do(ctx, http.MethodGet, "/v1/resources/"+id, "", nil, resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// UpdateResource updates a resource with PUT /v1/resources/{id}
+func (c *Client) UpdateResource(ctx context.Context, id string, object *structpb.Struct) (*maestropbv1.Resource, error) {
+	resource := &maestropbv1.Resource{}
+	if err := c.doProto(ctx, http.MethodPut, "/v1/resources/"+id, object, resource); err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// SendCloudEvent sends a structured cloudevent with POST /v1/cloudevents
+func (c *Client) SendCloudEvent(ctx context.Context, evt *event.Event) (*maestropbv1.CloudEventSendResponse, error) {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &maestropbv1.CloudEventSendResponse{}
+	if err := c.Do(ctx, http.MethodPost, "/v1/cloudevents", ContentTypeCloudEvents, body, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,153 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type recordedRequest struct {
+	Method      string
+	Path        string
+	ContentType string
+	Accept      string
+	Body        map[string]interface{}
+}
+
+func newTestServer(t *testing.T, status int, respBody string) (*httptest.Server, *recordedRequest) {
+	recorded := &recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorded.Method = r.Method
+		recorded.Path = r.URL.Path
+		recorded.ContentType = r.Header.Get("Content-Type")
+		recorded.Accept = r.Header.Get("Accept")
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if len(body) > 0 {
+			require.NoError(t, json.Unmarshal(body, &recorded.Body))
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(respBody))
+	}))
+	t.Cleanup(server.Close)
+	return server, recorded
+}
+
+func TestConsumers(t *testing.T) {
+	server, recorded := newTestServer(t, http.StatusOK, `{"id": "c1", "labels": [{"key": "foo", "value": "bar"}]}`)
+	client := New(server.URL+"/", server.Client())
+	ctx := context.Background()
+
+	consumer, err := client.CreateConsumer(ctx, &maestropbv1.ConsumerCreateRequest{
+		Labels: []*maestropbv1.ConsumerLabel{{Key: "foo", Value: "bar"}},
+	})
+	require.NoError(t, err, "CreateConsumer()")
+	assert.Equal(t, "c1", consumer.Id, "consumer id")
+	assert.Equal(t, "bar", consumer.Labels[0].Value, "consumer label")
+	assert.Equal(t, http.MethodPost, recorded.Method, "method")
+	assert.Equal(t, "/v1/consumers", recorded.Path, "path")
+	assert.Equal(t, ContentTypeJSON, recorded.ContentType, "content type")
+	assert.Equal(t, []interface{}{map[string]interface{}{"key": "foo", "value": "bar"}}, recorded.Body["labels"], "labels")
+
+	_, err = client.GetConsumer(ctx, "c1")
+	require.NoError(t, err, "GetConsumer()")
+	assert.Equal(t, http.MethodGet, recorded.Method, "method")
+	assert.Equal(t, "/v1/consumers/c1", recorded.Path, "path")
+
+	_, err = client.UpdateConsumer(ctx, &maestropbv1.ConsumerUpdateRequest{Id: "c1"})
+	require.NoError(t, err, "UpdateConsumer()")
+	assert.Equal(t, http.MethodPut, recorded.Method, "method")
+	assert.Equal(t, "/v1/consumers/c1", recorded.Path, "path")
+}
+
+func TestResources(t *testing.T) {
+	server, recorded := newTestServer(t, http.StatusOK, `{"id": "r1", "consumerId": "c1", "generationId": "2", "object": {"kind": "ConfigMap"}, "unknown": true}`)
+	client := New(server.URL, server.Client())
+	ctx := context.Background()
+
+	object, err := structpb.NewStruct(map[string]interface{}{"kind": "ConfigMap"})
+	require.NoError(t, err)
+
+	resource, err := client.CreateResource(ctx, "c1", object)
+	require.NoError(t, err, "CreateResource()")
+	assert.Equal(t, "r1", resource.Id, "resource id")
+	assert.Equal(t, int64(2), resource.GenerationId, "resource generation")
+	assert.Equal(t, http.MethodPost, recorded.Method, "method")
+	assert.Equal(t, "/v1/consumers/c1/resources", recorded.Path, "path")
+	assert.Equal(t, "ConfigMap", recorded.Body["kind"], "object is the body")
+
+	_, err = client.GetResource(ctx, "r1")
+	require.NoError(t, err, "GetResource()")
+	assert.Equal(t, "/v1/resources/r1", recorded.Path, "path")
+
+	raw, err := client.DoRaw(ctx, http.MethodGet, "/v1/resources/r1", "", nil)
+	require.NoError(t, err, "DoRaw()")
+	assert.Contains(t, string(raw), `"unknown": true`, "raw body keeps the unknown fields")
+
+	_, err = client.UpdateResource(ctx, "r1", object)
+	require.NoError(t, err, "UpdateResource()")
+	assert.Equal(t, http.MethodPut, recorded.Method, "method")
+	assert.Equal(t, "/v1/resources/r1", recorded.Path, "path")
+}
+
+func TestSendCloudEvent(t *testing.T) {
+	server, recorded := newTestServer(t, http.StatusOK, `{"message": "Manifest posted successfully."}`)
+	client := New(server.URL, server.Client())
+
+	evt := event.New()
+	evt.SetID("e1")
+	evt.SetSource("maestro")
+	evt.SetType("io.open-cluster-management.works.v1alpha1.manifests.spec.create_request")
+	require.NoError(t, evt.SetData("application/json", map[string]interface{}{"manifest": map[string]interface{}{}}))
+
+	resp, err := client.SendCloudEvent(context.Background(), &evt)
+	require.NoError(t, err, "SendCloudEvent()")
+	assert.Equal(t, maestropbv1.CloudEventSendResponse_OK, resp.Status, "status")
+	assert.Equal(t, "/v1/cloudevents", recorded.Path, "path")
+	assert.Equal(t, ContentTypeCloudEvents, recorded.ContentType, "content type")
+	assert.Equal(t, "e1", recorded.Body["id"], "event id")
+	assert.Empty(t, recorded.Accept, "accept")
+
+	_, err = client.WithHeader("Accept", ContentTypeJSON).SendCloudEvent(context.Background(), &evt)
+	require.NoError(t, err, "SendCloudEvent()")
+	assert.Equal(t, ContentTypeJSON, recorded.Accept, "accept")
+	assert.Equal(t, ContentTypeCloudEvents, recorded.ContentType, "content type is not overridden")
+}
+
+func TestError(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusNotFound, `{"code": 5, "message": "not found"}`)
+	client := New(server.URL, server.Client())
+
+	_, err := client.GetResource(context.Background(), "missing")
+	require.Error(t, err, "GetResource()")
+	assert.Equal(t, http.StatusNotFound, StatusCode(err), "status code")
+
+	restErr, ok := err.(*Error)
+	require.True(t, ok, "error type")
+	assert.Equal(t, http.MethodGet, restErr.Method, "method")
+	assert.JSONEq(t, `{"code": 5, "message": "not found"}`, string(restErr.Body), "body")
+	assert.Contains(t, err.Error(), "unexpected status code 404", "message")
+
+	st, err := restErr.Status()
+	require.NoError(t, err, "Status()")
+	assert.Equal(t, "not found", st.Message, "status message")
+	assert.Equal(t, codes.NotFound, restErr.Code(), "code")
+
+	invalid := &Error{Body: []byte("not found")}
+	_, err = invalid.Status()
+	assert.Error(t, err, "Status() of a non-JSON body")
+	assert.Equal(t, codes.Unknown, invalid.Code(), "code of a non-JSON body")
+
+	assert.Equal(t, 0, StatusCode(io.EOF), "status code of other errors")
+}
@@ -0,0 +1,186 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	workv1 "open-cluster-management.io/api/work/v1"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+)
+
+// condition types reported by the work-agent for the manifests
+const (
+	ConditionApplied     = workv1.WorkApplied
+	ConditionAvailable   = workv1.WorkAvailable
+	ConditionDegraded    = workv1.WorkDegraded
+	ConditionProgressing = workv1.WorkProgressing
+	// ConditionDeleted is reported by the work-agent once the manifests are removed from the cluster
+	ConditionDeleted = "Deleted"
+)
+
+// ResourceStatus is the typed form of the maestro resource status
+type ResourceStatus struct {
+	// SentTimestamp is the unix timestamp at which the status was sent by the agent
+	SentTimestamp int64 `json:"sentTimestamp"`
+	// ResourceGenerationID is the generation of the resource the status belongs to
+	ResourceGenerationID int64           `json:"resourceGenerationID"`
+	ReconcileStatus      ReconcileStatus `json:"reconcileStatus"`
+	// ContentStatus is the status feedback of the applied object
+	ContentStatus ContentStatus `json:"contentStatus"`
+	// Manifests are the statuses of the manifests of a manifest bundle, in the order of the bundle
+	Manifests []workv1.ManifestCondition `json:"manifests,omitempty"`
+}
+
+// ReconcileStatus is the agent status of the resource
+type ReconcileStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	CreationTimestamp  string             `json:"creationTimestamp,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ContentStatus is the status of the applied object, as returned by the status feedback
+type ContentStatus map[string]interface{}
+
+// FromResource decodes the status of the resource, an empty status is returned
+// when the agent has not reported yet
+func FromResource(r *maestropbv1.Resource) (*ResourceStatus, error) {
+	s := &ResourceStatus{}
+	if r.GetStatus() == nil {
+		return s, nil
+	}
+
+	data, err := protojson.Marshal(r.GetStatus())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal resource status: %v", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resource status: %v", err)
+	}
+
+	return s, nil
+}
+
+// FromJSON decodes the status of a resource returned by the REST API
+func FromJSON(data []byte) (*ResourceStatus, error) {
+	r := &maestropbv1.Resource{}
+	if err := protojson.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resource: %v", err)
+	}
+
+	return FromResource(r)
+}
+
+// Condition returns the condition of the given type, or nil when it is not reported
+func (s *ResourceStatus) Condition(conditionType string) *metav1.Condition {
+	return meta.FindStatusCondition(s.ReconcileStatus.Conditions, conditionType)
+}
+
+// HasCondition returns true when the condition of the given type has the given status
+func (s *ResourceStatus) HasCondition(conditionType string, status metav1.ConditionStatus) bool {
+	cond := s.Condition(conditionType)
+	return cond != nil && cond.Status == status
+}
+
+// Manifest returns the status of the manifest of the bundle with the given kind, namespace and name, or
+// nil when it is not reported
+func (s *ResourceStatus) Manifest(kind, namespace, name string) *workv1.ManifestCondition {
+	for i, m := range s.Manifests {
+		if m.ResourceMeta.Kind == kind && m.ResourceMeta.Namespace == namespace && m.ResourceMeta.Name == name {
+			return &s.Manifests[i]
+		}
+	}
+	return nil
+}
+
+// HasManifestCondition returns true when the manifest of the bundle reports the condition of the given
+// type with the given status
+func (s *ResourceStatus) HasManifestCondition(kind, namespace, name, conditionType string, status metav1.ConditionStatus) bool {
+	m := s.Manifest(kind, namespace, name)
+	if m == nil {
+		return false
+	}
+	cond := meta.FindStatusCondition(m.Conditions, conditionType)
+	return cond != nil && cond.Status == status
+}
+
+// Int64 returns the integer at the given path, the numbers are decoded as float64 from the proto struct
+func (c ContentStatus) Int64(fields ...string) (int64, bool) {
+	val, found, err := unstructured.NestedFieldNoCopy(c, fields...)
+	if !found || err != nil {
+		return 0, false
+	}
+
+	switch v := val.(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// String returns the string at the given path
+func (c ContentStatus) String(fields ...string) (string, bool) {
+	val, found, err := unstructured.NestedString(c, fields...)
+	if !found || err != nil {
+		return "", false
+	}
+	return val, true
+}
+
+// pollIntervalKey is the context key of the interval at which WaitFor reads the resource
+type pollIntervalKey struct{}
+
+// WithPollInterval returns a context that makes WaitFor read the resource at the given interval
+// instead of every 5 seconds
+func WithPollInterval(ctx context.Context, interval time.Duration) context.Context {
+	return context.WithValue(ctx, pollIntervalKey{}, interval)
+}
+
+// pollInterval returns the interval set with WithPollInterval, or 5 seconds
+func pollInterval(ctx context.Context) time.Duration {
+	if interval, ok := ctx.Value(pollIntervalKey{}).(time.Duration); ok && interval > 0 {
+		return interval
+	}
+	return time.Second * 5
+}
+
+// WaitFor reads the resource until its status matches, at the interval set with WithPollInterval
+func WaitFor(ctx context.Context, client maestropbv1.ResourceServiceClient, id string, match func(*maestropbv1.Resource, *ResourceStatus) bool, timeout time.Duration) (*ResourceStatus, error) {
+	var last *ResourceStatus
+	err := wait.For(func(context.Context) (done bool, err error) {
+		pbResource, err := client.Read(ctx, &maestropbv1.ResourceReadRequest{Id: id})
+		if err != nil {
+			// maestro may not be reachable yet
+			return false, nil
+		}
+
+		last, err = FromResource(pbResource)
+		if err != nil {
+			return false, err
+		}
+
+		return match(pbResource, last), nil
+	}, wait.WithTimeout(timeout), wait.WithInterval(pollInterval(ctx)))
+	if err != nil {
+		return last, fmt.Errorf("resource %s status does not match: %v", id, err)
+	}
+
+	return last, nil
+}
+
+// WaitForResourceCondition waits until the resource reports the condition with the given status
+// for its current generation
+func WaitForResourceCondition(ctx context.Context, client maestropbv1.ResourceServiceClient, id, conditionType string, status metav1.ConditionStatus, timeout time.Duration) (*ResourceStatus, error) {
+	return WaitFor(ctx, client, id, func(r *maestropbv1.Resource, s *ResourceStatus) bool {
+		return s.ResourceGenerationID >= r.GenerationId && s.HasCondition(conditionType, status)
+	}, timeout)
+}
@@ -0,0 +1,142 @@
+package status
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const resourceJSON = `
+{
+	"id": "11ddef7f-2816-4779-a25a-496660005cff",
+	"consumerId": "f7384ef8-37bf-4cb2-8682-9b2f00b6f457",
+	"generationId": "2",
+	"object": {
+		"apiVersion": "apps/v1",
+		"kind": "Deployment"
+	},
+	"status": {
+		"sentTimestamp": 1693560000,
+		"resourceGenerationID": 2,
+		"reconcileStatus": {
+			"observedGeneration": 2,
+			"conditions": [
+				{
+					"type": "Applied",
+					"status": "True",
+					"lastTransitionTime": "2023-09-01T17:31:00Z",
+					"reason": "AppliedManifestComplete",
+					"message": "Apply manifest complete"
+				},
+				{
+					"type": "Available",
+					"status": "False",
+					"lastTransitionTime": "2023-09-01T17:31:00Z",
+					"reason": "ResourceNotAvailable",
+					"message": ""
+				}
+			]
+		},
+		"contentStatus": {
+			"readyReplicas": 2,
+			"conditions": [
+				{
+					"type": "Available",
+					"status": "True"
+				}
+			],
+			"observedGeneration": 2,
+			"phase": "Running"
+		}
+	}
+}`
+
+func TestFromJSON(t *testing.T) {
+	s, err := FromJSON([]byte(resourceJSON))
+	require.NoError(t, err, "FromJSON()")
+
+	assert.Equal(t, int64(1693560000), s.SentTimestamp, "sent timestamp")
+	assert.Equal(t, int64(2), s.ResourceGenerationID, "resource generation")
+	assert.Equal(t, int64(2), s.ReconcileStatus.ObservedGeneration, "observed generation")
+
+	assert.True(t, s.HasCondition(ConditionApplied, metav1.ConditionTrue), "applied")
+	assert.True(t, s.HasCondition(ConditionAvailable, metav1.ConditionFalse), "available")
+	assert.False(t, s.HasCondition(ConditionAvailable, metav1.ConditionTrue), "available")
+	assert.Nil(t, s.Condition(ConditionDegraded), "degraded")
+	assert.Equal(t, "AppliedManifestComplete", s.Condition(ConditionApplied).Reason, "applied reason")
+
+	readyReplicas, ok := s.ContentStatus.Int64("readyReplicas")
+	assert.True(t, ok, "ready replicas found")
+	assert.Equal(t, int64(2), readyReplicas, "ready replicas")
+
+	_, ok = s.ContentStatus.Int64("replicas")
+	assert.False(t, ok, "replicas found")
+
+	phase, ok := s.ContentStatus.String("phase")
+	assert.True(t, ok, "phase found")
+	assert.Equal(t, "Running", phase, "phase")
+}
+
+func TestFromResource(t *testing.T) {
+	r := &maestropbv1.Resource{}
+	require.NoError(t, protojson.Unmarshal([]byte(resourceJSON), r), "Unmarshal()")
+
+	s, err := FromResource(r)
+	require.NoError(t, err, "FromResource()")
+	assert.Equal(t, int64(2), s.ResourceGenerationID, "resource generation")
+
+	// the status is empty until the agent reports it
+	empty, err := FromResource(&maestropbv1.Resource{Object: &structpb.Struct{}})
+	require.NoError(t, err, "FromResource()")
+	assert.Equal(t, &ResourceStatus{}, empty, "empty status")
+	assert.False(t, empty.HasCondition(ConditionApplied, metav1.ConditionTrue), "applied")
+
+	// the status is null in the REST response until the agent reports it
+	nullStatus, err := FromJSON([]byte(`{"id": "11ddef7f-2816-4779-a25a-496660005cff", "status": null}`))
+	require.NoError(t, err, "FromJSON()")
+	assert.Equal(t, &ResourceStatus{}, nullStatus, "null status")
+}
+
+func TestManifests(t *testing.T) {
+	s, err := FromJSON([]byte(`{
+	"id": "11ddef7f-2816-4779-a25a-496660005cff",
+	"status": {
+		"resourceGenerationID": 1,
+		"reconcileStatus": {
+			"conditions": [{"type": "Applied", "status": "False", "lastTransitionTime": "2023-09-01T17:31:00Z", "reason": "AppliedManifestWorkFailed", "message": ""}]
+		},
+		"manifests": [
+			{
+				"resourceMeta": {"ordinal": 0, "version": "v1", "kind": "ConfigMap", "name": "config", "namespace": "default"},
+				"conditions": [{"type": "Applied", "status": "True", "lastTransitionTime": "2023-09-01T17:31:00Z", "reason": "AppliedManifestComplete", "message": ""}]
+			},
+			{
+				"resourceMeta": {"ordinal": 1, "group": "example.com", "version": "v1", "kind": "Widget", "name": "widget", "namespace": "default"},
+				"conditions": [{"type": "Applied", "status": "False", "lastTransitionTime": "2023-09-01T17:31:00Z", "reason": "AppliedManifestFailed", "message": ""}]
+			}
+		]
+	}
+}`))
+	require.NoError(t, err, "FromJSON()")
+	require.Len(t, s.Manifests, 2, "manifests")
+
+	assert.True(t, s.HasCondition(ConditionApplied, metav1.ConditionFalse), "applied")
+	assert.True(t, s.HasManifestCondition("ConfigMap", "default", "config", ConditionApplied, metav1.ConditionTrue), "applied configmap")
+	assert.True(t, s.HasManifestCondition("Widget", "default", "widget", ConditionApplied, metav1.ConditionFalse), "failed widget")
+	assert.False(t, s.HasManifestCondition("Widget", "default", "widget", ConditionApplied, metav1.ConditionTrue), "applied widget")
+	assert.Equal(t, int32(1), s.Manifest("Widget", "default", "widget").ResourceMeta.Ordinal, "ordinal")
+	assert.Nil(t, s.Manifest("ConfigMap", "default", "unknown"), "unknown manifest")
+}
+
+func TestPollInterval(t *testing.T) {
+	assert.Equal(t, time.Second*5, pollInterval(context.Background()), "default interval")
+	assert.Equal(t, time.Millisecond*100, pollInterval(WithPollInterval(context.Background(), time.Millisecond*100)), "interval")
+	assert.Equal(t, time.Second*5, pollInterval(WithPollInterval(context.Background(), 0)), "zero interval")
+}
@@ -0,0 +1,148 @@
+package watchrecorder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+)
+
+// Disconnect is a watch stream that ended with an error while the recorder was running
+type Disconnect struct {
+	Time time.Time
+	Err  error
+}
+
+// Hub runs a single CloudEventsService.Watch, reopens it after backoff each time it ends, and fans its
+// events out to the recorders subscribed to it. Maestro hands each status event to one of the watch
+// streams and does not store the status until a stream receives it, so the suite keeps one stream open
+// for the whole run and the features subscribe to it instead of opening and stopping streams of their own
+type Hub struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	subscribers map[*Recorder]struct{}
+	disconnects []Disconnect
+	closed      bool
+}
+
+// StartHub opens the watch stream of the hub, it keeps watching until Stop is called
+func StartHub(ctx context.Context, client maestropbv1.CloudEventsServiceClient, req *maestropbv1.ResourceWatchRequest, backoff time.Duration) (*Hub, error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	stream, err := client.Watch(watchCtx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	h := &Hub{cancel: cancel, done: make(chan struct{}), subscribers: map[*Recorder]struct{}{}}
+	go h.run(watchCtx, client, req, stream, backoff)
+	return h, nil
+}
+
+func (h *Hub) run(ctx context.Context, client maestropbv1.CloudEventsServiceClient, req *maestropbv1.ResourceWatchRequest,
+	stream maestropbv1.CloudEventsService_WatchClient, backoff time.Duration) {
+	defer close(h.done)
+	defer h.closeSubscribers()
+	for {
+		err := receive(stream, h.broadcast)
+		if ctx.Err() != nil {
+			return
+		}
+		h.disconnected(Disconnect{Time: time.Now(), Err: err})
+
+		// the stream cannot be opened while the server is down, keep trying until it is back
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			if stream, err = client.Watch(ctx, req); err == nil {
+				break
+			}
+		}
+	}
+}
+
+// Subscribe returns a recorder of the events the hub receives from now on, stopping the recorder
+// unsubscribes it and keeps the stream of the hub open. The events received before are not replayed,
+// so subscribe before the request whose status is watched
+func (h *Hub) Subscribe() *Recorder {
+	r := &Recorder{done: make(chan struct{})}
+	r.cancel = func() {
+		h.mu.Lock()
+		delete(h.subscribers, r)
+		h.mu.Unlock()
+		r.close()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		r.close()
+		return r
+	}
+	h.subscribers[r] = struct{}{}
+	return r
+}
+
+// Disconnects returns the streams of the hub that ended before it was stopped
+func (h *Hub) Disconnects() []Disconnect {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Disconnect{}, h.disconnects...)
+}
+
+// Stop cancels the watch stream and waits for the hub to return, the subscribed recorders are done
+func (h *Hub) Stop(timeout time.Duration) error {
+	h.cancel()
+
+	select {
+	case <-h.done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("watch stream is not closed after %s", timeout)
+	}
+}
+
+func (h *Hub) broadcast(record Record) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for r := range h.subscribers {
+		r.add(record)
+	}
+}
+
+// disconnected records the stream that ended, the subscribed recorders see the disconnects of the
+// hub while they are subscribed
+func (h *Hub) disconnected(d Disconnect) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnects = append(h.disconnects, d)
+	for r := range h.subscribers {
+		r.addDisconnect(d)
+	}
+}
+
+func (h *Hub) closeSubscribers() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for r := range h.subscribers {
+		r.close()
+	}
+	h.subscribers = map[*Recorder]struct{}{}
+}
+
+// Disconnects returns the streams that ended before the recorder was stopped, a recorder started
+// with Start has at most one, a subscribed recorder has the ones of its hub since it subscribed
+func (r *Recorder) Disconnects() []Disconnect {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Disconnect{}, r.disconnects...)
+}
@@ -0,0 +1,311 @@
+package watchrecorder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cloudeventstypes "github.com/cloudevents/sdk-go/v2/types"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	workpayload "open-cluster-management.io/api/cloudevents/work/payload"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+)
+
+// Record is an event received from the watch stream
+type Record struct {
+	Received time.Time
+	Event    *cloudevents.Event
+}
+
+// ResourceID returns the resourceid extension of the event, or "" when it is not set
+func (r Record) ResourceID() string {
+	id, _ := cloudeventstypes.ToString(r.Event.Extensions()[cetypes.ExtensionResourceID])
+	return id
+}
+
+// ResourceVersion returns the resourceversion extension of the event
+func (r Record) ResourceVersion() (int64, error) {
+	val, ok := r.Event.Extensions()[cetypes.ExtensionResourceVersion]
+	if !ok {
+		return 0, fmt.Errorf("event %s has no resourceversion", r.Event.ID())
+	}
+
+	switch v := val.(type) {
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		i, err := cloudeventstypes.ToInteger(v)
+		return int64(i), err
+	}
+}
+
+// Conditions returns the conditions of the manifest status carried by the event
+func (r Record) Conditions() ([]metav1.Condition, error) {
+	manifestStatus := &workpayload.ManifestStatus{}
+	if err := json.Unmarshal(r.Event.Data(), manifestStatus); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event %s data as manifest status: %v", r.Event.ID(), err)
+	}
+	return manifestStatus.Conditions, nil
+}
+
+// HasCondition returns true when the event carries the condition with the given status
+func (r Record) HasCondition(conditionType string, status metav1.ConditionStatus) bool {
+	conditions, err := r.Conditions()
+	if err != nil {
+		return false
+	}
+	cond := meta.FindStatusCondition(conditions, conditionType)
+	return cond != nil && cond.Status == status
+}
+
+// key identifies the resource of the event, maestro does not set the resource id on the
+// watched events, they fall back to the source, which is the consumer id
+func (r Record) key() string {
+	if id := r.ResourceID(); id != "" {
+		return id
+	}
+	return r.Event.Source()
+}
+
+// Recorder runs CloudEventsService.Watch in the background and records every event, or records
+// the events of a Hub it is subscribed to
+type Recorder struct {
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu          sync.Mutex
+	records     []Record
+	disconnects []Disconnect
+	err         error
+}
+
+// Start opens the watch stream and records its events until Stop is called or the stream ends
+func Start(ctx context.Context, client maestropbv1.CloudEventsServiceClient, req *maestropbv1.ResourceWatchRequest) (*Recorder, error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	stream, err := client.Watch(watchCtx, req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &Recorder{cancel: cancel, done: make(chan struct{})}
+	go r.run(stream)
+	return r, nil
+}
+
+func (r *Recorder) run(stream maestropbv1.CloudEventsService_WatchClient) {
+	defer r.close()
+	r.finish(receive(stream, r.add))
+}
+
+// receive hands the events of the stream to handle until it ends and returns the error that ended it
+func receive(stream maestropbv1.CloudEventsService_WatchClient, handle func(Record)) error {
+	for {
+		pbEvt, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		evt, err := cepbv2.FromProto(pbEvt)
+		if err != nil {
+			return fmt.Errorf("failed to convert protobuf to cloudevent: %v", err)
+		}
+
+		handle(Record{Received: time.Now(), Event: evt})
+	}
+}
+
+func (r *Recorder) add(record Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+func (r *Recorder) addDisconnect(d Disconnect) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disconnects = append(r.disconnects, d)
+}
+
+// close marks the recorder done, it is called once the stream ended or the subscription is stopped
+func (r *Recorder) close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// finish records the error that ended the stream, the cancellation by Stop is not an error
+func (r *Recorder) finish(err error) {
+	if errors.Is(err, io.EOF) || status.Code(err) == codes.Canceled {
+		err = nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+	if err != nil {
+		r.disconnects = append(r.disconnects, Disconnect{Time: time.Now(), Err: err})
+	}
+}
+
+// Stop cancels the watch stream, or unsubscribes the recorder from its hub, waits for the recorder
+// to return and returns the error that ended the stream
+func (r *Recorder) Stop(timeout time.Duration) error {
+	r.cancel()
+
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		return fmt.Errorf("watch stream is not closed after %s", timeout)
+	}
+
+	return r.Err()
+}
+
+// Done is closed once the watch stream ended
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the error that ended the watch stream
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Records returns a copy of the recorded events
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record{}, r.records...)
+}
+
+// WaitFor waits until an event matches, it fails early when the watch stream ends
+func (r *Recorder) WaitFor(match func(Record) bool, timeout time.Duration) (Record, error) {
+	var found Record
+	err := wait.For(func(context.Context) (done bool, err error) {
+		for _, record := range r.Records() {
+			if match(record) {
+				found = record
+				return true, nil
+			}
+		}
+
+		select {
+		case <-r.done:
+			return false, fmt.Errorf("watch stream ended: %v", r.Err())
+		default:
+			return false, nil
+		}
+	}, wait.WithTimeout(timeout), wait.WithInterval(time.Millisecond*200))
+
+	return found, err
+}
+
+// FromSource returns the events sent by the source, maestro sets the consumer id as the source of the watched events
+func FromSource(records []Record, source string) []Record {
+	var filtered []Record
+	for _, record := range records {
+		if record.Event.Source() == source {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// CheckOrdered returns an error when the resourceversion of the events of a resource decreases
+func CheckOrdered(records []Record) error {
+	last := map[string]int64{}
+	for _, record := range records {
+		version, err := record.ResourceVersion()
+		if err != nil {
+			return err
+		}
+
+		key := record.key()
+		if prev, ok := last[key]; ok && version < prev {
+			return fmt.Errorf("event %s of %s has resourceversion %d after %d", record.Event.ID(), key, version, prev)
+		}
+		last[key] = version
+	}
+	return nil
+}
+
+// CheckNoDuplicates returns an error when an event is received twice, either with the same id or
+// with the same resourceversion and manifest status for the same resource. The agent resends the
+// status with a new id and a new lastTransitionTime, so the times are not compared
+func CheckNoDuplicates(records []Record) error {
+	ids := map[string]bool{}
+	contents := map[string]string{}
+	for _, record := range records {
+		if ids[record.Event.ID()] {
+			return fmt.Errorf("event %s is received twice", record.Event.ID())
+		}
+		ids[record.Event.ID()] = true
+
+		content, err := record.content()
+		if err != nil {
+			return err
+		}
+		if prev, ok := contents[content]; ok {
+			return fmt.Errorf("event %s duplicates event %s", record.Event.ID(), prev)
+		}
+		contents[content] = record.Event.ID()
+	}
+	return nil
+}
+
+// content identifies the status carried by the event by its resource, resourceversion and manifest
+// status, the lastTransitionTime of the conditions is cleared
+func (r Record) content() (string, error) {
+	version, err := r.ResourceVersion()
+	if err != nil {
+		return "", err
+	}
+
+	manifestStatus := &workpayload.ManifestStatus{}
+	if err := json.Unmarshal(r.Event.Data(), manifestStatus); err != nil {
+		return "", fmt.Errorf("failed to unmarshal event %s data as manifest status: %v", r.Event.ID(), err)
+	}
+	clearTransitionTimes(manifestStatus.Conditions)
+	if manifestStatus.Status != nil {
+		clearTransitionTimes(manifestStatus.Status.Conditions)
+	}
+
+	data, err := json.Marshal(manifestStatus)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d/%x", r.key(), version, sha256.Sum256(data)), nil
+}
+
+func clearTransitionTimes(conditions []metav1.Condition) {
+	for i := range conditions {
+		conditions[i].LastTransitionTime = metav1.Time{}
+	}
+}
+
+// ForeignEvents returns the events sent by a source other than the watched one, maestro sets the
+// consumer id as the source of the watched events
+func ForeignEvents(records []Record, source string) []Record {
+	var foreign []Record
+	for _, record := range records {
+		if record.Event.Source() != source {
+			foreign = append(foreign, record)
+		}
+	}
+	return foreign
+}
@@ -0,0 +1,292 @@
+package watchrecorder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	cepbv2 "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	maestropbv1 "github.com/kube-orchestra/maestro/proto/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cetypes "open-cluster-management.io/api/cloudevents/generic/types"
+	"sigs.k8s.io/e2e-framework/klient/wait"
+)
+
+// fakeCloudEventsServer sends the events to every watcher after sendDelay and keeps the stream open,
+// the first failedWatches streams end with Unavailable once the events are sent
+type fakeCloudEventsServer struct {
+	maestropbv1.UnimplementedCloudEventsServiceServer
+	events        []*cloudevents.Event
+	failedWatches int
+	sendDelay     time.Duration
+
+	mu      sync.Mutex
+	watches int
+}
+
+func (s *fakeCloudEventsServer) Watch(_ *maestropbv1.ResourceWatchRequest, srv maestropbv1.CloudEventsService_WatchServer) error {
+	s.mu.Lock()
+	s.watches++
+	fail := s.watches <= s.failedWatches
+	s.mu.Unlock()
+
+	time.Sleep(s.sendDelay)
+	for _, evt := range s.events {
+		pbEvt, err := cepbv2.ToProto(evt)
+		if err != nil {
+			return err
+		}
+		if err := srv.Send(pbEvt); err != nil {
+			return err
+		}
+	}
+
+	if fail {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+
+	<-srv.Context().Done()
+	return nil
+}
+
+func newEvent(resourceID, source string, version int64, data string) *cloudevents.Event {
+	evt := cloudevents.NewEvent()
+	evt.SetID(uuid.NewString())
+	evt.SetSource(source)
+	evt.SetType("io.open-cluster-management.works.v1alpha1.manifests.status.update_request")
+	if resourceID != "" {
+		evt.SetExtension(cetypes.ExtensionResourceID, resourceID)
+	}
+	evt.SetExtension(cetypes.ExtensionResourceVersion, version)
+	_ = evt.SetData(cloudevents.ApplicationJSON, []byte(data))
+	return &evt
+}
+
+func newRecord(evt *cloudevents.Event) Record {
+	return Record{Received: time.Now(), Event: evt}
+}
+
+func newTestClient(t *testing.T, events ...*cloudevents.Event) maestropbv1.CloudEventsServiceClient {
+	return newTestClientWithServer(t, &fakeCloudEventsServer{events: events})
+}
+
+func newTestClientWithServer(t *testing.T, fakeServer *fakeCloudEventsServer) maestropbv1.CloudEventsServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	maestropbv1.RegisterCloudEventsServiceServer(server, fakeServer)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	require.NoError(t, err, "Dial()")
+	t.Cleanup(func() { conn.Close() })
+
+	return maestropbv1.NewCloudEventsServiceClient(conn)
+}
+
+func TestRecorder(t *testing.T) {
+	client := newTestClient(t,
+		newEvent("", "consumer1", 1, `{"conditions": []}`),
+		newEvent("", "consumer1", 2, `{"conditions": []}`),
+	)
+
+	recorders := []*Recorder{}
+	for i := 0; i < 2; i++ {
+		r, err := Start(context.Background(), client, &maestropbv1.ResourceWatchRequest{Id: "r1"})
+		require.NoError(t, err, "Start()")
+		recorders = append(recorders, r)
+	}
+
+	for _, r := range recorders {
+		record, err := r.WaitFor(func(record Record) bool {
+			version, _ := record.ResourceVersion()
+			return version == 2
+		}, time.Second*5)
+		require.NoError(t, err, "WaitFor()")
+		assert.Equal(t, "consumer1", record.Event.Source(), "source")
+
+		require.NoError(t, r.Stop(time.Second*5), "Stop()")
+		records := r.Records()
+		assert.Len(t, records, 2, "records")
+		assert.NoError(t, CheckOrdered(records), "CheckOrdered()")
+		assert.NoError(t, CheckNoDuplicates(records), "CheckNoDuplicates()")
+		assert.Empty(t, ForeignEvents(records, "consumer1"), "ForeignEvents()")
+
+		select {
+		case <-r.Done():
+		default:
+			t.Fatal("recorder is not done after Stop()")
+		}
+	}
+}
+
+func TestWaitForStreamEnded(t *testing.T) {
+	client := newTestClient(t)
+	r, err := Start(context.Background(), client, &maestropbv1.ResourceWatchRequest{Id: "r1"})
+	require.NoError(t, err, "Start()")
+	require.NoError(t, r.Stop(time.Second*5), "Stop()")
+
+	_, err = r.WaitFor(func(Record) bool { return true }, time.Second*5)
+	assert.ErrorContains(t, err, "watch stream ended", "WaitFor()")
+}
+
+func TestStreamFailure(t *testing.T) {
+	client := newTestClientWithServer(t, &fakeCloudEventsServer{
+		events:        []*cloudevents.Event{newEvent("", "consumer1", 1, "{}")},
+		failedWatches: 1,
+	})
+
+	r, err := Start(context.Background(), client, &maestropbv1.ResourceWatchRequest{Id: "r1"})
+	require.NoError(t, err, "Start()")
+
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("recorder is not done after the stream failed")
+	}
+
+	assert.Equal(t, codes.Unavailable, status.Code(r.Err()), "Err()")
+	assert.Len(t, r.Records(), 1, "records")
+	require.Len(t, r.Disconnects(), 1, "disconnects")
+	assert.Equal(t, codes.Unavailable, status.Code(r.Disconnects()[0].Err), "disconnect error")
+}
+
+func TestHub(t *testing.T) {
+	client := newTestClientWithServer(t, &fakeCloudEventsServer{
+		events:        []*cloudevents.Event{newEvent("", "consumer1", 1, "{}")},
+		failedWatches: 2,
+		// the events are sent once the recorders are subscribed
+		sendDelay: time.Millisecond * 100,
+	})
+
+	h, err := StartHub(context.Background(), client, &maestropbv1.ResourceWatchRequest{}, time.Millisecond*10)
+	require.NoError(t, err, "StartHub()")
+	first := h.Subscribe()
+	second := h.Subscribe()
+
+	// each stream sends the event again, the third one stays open
+	err = wait.For(func(context.Context) (bool, error) {
+		return len(first.Records()) == 3, nil
+	}, wait.WithTimeout(time.Second*5), wait.WithInterval(time.Millisecond*50))
+	require.NoError(t, err, "records")
+
+	disconnects := h.Disconnects()
+	require.Len(t, disconnects, 2, "disconnects")
+	for _, d := range disconnects {
+		assert.Equal(t, codes.Unavailable, status.Code(d.Err), "disconnect error")
+	}
+	assert.Len(t, first.Disconnects(), 2, "disconnects of the subscriber")
+
+	// stopping a subscriber leaves the stream of the hub open for the others
+	require.NoError(t, first.Stop(time.Second*5), "Stop()")
+	select {
+	case <-first.Done():
+	default:
+		t.Fatal("subscriber is not done after Stop()")
+	}
+	assert.Len(t, second.Records(), 3, "records of the other subscriber")
+	select {
+	case <-second.Done():
+		t.Fatal("subscriber is done after another one stopped")
+	default:
+	}
+
+	require.NoError(t, h.Stop(time.Second*5), "Stop()")
+	select {
+	case <-second.Done():
+	default:
+		t.Fatal("subscriber is not done after the hub stopped")
+	}
+	_, err = h.Subscribe().WaitFor(func(Record) bool { return true }, time.Second*5)
+	assert.ErrorContains(t, err, "watch stream ended", "WaitFor() of a subscriber of a stopped hub")
+}
+
+func TestCheckOrdered(t *testing.T) {
+	ordered := []Record{
+		newRecord(newEvent("r1", "consumer1", 1, "{}")),
+		newRecord(newEvent("r2", "consumer1", 5, "{}")),
+		newRecord(newEvent("r1", "consumer1", 2, "{}")),
+		newRecord(newEvent("r1", "consumer1", 2, `{"a": 1}`)),
+	}
+	assert.NoError(t, CheckOrdered(ordered), "ordered")
+
+	unordered := append(ordered, newRecord(newEvent("r1", "consumer1", 1, "{}")))
+	assert.Error(t, CheckOrdered(unordered), "unordered")
+
+	// the events without resource id are ordered by source
+	unorderedSource := []Record{
+		newRecord(newEvent("", "consumer1", 3, "{}")),
+		newRecord(newEvent("", "consumer1", 2, "{}")),
+	}
+	assert.Error(t, CheckOrdered(unorderedSource), "unordered source")
+}
+
+func TestCheckNoDuplicates(t *testing.T) {
+	evt := newEvent("r1", "consumer1", 1, "{}")
+	assert.Error(t, CheckNoDuplicates([]Record{newRecord(evt), newRecord(evt)}), "same id")
+
+	applied := `{"conditions": [{"type": "Applied", "status": "True", "reason": "AppliedManifestComplete", "message": "", "lastTransitionTime": "%s"}]}`
+	assert.Error(t, CheckNoDuplicates([]Record{
+		newRecord(newEvent("", "consumer1", 1, fmt.Sprintf(applied, "2023-09-01T17:31:00Z"))),
+		newRecord(newEvent("", "consumer1", 1, fmt.Sprintf(applied, "2023-09-01T17:32:00Z"))),
+	}), "same status resent")
+
+	assert.Error(t, CheckNoDuplicates([]Record{
+		newRecord(newEvent("", "consumer1", 1, "{}")),
+		newRecord(newEvent("", "consumer1", 1, "[]")),
+	}), "invalid status")
+
+	replicas := `{"conditions": [], "status": {"resourceMeta": {}, "conditions": [], "statusFeedback": {"values": [{"name": "ReadyReplicas", "fieldValue": {"type": "Integer", "integer": %d}}]}}}`
+	assert.NoError(t, CheckNoDuplicates([]Record{
+		newRecord(newEvent("", "consumer1", 1, fmt.Sprintf(applied, "2023-09-01T17:31:00Z"))),
+		newRecord(newEvent("", "consumer1", 2, fmt.Sprintf(applied, "2023-09-01T17:31:00Z"))),
+		newRecord(newEvent("", "consumer2", 1, fmt.Sprintf(applied, "2023-09-01T17:31:00Z"))),
+		newRecord(newEvent("", "consumer1", 1, fmt.Sprintf(replicas, 0))),
+		newRecord(newEvent("", "consumer1", 1, fmt.Sprintf(replicas, 1))),
+	}), "distinct")
+}
+
+func TestConditions(t *testing.T) {
+	record := newRecord(newEvent("", "consumer1", 1, `{"conditions": [{"type": "Deleted", "status": "True", "reason": "ManifestsDeleted", "message": "", "lastTransitionTime": "2023-09-01T17:31:00Z"}]}`))
+	assert.True(t, record.HasCondition("Deleted", metav1.ConditionTrue), "deleted")
+	assert.False(t, record.HasCondition("Applied", metav1.ConditionTrue), "applied")
+
+	invalid := newRecord(newEvent("", "consumer1", 1, `[]`))
+	_, err := invalid.Conditions()
+	assert.Error(t, err, "Conditions()")
+	assert.False(t, invalid.HasCondition("Deleted", metav1.ConditionTrue), "deleted")
+}
+
+func TestFromSource(t *testing.T) {
+	records := []Record{
+		newRecord(newEvent("r1", "consumer1", 1, "{}")),
+		newRecord(newEvent("r2", "consumer1", 1, "{}")),
+		newRecord(newEvent("", "consumer1", 1, "{}")),
+		newRecord(newEvent("", "consumer2", 1, "{}")),
+	}
+	assert.Len(t, FromSource(records, "consumer1"), 3, "consumer1 events")
+	assert.Len(t, FromSource(records, "consumer2"), 1, "consumer2 events")
+
+	foreign := ForeignEvents(records, "consumer1")
+	require.Len(t, foreign, 1, "foreign events")
+	assert.Equal(t, "consumer2", foreign[0].Event.Source(), "foreign source")
+	assert.Empty(t, ForeignEvents(records[:3], "consumer1"), "events of the watched source")
+}
@@ -0,0 +1,109 @@
+package workview
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	workv1 "open-cluster-management.io/api/work/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// View is the agent side view of a maestro resource. The work-agent names the ManifestWork after the
+// resource id and keeps it in the namespace named after the consumer id, and the AppliedManifestWork
+// of the resource refers to the ManifestWork by name
+type View struct {
+	ResourceID string
+	// ManifestWork is nil when the agent does not keep it in the cluster, the agent that receives
+	// the specs over cloudevents keeps the ManifestWorks in memory
+	ManifestWork *workv1.ManifestWork
+	// AppliedManifestWork is nil until the agent applied the resource, or after it removed the resource
+	AppliedManifestWork *workv1.AppliedManifestWork
+}
+
+// List returns the views of the resources of the consumer, keyed by resource id. The AppliedManifestWorks
+// are cluster scoped, so only the ones of the ManifestWorks of the consumer and of the given resource ids
+// are returned. The reader needs the workv1 types in its scheme
+func List(ctx context.Context, reader client.Reader, consumerID string, resourceIDs ...string) (map[string]*View, error) {
+	views := map[string]*View{}
+	for _, id := range resourceIDs {
+		views[id] = &View{ResourceID: id}
+	}
+
+	var works workv1.ManifestWorkList
+	if err := reader.List(ctx, &works, client.InNamespace(consumerID)); err != nil && !meta.IsNoMatchError(err) {
+		return nil, fmt.Errorf("failed to list the manifestworks of consumer %s: %v", consumerID, err)
+	}
+	for i := range works.Items {
+		work := &works.Items[i]
+		views[work.Name] = &View{ResourceID: work.Name, ManifestWork: work}
+	}
+
+	var appliedWorks workv1.AppliedManifestWorkList
+	if err := reader.List(ctx, &appliedWorks); err != nil {
+		return nil, fmt.Errorf("failed to list the appliedmanifestworks: %v", err)
+	}
+	for i := range appliedWorks.Items {
+		appliedWork := &appliedWorks.Items[i]
+		if view, ok := views[appliedWork.Spec.ManifestWorkName]; ok {
+			view.AppliedManifestWork = appliedWork
+		}
+	}
+
+	// the ids the agent knows nothing about are dropped
+	for id, view := range views {
+		if view.ManifestWork == nil && view.AppliedManifestWork == nil {
+			delete(views, id)
+		}
+	}
+	return views, nil
+}
+
+// Get returns the view of the resource, or nil when the agent keeps nothing for it
+func Get(ctx context.Context, reader client.Reader, consumerID, resourceID string) (*View, error) {
+	views, err := List(ctx, reader, consumerID, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	return views[resourceID], nil
+}
+
+// AppliedResources returns the resources the agent applied for the resource
+func (v *View) AppliedResources() []workv1.AppliedManifestResourceMeta {
+	if v.AppliedManifestWork == nil {
+		return nil
+	}
+	return v.AppliedManifestWork.Status.AppliedResources
+}
+
+// Condition returns the condition of the ManifestWork of the given type, or nil when it is not reported
+func (v *View) Condition(conditionType string) *metav1.Condition {
+	if v.ManifestWork == nil {
+		return nil
+	}
+	return meta.FindStatusCondition(v.ManifestWork.Status.Conditions, conditionType)
+}
+
+// ManifestConditions returns the conditions of the manifests of the ManifestWork
+func (v *View) ManifestConditions() []workv1.ManifestCondition {
+	if v.ManifestWork == nil {
+		return nil
+	}
+	return v.ManifestWork.Status.ResourceStatus.Manifests
+}
+
+// Owns returns true when the object is owned by the AppliedManifestWork of the resource, the agent
+// sets the owner reference on the objects it applied so they are removed with the AppliedManifestWork
+func (v *View) Owns(obj metav1.Object) bool {
+	if v.AppliedManifestWork == nil {
+		return false
+	}
+
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == v.AppliedManifestWork.UID {
+			return true
+		}
+	}
+	return false
+}
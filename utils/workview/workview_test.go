@@ -0,0 +1,84 @@
+package workview
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	workv1 "open-cluster-management.io/api/work/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	consumerID = "f7384ef8-37bf-4cb2-8682-9b2f00b6f457"
+	resourceID = "11ddef7f-2816-4779-a25a-496660005cff"
+)
+
+func newAppliedManifestWork(workName string) *workv1.AppliedManifestWork {
+	return &workv1.AppliedManifestWork{
+		ObjectMeta: metav1.ObjectMeta{Name: "hubhash-" + workName, UID: types.UID("applied-" + workName)},
+		Spec:       workv1.AppliedManifestWorkSpec{HubHash: "hubhash", ManifestWorkName: workName},
+		Status: workv1.AppliedManifestWorkStatus{
+			AppliedResources: []workv1.AppliedManifestResourceMeta{{
+				ResourceIdentifier: workv1.ResourceIdentifier{Group: "apps", Resource: "deployments", Name: "nginx", Namespace: "default"},
+				Version:            "v1",
+			}},
+		},
+	}
+}
+
+func newFakeReader(t *testing.T, objs ...runtime.Object) *fake.ClientBuilder {
+	scheme := runtime.NewScheme()
+	require.NoError(t, workv1.Install(scheme))
+	return fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...)
+}
+
+func TestList(t *testing.T) {
+	work := &workv1.ManifestWork{
+		ObjectMeta: metav1.ObjectMeta{Name: resourceID, Namespace: consumerID},
+		Status: workv1.ManifestWorkStatus{
+			Conditions: []metav1.Condition{{Type: workv1.WorkApplied, Status: metav1.ConditionTrue}},
+		},
+	}
+	other := &workv1.ManifestWork{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "another-consumer"}}
+	reader := newFakeReader(t, work, other, newAppliedManifestWork(resourceID), newAppliedManifestWork("other"),
+		newAppliedManifestWork("in-memory")).Build()
+
+	views, err := List(context.Background(), reader, consumerID, "in-memory", "unknown")
+	require.NoError(t, err)
+	require.Len(t, views, 2, "the resources of the consumer and the given ids the agent knows")
+
+	view := views[resourceID]
+	require.NotNil(t, view.ManifestWork)
+	require.NotNil(t, view.AppliedManifestWork)
+	assert.Equal(t, metav1.ConditionTrue, view.Condition(workv1.WorkApplied).Status)
+	assert.Len(t, view.AppliedResources(), 1)
+
+	inMemory := views["in-memory"]
+	assert.Nil(t, inMemory.ManifestWork, "the manifestwork is kept in memory")
+	assert.Nil(t, inMemory.Condition(workv1.WorkApplied))
+	assert.Equal(t, "nginx", inMemory.AppliedResources()[0].Name)
+}
+
+func TestGet(t *testing.T) {
+	reader := newFakeReader(t, newAppliedManifestWork(resourceID)).Build()
+
+	view, err := Get(context.Background(), reader, consumerID, resourceID)
+	require.NoError(t, err)
+	require.NotNil(t, view)
+
+	owned := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		OwnerReferences: []metav1.OwnerReference{{Kind: "AppliedManifestWork", UID: types.UID("applied-" + resourceID)}},
+	}}
+	assert.True(t, view.Owns(owned))
+	assert.False(t, view.Owns(&appsv1.Deployment{}))
+
+	view, err = Get(context.Background(), reader, consumerID, "unknown")
+	require.NoError(t, err)
+	assert.Nil(t, view, "the agent keeps nothing for the resource")
+}